@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"neo4j-starter/n4j"
+	"neo4j-starter/resolve/resolvetest"
+)
+
+// Generates a DataGen dataset as CSV files for `neo4j-admin database import`.
+// With the same seed, count and reference time this is the same data that the
+// benchmarks insert through CreateEntities.
+func run() error {
+	var (
+		entityCount = flag.Int("entities", 1_000_000, "number of entities to generate")
+		seed        = flag.Int64("seed", 1, "DataGen seed")
+		out         = flag.String("out", "./import", "output directory")
+		database    = flag.String("database", "neo4j", "database to import into")
+		now         = flag.String("now", resolvetest.DefaultNow.Format(time.RFC3339), "DataGen reference time, when histories end")
+	)
+	flag.Parse()
+
+	genNow, err := time.Parse(time.RFC3339, *now)
+	if err != nil {
+		return fmt.Errorf("parse now: %w", err)
+	}
+
+	start := time.Now()
+
+	w, err := n4j.NewAdminImportWriter(*out)
+	if err != nil {
+		return err
+	}
+
+	// entities are written as they are generated, so aren't kept for lookups
+	gen := resolvetest.NewDataGen(*seed, resolvetest.WithNow(genNow), resolvetest.WithoutLookups())
+	for i := 0; i < *entityCount; i++ {
+		if err := w.Write(gen.NewEntity()); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("wrote %d entities to %s, time taken: %v", *entityCount, *out, time.Since(start))
+	fmt.Println("neo4j-admin " + strings.Join(w.ImportArgs(*database), " "))
+
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(fmt.Errorf("failed to run: %w", err))
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"neo4j-starter/loadtest"
+	"neo4j-starter/n4j"
+	"neo4j-starter/resolve/resolvetest"
+)
+
+const usage = `usage:
+  loadtest run [flags]                       drive lookups against the local server
+  loadtest compare [flags] base.json new.json diff two reports, failing on regressions
+  loadtest strategies [flags]                diff two lookup strategies on the same lookups`
+
+// Runs lookups of a DataGen dataset against the local server at a target rate
+// or concurrency, writing a JSON report, compares two reports, or compares two
+// lookup strategies on the same lookups. With the
+// same seed, count and reference time the lookups are of the entities that the
+// benchmarks and admincsv generate.
+func run() error {
+	if len(os.Args) < 2 {
+		return errors.New(usage)
+	}
+	switch os.Args[1] {
+	case "run":
+		return runLoad(os.Args[2:])
+	case "compare":
+		return compare(os.Args[2:])
+	case "strategies":
+		return compareStrategies(os.Args[2:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", os.Args[1], usage)
+	}
+}
+
+func runLoad(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var (
+		entityCount = fs.Int("entities", 100_000, "number of DataGen entities in the database")
+		seed        = fs.Int64("seed", 1, "DataGen seed")
+		now         = fs.String("now", resolvetest.DefaultNow.Format(time.RFC3339), "DataGen reference time, when histories end")
+		insert      = fs.Bool("insert", false, "replace the entities in the database with the generated ones first")
+		lookupCount = fs.Int("lookups", 10_000, "number of distinct lookups to cycle through")
+		unknown     = fs.Float64("unknown", 0.1, "share of lookups of unknown identifiers")
+		rate        = fs.Float64("rate", 0, "target requests per second, 0 for as fast as possible")
+		concurrency = fs.Int("concurrency", 10, "requests in flight at most")
+		duration    = fs.Duration("duration", 30*time.Second, "how long to send requests for")
+		batchSize   = fs.Int("batch", 1, "lookups per request")
+		strategy    = fs.String("strategy", n4j.UnwindLookups.Name(), "lookup strategy")
+		label       = fs.String("label", "", "label of the report, e.g. the cluster size")
+		out         = fs.String("out", "", "file to write the JSON report to")
+	)
+	_ = fs.Parse(args)
+
+	genNow, err := time.Parse(time.RFC3339, *now)
+	if err != nil {
+		return fmt.Errorf("parse now: %w", err)
+	}
+	s, err := n4j.LookupStrategyByName(*strategy)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	driver, cleanup, err := n4j.Connect(ctx)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	a := n4j.NewAdapter(driver, n4j.WithLookupStrategy(s))
+
+	gen := resolvetest.NewDataGen(*seed, resolvetest.WithNow(genNow))
+	if err := generate(ctx, a, gen, *entityCount, *insert, s == n4j.Projection); err != nil {
+		return err
+	}
+	lookups := gen.NewLookupsWith(*lookupCount, resolvetest.LookupConfig{Unknown: *unknown})
+
+	log.Printf("sending lookups for %v", *duration)
+	report, err := loadtest.Run(ctx, a, lookups, loadtest.Config{
+		Rate:        *rate,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		BatchSize:   *batchSize,
+	})
+	if err != nil {
+		return err
+	}
+	report.Label = *label
+	fmt.Println(report)
+
+	if *out == "" {
+		return nil
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+	if err := report.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// generate generates the entities, replacing the ones in the database if
+// insert, and rebuilds the lookup projection if project.
+func generate(ctx context.Context, a *n4j.Adapter, gen *resolvetest.DataGen, n int, insert, project bool) error {
+	entities := gen.NewEntities(n)
+	if insert {
+		if err := a.Cleanup(ctx); err != nil {
+			return err
+		}
+		stats, err := a.CreateEntitiesParallel(ctx, entities, n4j.BulkConfig{Workers: 8, BatchSize: 1000})
+		if err != nil {
+			return err
+		}
+		log.Printf("inserted %d entities, time taken: %v", stats.Entities, stats.Elapsed)
+	}
+	if insert && project {
+		stats, err := a.BuildLookupProjection(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("projected %d entities to %d lookups, time taken: %v", stats.Entities, stats.Projections, stats.Elapsed)
+	}
+	return nil
+}
+
+func compare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	var (
+		latency    = fs.Float64("latency", 0.1, "largest relative increase of p50, p95 and p99")
+		throughput = fs.Float64("throughput", 0.1, "largest relative decrease of throughput")
+		errorRate  = fs.Float64("error-rate", 0.01, "largest absolute increase of the error rate")
+		matchRate  = fs.Float64("match-rate", 0.01, "largest absolute decrease of the match rate")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New(usage)
+	}
+
+	base, err := readReport(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	next, err := readReport(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	c := loadtest.Compare(base, next, loadtest.Thresholds{
+		Latency:    *latency,
+		Throughput: *throughput,
+		ErrorRate:  *errorRate,
+		MatchRate:  *matchRate,
+	})
+	fmt.Println(c)
+	if regressions := c.Regressions(); len(regressions) > 0 {
+		return fmt.Errorf("%d regressions", len(regressions))
+	}
+	return nil
+}
+
+func compareStrategies(args []string) error {
+	fs := flag.NewFlagSet("strategies", flag.ExitOnError)
+	var (
+		entityCount = fs.Int("entities", 100_000, "number of DataGen entities in the database")
+		seed        = fs.Int64("seed", 1, "DataGen seed")
+		now         = fs.String("now", resolvetest.DefaultNow.Format(time.RFC3339), "DataGen reference time, when histories end")
+		insert      = fs.Bool("insert", false, "replace the entities in the database with the generated ones, and build the lookup projection, first")
+		lookupCount = fs.Int("lookups", 10_000, "number of lookups")
+		unknown     = fs.Float64("unknown", 0.1, "share of lookups of unknown identifiers")
+		base        = fs.String("base", n4j.UnwindLookups.Name(), "lookup strategy to compare against")
+		other       = fs.String("other", n4j.TwoPhase.Name(), "lookup strategy to compare")
+		batchSize   = fs.Int("batch", 1000, "lookups per query")
+	)
+	_ = fs.Parse(args)
+
+	genNow, err := time.Parse(time.RFC3339, *now)
+	if err != nil {
+		return fmt.Errorf("parse now: %w", err)
+	}
+	baseStrategy, err := n4j.LookupStrategyByName(*base)
+	if err != nil {
+		return err
+	}
+	otherStrategy, err := n4j.LookupStrategyByName(*other)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	driver, cleanup, err := n4j.Connect(ctx)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	a := n4j.NewAdapter(driver)
+
+	gen := resolvetest.NewDataGen(*seed, resolvetest.WithNow(genNow))
+	project := baseStrategy == n4j.Projection || otherStrategy == n4j.Projection
+	if err := generate(ctx, a, gen, *entityCount, *insert, project); err != nil {
+		return err
+	}
+	lookups := gen.NewLookupsWith(*lookupCount, resolvetest.LookupConfig{Unknown: *unknown})
+
+	c, err := a.CompareLookupStrategies(ctx, lookups, baseStrategy, otherStrategy, n4j.CompareConfig{BatchSize: *batchSize})
+	if err != nil {
+		return err
+	}
+	fmt.Println(c)
+	if c.DiffCount > 0 {
+		return fmt.Errorf("%d lookups with different results", c.DiffCount)
+	}
+	return nil
+}
+
+func readReport(path string) (*loadtest.Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open report: %w", err)
+	}
+	defer f.Close()
+	return loadtest.ReadReport(f)
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(fmt.Errorf("failed to run: %w", err))
+	}
+}
@@ -0,0 +1,119 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// loadCheckpoint returns the checkpoint for source, or a fresh one if there is
+// no checkpoint file yet.
+func (im *Importer) loadCheckpoint(source string) (Checkpoint, error) {
+	cp := Checkpoint{Source: source}
+	if im.cfg.CheckpointPath == "" {
+		return cp, nil
+	}
+
+	b, err := os.ReadFile(im.cfg.CheckpointPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, fmt.Errorf("read checkpoint: %w", err)
+	}
+
+	var saved Checkpoint
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return cp, fmt.Errorf("unmarshal checkpoint: %w", err)
+	}
+	if saved.Source != source {
+		return cp, fmt.Errorf("checkpoint is for %q, not %q", saved.Source, source)
+	}
+
+	return saved, nil
+}
+
+// saveCheckpoint writes to a temp file and renames it, so that a crash while
+// writing never leaves a truncated checkpoint behind.
+func (im *Importer) saveCheckpoint(cp Checkpoint) error {
+	if im.cfg.CheckpointPath == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(cp, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(im.cfg.CheckpointPath), ".checkpoint-*")
+	if err != nil {
+		return fmt.Errorf("create checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), im.cfg.CheckpointPath); err != nil {
+		return fmt.Errorf("rename checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+type rejectRecord struct {
+	Offset int64  `json:"offset"`
+	Error  string `json:"error"`
+	Raw    string `json:"raw"`
+}
+
+type rejectWriter struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+// openRejects opens the rejects file in append mode so that rejects from
+// previous runs of a resumed import are kept.
+func (im *Importer) openRejects() (*rejectWriter, error) {
+	if im.cfg.RejectPath == "" {
+		return &rejectWriter{}, nil
+	}
+	f, err := os.OpenFile(im.cfg.RejectPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open rejects: %w", err)
+	}
+	return &rejectWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (w *rejectWriter) write(rowErr *RowError) error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.enc.Encode(rejectRecord{
+		Offset: rowErr.Offset,
+		Error:  rowErr.Err.Error(),
+		Raw:    rowErr.Raw,
+	})
+	if err != nil {
+		return fmt.Errorf("write reject: %w", err)
+	}
+	return nil
+}
+
+func (w *rejectWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,270 @@
+// Package ingest streams entities from CSV or JSONL files into a store in
+// batches, checkpointing after each committed batch so that a failed import
+// can be resumed.
+package ingest
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"neo4j-starter/resolve"
+)
+
+const defaultBatchSize = 1000
+
+// Writer is implemented by n4j.Adapter.
+type Writer interface {
+	CreateEntities(ctx context.Context, entities []*resolve.Entity) error
+}
+
+type Config struct {
+	// BatchSize is the number of entities written per transaction, defaults to
+	// 1000.
+	BatchSize int
+	// CheckpointPath is where progress is recorded after each batch. If the file
+	// exists for the same source, the import resumes from it. Optional.
+	CheckpointPath string
+	// RejectPath is where malformed rows are appended as JSONL. If empty,
+	// malformed rows are only counted.
+	RejectPath string
+	// OnProgress is called after each committed batch, defaults to logging.
+	OnProgress func(Progress)
+}
+
+// Checkpoint records how far an import has got. Offset is the byte position in
+// the source file up to which all entities have been committed. RejectedOffset
+// is the position up to which malformed rows have been written to the rejects
+// file and counted in Rejected, which is past Offset while entities read
+// before them are not committed yet, so a resumed import doesn't reject them
+// again.
+type Checkpoint struct {
+	Source         string    `json:"source"`
+	Offset         int64     `json:"offset"`
+	Entities       int       `json:"entities"`
+	Rejected       int       `json:"rejected"`
+	RejectedOffset int64     `json:"rejected_offset,omitempty"`
+	Batches        int       `json:"batches"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type Progress struct {
+	Batches  int
+	Entities int
+	Rejected int
+	Offset   int64
+	Elapsed  time.Duration
+	// BatchDuration is the time taken to write the last batch.
+	BatchDuration time.Duration
+}
+
+// Rate returns the throughput in entities per second for this run.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Entities) / p.Elapsed.Seconds()
+}
+
+type Stats struct {
+	Entities int
+	Rejected int
+	Batches  int
+	Elapsed  time.Duration
+	// Resumed is true if the import continued from a checkpoint.
+	Resumed bool
+}
+
+func (s Stats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Entities) / s.Elapsed.Seconds()
+}
+
+type Importer struct {
+	writer Writer
+	cfg    Config
+}
+
+func NewImporter(writer Writer, cfg Config) *Importer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.OnProgress == nil {
+		cfg.OnProgress = logProgress
+	}
+	return &Importer{
+		writer: writer,
+		cfg:    cfg,
+	}
+}
+
+func logProgress(p Progress) {
+	log.Printf("imported batch %d: entities: %d, rejected: %d, batch: %v, rate: %.0f/s",
+		p.Batches, p.Entities, p.Rejected, p.BatchDuration.Round(time.Millisecond), p.Rate())
+}
+
+// ImportFile imports the file at path, choosing the format on the extension
+// (.csv, .jsonl or .ndjson). If a checkpoint for the same file exists, reading
+// continues from the checkpointed offset.
+func (im *Importer) ImportFile(ctx context.Context, path string) (Stats, error) {
+	cp, err := im.loadCheckpoint(path)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Stats{}, fmt.Errorf("open source: %w", err)
+	}
+	defer f.Close()
+
+	reader, err := openReader(f, path, cp.Offset)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats, err := im.Import(ctx, reader, cp)
+	stats.Resumed = cp.Offset > 0
+	return stats, err
+}
+
+func openReader(f *os.File, path string, offset int64) (EntityReader, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".jsonl", ".ndjson":
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seek: %w", err)
+		}
+		return NewJSONLReader(f, offset), nil
+	case ".csv":
+		if offset == 0 {
+			return NewCSVReader(f)
+		}
+		header, err := csv.NewReader(f).Read()
+		if err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seek: %w", err)
+		}
+		return newCSVReaderAt(f, offset, header)
+	default:
+		return nil, fmt.Errorf("unsupported file extension %q", ext)
+	}
+}
+
+// Import reads all entities from reader and writes them in batches. cp is the
+// checkpoint the reader was positioned at, use the zero value for a fresh
+// import.
+func (im *Importer) Import(ctx context.Context, reader EntityReader, cp Checkpoint) (Stats, error) {
+	var stats Stats
+
+	rejects, err := im.openRejects()
+	if err != nil {
+		return stats, err
+	}
+	defer rejects.Close()
+
+	start := time.Now()
+	rejectedBefore := cp.Rejected
+	batch := make([]*resolve.Entity, 0, im.cfg.BatchSize)
+	offset := cp.Offset
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		batchStart := time.Now()
+		if err := im.writer.CreateEntities(ctx, batch); err != nil {
+			return fmt.Errorf("write batch at offset %d: %w", cp.Offset, err)
+		}
+		batchDuration := time.Since(batchStart)
+
+		stats.Entities += len(batch)
+		stats.Batches++
+		stats.Elapsed = time.Since(start)
+
+		cp.Offset = offset
+		cp.Entities += len(batch)
+		cp.Rejected = rejectedBefore + stats.Rejected
+		cp.Batches++
+		cp.UpdatedAt = time.Now().UTC()
+		if err := im.saveCheckpoint(cp); err != nil {
+			return err
+		}
+
+		im.cfg.OnProgress(Progress{
+			Batches:       stats.Batches,
+			Entities:      stats.Entities,
+			Rejected:      stats.Rejected,
+			Offset:        offset,
+			Elapsed:       stats.Elapsed,
+			BatchDuration: batchDuration,
+		})
+
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		entity, next, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			offset = next
+			if next <= cp.RejectedOffset {
+				// written and counted before the import was resumed
+				continue
+			}
+			stats.Rejected++
+			if err := rejects.write(rowErr); err != nil {
+				return stats, err
+			}
+
+			// checkpoint the reject, and the offset if no entities are pending
+			cp.Rejected = rejectedBefore + stats.Rejected
+			cp.RejectedOffset = next
+			if len(batch) == 0 {
+				cp.Offset = offset
+			}
+			cp.UpdatedAt = time.Now().UTC()
+			if err := im.saveCheckpoint(cp); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		batch = append(batch, entity)
+		offset = next
+
+		if len(batch) >= im.cfg.BatchSize {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return stats, err
+	}
+	stats.Elapsed = time.Since(start)
+
+	return stats, nil
+}
@@ -0,0 +1,382 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+	"neo4j-starter/resolve/resolvetest"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWriter records written entities and can fail on a given batch.
+type fakeWriter struct {
+	entities []*resolve.Entity
+	batches  int
+	failAt   int // 1-based batch to fail, 0 to never fail
+}
+
+func (w *fakeWriter) CreateEntities(_ context.Context, entities []*resolve.Entity) error {
+	w.batches++
+	if w.batches == w.failAt {
+		return errors.New("connection reset")
+	}
+	w.entities = append(w.entities, entities...)
+	return nil
+}
+
+func writeJSONL(t *testing.T, path string, entities []*resolve.Entity, extraLines ...string) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for i, e := range entities {
+		require.NoError(t, enc.Encode(e))
+		if i < len(extraLines) {
+			_, err := f.WriteString(extraLines[i] + "\n")
+			require.NoError(t, err)
+		}
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var n int
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		n++
+	}
+	require.NoError(t, s.Err())
+	return n
+}
+
+func TestImporter_ImportFile_JSONL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	gen := resolvetest.NewDataGen(1)
+	entities := gen.NewEntities(25)
+
+	source := filepath.Join(dir, "entities.jsonl")
+	writeJSONL(t, source, entities, `{"ID": "not-a-uuid"}`, `{broken`)
+
+	w := &fakeWriter{}
+	im := NewImporter(w, Config{
+		BatchSize:  10,
+		RejectPath: filepath.Join(dir, "rejects.jsonl"),
+		OnProgress: func(Progress) {},
+	})
+
+	stats, err := im.ImportFile(ctx, source)
+	require.NoError(t, err)
+	require.Equal(t, 25, stats.Entities)
+	require.Equal(t, 2, stats.Rejected)
+	require.Equal(t, 3, stats.Batches)
+	require.False(t, stats.Resumed)
+
+	require.Len(t, w.entities, 25)
+	for i := range entities {
+		require.Equal(t, entities[i].ID, w.entities[i].ID)
+		require.Equal(t, entities[i].Name, w.entities[i].Name)
+	}
+	require.Equal(t, 2, countLines(t, filepath.Join(dir, "rejects.jsonl")))
+}
+
+func TestImporter_ImportFile_Resume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	gen := resolvetest.NewDataGen(1)
+	entities := gen.NewEntities(35)
+
+	source := filepath.Join(dir, "entities.jsonl")
+	writeJSONL(t, source, entities)
+
+	cfg := Config{
+		BatchSize:      10,
+		CheckpointPath: filepath.Join(dir, "checkpoint.json"),
+		OnProgress:     func(Progress) {},
+	}
+
+	// fail on the third batch, two batches should have been checkpointed
+	w := &fakeWriter{failAt: 3}
+	_, err := NewImporter(w, cfg).ImportFile(ctx, source)
+	require.Error(t, err)
+	require.Len(t, w.entities, 20)
+
+	b, err := os.ReadFile(cfg.CheckpointPath)
+	require.NoError(t, err)
+	var cp Checkpoint
+	require.NoError(t, json.Unmarshal(b, &cp))
+	require.Equal(t, 20, cp.Entities)
+	require.Equal(t, 2, cp.Batches)
+
+	// resume continues after the last committed batch
+	w.failAt = 0
+	stats, err := NewImporter(w, cfg).ImportFile(ctx, source)
+	require.NoError(t, err)
+	require.True(t, stats.Resumed)
+	require.Equal(t, 15, stats.Entities)
+
+	require.Len(t, w.entities, 35)
+	for i := range entities {
+		require.Equal(t, entities[i].ID, w.entities[i].ID)
+	}
+}
+
+const testCSV = `entity_id,kind,value,type,security,primary,from,until
+1b4e28ba-2fa1-11d2-883f-0016d3cca427,name,Acme Ltd,,,,2015-01-01,2020-06-01
+1b4e28ba-2fa1-11d2-883f-0016d3cca427,name,Acme Plc,,,,2020-06-01,
+1b4e28ba-2fa1-11d2-883f-0016d3cca427,country,United Kingdom,,,,2015-01-01,
+1b4e28ba-2fa1-11d2-883f-0016d3cca427,identifier,42,sray_entity_id,,,2015-01-01,
+1b4e28ba-2fa1-11d2-883f-0016d3cca427,identifier,000042-E,fs_entity_id,,,2015-01-01,
+1b4e28ba-2fa1-11d2-883f-0016d3cca427,security,GB0002634946,isin,Acme Ord,true,2015-01-01,
+1b4e28ba-2fa1-11d2-883f-0016d3cca427,security,263494,asset_id,Acme Ord,,2015-01-01,
+1b4e28ba-2fa1-11d2-883f-0016d3cca427,security,US0378331005,isin,Acme ADR,,2015-01-01,
+6fa459ea-ee8a-3ca4-894e-db77e160355e,name,Broken Co,,,,not-a-date,
+6fa459ea-ee8a-3ca4-894e-db77e160355e,identifier,43,sray_entity_id,,,2016-01-01,
+c9bf9e57-1685-4c89-bafb-ff5af830be8a,name,Other Inc,,,,2016-01-01,
+`
+
+func TestCSVReader(t *testing.T) {
+	r, err := NewCSVReader(strings.NewReader(testCSV))
+	require.NoError(t, err)
+
+	entity, _, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "1b4e28ba-2fa1-11d2-883f-0016d3cca427", entity.ID.String())
+	require.Len(t, entity.Name, 2)
+	require.Equal(t, "Acme Plc", entity.Name[1].Detail.Value)
+	require.Nil(t, entity.Name[1].Duration.EndDate)
+	require.Len(t, entity.Country, 1)
+
+	require.Len(t, entity.Identifiers, 1)
+	require.Equal(t, []resolve.Identifier{
+		{Type: "sray_entity_id", Value: "42"},
+		{Type: "fs_entity_id", Value: "000042-E"},
+	}, entity.Identifiers[0].Detail)
+
+	require.Len(t, entity.Securities, 1)
+	require.Equal(t, []resolve.Security{
+		{
+			Name: "Acme Ord",
+			Identifiers: []resolve.Identifier{
+				{Type: "isin", Value: "GB0002634946"},
+				{Type: "asset_id", Value: "263494"},
+			},
+			IsPrimary: true,
+		},
+		{
+			Name:        "Acme ADR",
+			Identifiers: []resolve.Identifier{{Type: "isin", Value: "US0378331005"}},
+		},
+	}, entity.Securities[0].Detail)
+
+	// the entity with a bad date is rejected as a whole
+	_, _, err = r.Next()
+	var rowErr *RowError
+	require.ErrorAs(t, err, &rowErr)
+	require.Equal(t, 2, strings.Count(rowErr.Raw, "\n")+1)
+
+	entity, offset, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "Other Inc", entity.Name[0].Detail.Value)
+	require.Equal(t, int64(len(testCSV)), offset)
+
+	_, _, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestCSVReader_MalformedRows(t *testing.T) {
+	const (
+		acme  = "1b4e28ba-2fa1-11d2-883f-0016d3cca427"
+		other = "c9bf9e57-1685-4c89-bafb-ff5af830be8a"
+	)
+	next := func(t *testing.T, r *CSVReader) (*resolve.Entity, *RowError) {
+		t.Helper()
+		entity, _, err := r.Next()
+		var rowErr *RowError
+		if err != nil {
+			require.ErrorAs(t, err, &rowErr)
+		}
+		return entity, rowErr
+	}
+
+	t.Run("first row of an entity", func(t *testing.T) {
+		r, err := NewCSVReader(strings.NewReader(`entity_id,kind,value,type,security,primary,from,until
+` + acme + `,name,Acme Plc,,,,2015-01-01,
+6fa459ea-ee8a-3ca4-894e-db77e160355e,name,Broken "Co,,,,2016-01-01,
+6fa459ea-ee8a-3ca4-894e-db77e160355e,identifier,43,sray_entity_id,,,2016-01-01,
+` + other + `,name,Other Inc,,,,2016-01-01,
+`))
+		require.NoError(t, err)
+
+		entity, rowErr := next(t, r)
+		require.Nil(t, rowErr)
+		require.Equal(t, acme, entity.ID.String())
+
+		// the malformed row has the entity_id of the next entity, which is
+		// rejected with all its rows
+		_, rowErr = next(t, r)
+		require.NotNil(t, rowErr)
+		require.Equal(t, 2, strings.Count(rowErr.Raw, "\n")+1)
+		require.Contains(t, rowErr.Raw, "sray_entity_id")
+
+		entity, rowErr = next(t, r)
+		require.Nil(t, rowErr)
+		require.Equal(t, other, entity.ID.String())
+
+		_, _, err = r.Next()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("without an entity_id", func(t *testing.T) {
+		r, err := NewCSVReader(strings.NewReader(`entity_id,kind,value,type,security,primary,from,until
+` + acme + `,name,Acme Plc,,,,2015-01-01,
+"6fa459ea"-ee8a,country,United Kingdom,,,,2016-01-01,
+6fa459ea-ee8a-3ca4-894e-db77e160355e,name,Broken Co,,,,2016-01-01,
+6fa459ea-ee8a-3ca4-894e-db77e160355e,identifier,43,sray_entity_id,,,2016-01-01,
+` + other + `,name,Other Inc,,,,2016-01-01,
+`))
+		require.NoError(t, err)
+
+		// the row could be the last of acme or the first of the next entity,
+		// so both are rejected
+		_, rowErr := next(t, r)
+		require.NotNil(t, rowErr)
+		require.Contains(t, rowErr.Raw, "Acme Plc")
+
+		_, rowErr = next(t, r)
+		require.NotNil(t, rowErr)
+		require.Equal(t, 3, strings.Count(rowErr.Raw, "\n")+1)
+		require.Contains(t, rowErr.Raw, "sray_entity_id")
+		require.NotContains(t, rowErr.Raw, "Acme Plc")
+
+		entity, rowErr := next(t, r)
+		require.Nil(t, rowErr)
+		require.Equal(t, other, entity.ID.String())
+	})
+}
+
+func TestValidateEntity(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	withName := func(until *time.Time) *resolve.Entity {
+		return &resolve.Entity{
+			ID: uuid.Must(uuid.NewV4()),
+			Name: []resolve.DetailDuration[resolve.EntityName]{{
+				Detail:   resolve.EntityName{Value: "Acme Plc"},
+				Duration: resolve.Duration{StartDate: from, EndDate: until},
+			}},
+		}
+	}
+	at := func(t time.Time) *time.Time { return &t }
+
+	require.NoError(t, validateEntity(withName(nil)))
+	require.NoError(t, validateEntity(withName(at(from.AddDate(0, 0, 1)))))
+
+	// a duration ending when it starts holds at no date, so is rejected
+	require.EqualError(t, validateEntity(withName(at(from))), "name: until 2020-01-01 is not after from 2020-01-01")
+	require.EqualError(t, validateEntity(withName(at(from.AddDate(0, 0, -1)))), "name: until 2019-12-31 is not after from 2020-01-01")
+}
+
+func TestImporter_ImportFile_CSVResume(t *testing.T) {
+	// the import fails writing the batch with the entity after the rejected
+	// one, once the reject is written, with no entities pending or with the
+	// first entity pending
+	tests := []struct {
+		batchSize int
+		failAt    int
+		written   int
+	}{
+		{batchSize: 1, failAt: 2, written: 1},
+		{batchSize: 2, failAt: 1, written: 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprintf("batch size %d", tt.batchSize), func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			source := filepath.Join(dir, "entities.csv")
+			require.NoError(t, os.WriteFile(source, []byte(testCSV), 0o644))
+
+			cfg := Config{
+				BatchSize:      tt.batchSize,
+				CheckpointPath: filepath.Join(dir, "checkpoint.json"),
+				RejectPath:     filepath.Join(dir, "rejects.jsonl"),
+				OnProgress:     func(Progress) {},
+			}
+
+			w := &fakeWriter{failAt: tt.failAt}
+			stats, err := NewImporter(w, cfg).ImportFile(ctx, source)
+			require.Error(t, err)
+			require.Equal(t, 1, stats.Rejected)
+			require.Len(t, w.entities, tt.written)
+
+			// the resumed import doesn't reject the row again
+			w.failAt = 0
+			stats, err = NewImporter(w, cfg).ImportFile(ctx, source)
+			require.NoError(t, err)
+			require.Equal(t, 2-tt.written, stats.Entities)
+			require.Zero(t, stats.Rejected)
+			require.Len(t, w.entities, 2)
+			require.Equal(t, "Other Inc", w.entities[1].Name[0].Detail.Value)
+			require.Equal(t, 1, countLines(t, cfg.RejectPath))
+
+			b, err := os.ReadFile(cfg.CheckpointPath)
+			require.NoError(t, err)
+			var cp Checkpoint
+			require.NoError(t, json.Unmarshal(b, &cp))
+			require.Equal(t, int64(len(testCSV)), cp.Offset)
+			require.Equal(t, 2, cp.Entities)
+			require.Equal(t, 1, cp.Rejected)
+		})
+	}
+}
+
+func TestImporter_ImportFile_RejectsAtEnd(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// the rejected entity is the last, after the last batch
+	source := filepath.Join(dir, "entities.csv")
+	lines := strings.Split(strings.TrimSpace(testCSV), "\n")
+	csv := strings.Join(append(lines[:len(lines)-1:len(lines)-1], lines[len(lines)-1]+",extra"), "\n") + "\n"
+	require.NoError(t, os.WriteFile(source, []byte(csv), 0o644))
+
+	cfg := Config{
+		BatchSize:      1,
+		CheckpointPath: filepath.Join(dir, "checkpoint.json"),
+		RejectPath:     filepath.Join(dir, "rejects.jsonl"),
+		OnProgress:     func(Progress) {},
+	}
+
+	w := &fakeWriter{}
+	stats, err := NewImporter(w, cfg).ImportFile(ctx, source)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Entities)
+	require.Equal(t, 2, stats.Rejected)
+
+	// running it again finds nothing left to import
+	stats, err = NewImporter(w, cfg).ImportFile(ctx, source)
+	require.NoError(t, err)
+	require.Zero(t, stats.Entities)
+	require.Zero(t, stats.Rejected)
+	require.Equal(t, 2, countLines(t, cfg.RejectPath))
+}
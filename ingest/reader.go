@@ -0,0 +1,497 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+)
+
+// EntityReader streams entities from a source. Next returns io.EOF once the
+// source is exhausted, and a *RowError for malformed input that can be skipped.
+// The returned offset is the position in the source just after the entity (or
+// rejected rows), which is used to resume an import.
+type EntityReader interface {
+	Next() (*resolve.Entity, int64, error)
+}
+
+// RowError is returned for malformed input, the raw input is kept so that it
+// can be written to the rejects file.
+type RowError struct {
+	Offset int64
+	Raw    string
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("malformed row at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// JSONLReader reads one JSON encoded resolve.Entity per line.
+type JSONLReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+// NewJSONLReader reads from r, where offset is the position of r in the
+// underlying file (non-zero when resuming).
+func NewJSONLReader(r io.Reader, offset int64) *JSONLReader {
+	return &JSONLReader{
+		r:      bufio.NewReaderSize(r, 1<<20),
+		offset: offset,
+	}
+}
+
+func (jr *JSONLReader) Next() (*resolve.Entity, int64, error) {
+	for {
+		start := jr.offset
+		line, err := jr.r.ReadBytes('\n')
+		jr.offset += int64(len(line))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, jr.offset, fmt.Errorf("read line: %w", err)
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return nil, jr.offset, io.EOF
+			}
+			continue
+		}
+
+		var entity resolve.Entity
+		if uerr := json.Unmarshal(line, &entity); uerr != nil {
+			return nil, jr.offset, &RowError{Offset: start, Raw: string(line), Err: uerr}
+		}
+		if verr := validateEntity(&entity); verr != nil {
+			return nil, jr.offset, &RowError{Offset: start, Raw: string(line), Err: verr}
+		}
+
+		return &entity, jr.offset, nil
+	}
+}
+
+// CSV columns, rows belonging to the same entity must be contiguous.
+//
+//	entity_id: uuid of the entity
+//	kind:      one of name, country, identifier, security
+//	value:     name, country or identifier value
+//	type:      identifier type (for identifier and security rows)
+//	security:  security name (for security rows)
+//	primary:   whether the security is primary (optional)
+//	from:      start date, RFC3339 or 2006-01-02
+//	until:     end date (optional)
+//
+// Identifier and security rows with the same from and until are grouped into
+// the same duration. A security with several identifiers has a row per
+// identifier.
+const (
+	colEntityID = "entity_id"
+	colKind     = "kind"
+	colValue    = "value"
+	colType     = "type"
+	colSecurity = "security"
+	colPrimary  = "primary"
+	colFrom     = "from"
+	colUntil    = "until"
+)
+
+const (
+	kindName       = "name"
+	kindCountry    = "country"
+	kindIdentifier = "identifier"
+	kindSecurity   = "security"
+)
+
+var requiredColumns = []string{colEntityID, colKind, colFrom}
+
+// CSVReader reads entities from the long CSV format described above.
+type CSVReader struct {
+	r       *csv.Reader
+	base    int64
+	columns map[string]int
+
+	// lookahead rows belonging to the next entity
+	pending []csvRow
+	done    bool
+}
+
+// csvRow is a row read ahead, with its parse error if it is malformed.
+type csvRow struct {
+	fields []string
+	offset int64
+	err    *RowError
+}
+
+// NewCSVReader reads the header from r and returns a reader positioned at the
+// first row.
+func NewCSVReader(r io.Reader) (*CSVReader, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	columns, err := headerColumns(header)
+	if err != nil {
+		return nil, err
+	}
+	cr.FieldsPerRecord = len(header)
+
+	return &CSVReader{
+		r:       cr,
+		columns: columns,
+	}, nil
+}
+
+// newCSVReaderAt continues reading rows at offset, with the header having been
+// read separately.
+func newCSVReaderAt(r io.Reader, offset int64, header []string) (*CSVReader, error) {
+	columns, err := headerColumns(header)
+	if err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(header)
+
+	return &CSVReader{
+		r:       cr,
+		base:    offset,
+		columns: columns,
+	}, nil
+}
+
+func headerColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, h := range header {
+		columns[strings.TrimSpace(h)] = i
+	}
+	for _, c := range requiredColumns {
+		if _, ok := columns[c]; !ok {
+			return nil, fmt.Errorf("missing column %q in header", c)
+		}
+	}
+	return columns, nil
+}
+
+func (cr *CSVReader) offset() int64 {
+	return cr.base + cr.r.InputOffset()
+}
+
+// readRow returns the next row. A malformed row has the fields read before
+// the parse error, and its error.
+func (cr *CSVReader) readRow() (csvRow, error) {
+	if len(cr.pending) > 0 {
+		row := cr.pending[0]
+		cr.pending = cr.pending[1:]
+		return row, nil
+	}
+	if cr.done {
+		return csvRow{}, io.EOF
+	}
+	start := cr.offset()
+	fields, err := cr.r.Read()
+	if errors.Is(err, io.EOF) {
+		cr.done = true
+		return csvRow{}, io.EOF
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return csvRow{
+				fields: fields,
+				offset: start,
+				err:    &RowError{Offset: start, Raw: strings.Join(fields, ","), Err: err},
+			}, nil
+		}
+		return csvRow{}, fmt.Errorf("read row: %w", err)
+	}
+	return csvRow{fields: fields, offset: start}, nil
+}
+
+// Next returns the entity of the next contiguous rows with the same entity_id.
+//
+// A malformed row rejects its entity as a whole rather than importing it
+// partially. Its entity is known from the entity_id read before the parse
+// error, if any. Otherwise it belongs to the entity of the rows around it,
+// and if it is between two entities both are rejected: it is the last row of
+// the first entity, and read again as the first row of the next.
+func (cr *CSVReader) Next() (*resolve.Entity, int64, error) {
+	var (
+		id       string
+		hasID    bool
+		rows     [][]string
+		start    int64
+		parseErr error
+		// malformed rows without an entity_id at the end of the rows
+		trailing []csvRow
+	)
+	for {
+		row, err := cr.readRow()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, cr.offset(), err
+		}
+
+		// a malformed row may have been cut off before its entity_id
+		rowID := cr.field(row.fields, colEntityID)
+		known := row.err == nil || rowID != ""
+		if known && hasID && rowID != id {
+			cr.pending = append(append(trailing, row), cr.pending...)
+			break
+		}
+		if len(rows) == 0 {
+			start = row.offset
+		}
+		if known {
+			id, hasID = rowID, true
+		}
+
+		if row.err == nil {
+			rows = append(rows, row.fields)
+			trailing = nil
+			continue
+		}
+		rows = append(rows, []string{row.err.Raw})
+		parseErr = row.err.Err
+		if known {
+			trailing = nil
+		} else {
+			trailing = append(trailing, row)
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, cr.offset(), io.EOF
+	}
+	end := cr.offset()
+	if len(cr.pending) > 0 {
+		end = cr.pending[0].offset
+	}
+	if parseErr != nil {
+		return nil, end, &RowError{Offset: start, Raw: joinRows(rows), Err: parseErr}
+	}
+
+	entity, err := cr.buildEntity(id, rows)
+	if err != nil {
+		return nil, end, &RowError{Offset: start, Raw: joinRows(rows), Err: err}
+	}
+	if err := validateEntity(entity); err != nil {
+		return nil, end, &RowError{Offset: start, Raw: joinRows(rows), Err: err}
+	}
+
+	return entity, end, nil
+}
+
+func (cr *CSVReader) field(row []string, column string) string {
+	i, ok := cr.columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func (cr *CSVReader) buildEntity(id string, rows [][]string) (*resolve.Entity, error) {
+	entityID, err := uuid.FromString(id)
+	if err != nil {
+		return nil, fmt.Errorf("entity_id: %w", err)
+	}
+	entity := resolve.Entity{ID: entityID}
+
+	// group identifiers and securities by duration, keeping the row order
+	identifierIdx := map[durationKey]int{}
+	securityIdx := map[durationKey]int{}
+
+	for i, row := range rows {
+		duration, err := cr.duration(row)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		key := newDurationKey(duration)
+		value := cr.field(row, colValue)
+
+		switch kind := cr.field(row, colKind); kind {
+		case kindName:
+			if value == "" {
+				return nil, fmt.Errorf("row %d: empty name", i)
+			}
+			entity.Name = append(entity.Name, resolve.DetailDuration[resolve.EntityName]{
+				Detail:   resolve.EntityName{Value: value},
+				Duration: duration,
+			})
+		case kindCountry:
+			if value == "" {
+				return nil, fmt.Errorf("row %d: empty country", i)
+			}
+			entity.Country = append(entity.Country, resolve.DetailDuration[resolve.EntityCountry]{
+				Detail:   resolve.EntityCountry{Value: value},
+				Duration: duration,
+			})
+		case kindIdentifier:
+			idnType := cr.field(row, colType)
+			if idnType == "" || value == "" {
+				return nil, fmt.Errorf("row %d: identifier requires type and value", i)
+			}
+			j, ok := identifierIdx[key]
+			if !ok {
+				j = len(entity.Identifiers)
+				identifierIdx[key] = j
+				entity.Identifiers = append(entity.Identifiers, resolve.DetailDuration[[]resolve.Identifier]{
+					Duration: duration,
+				})
+			}
+			entity.Identifiers[j].Detail = append(entity.Identifiers[j].Detail, resolve.Identifier{
+				Type:  resolve.IdentifierType(idnType),
+				Value: value,
+			})
+		case kindSecurity:
+			name := cr.field(row, colSecurity)
+			if name == "" {
+				return nil, fmt.Errorf("row %d: empty security name", i)
+			}
+			primary, err := parseOptionalBool(cr.field(row, colPrimary))
+			if err != nil {
+				return nil, fmt.Errorf("row %d: primary: %w", i, err)
+			}
+			j, ok := securityIdx[key]
+			if !ok {
+				j = len(entity.Securities)
+				securityIdx[key] = j
+				entity.Securities = append(entity.Securities, resolve.DetailDuration[[]resolve.Security]{
+					Duration: duration,
+				})
+			}
+			securities := entity.Securities[j].Detail
+			k := -1
+			for s := range securities {
+				if securities[s].Name == name {
+					k = s
+					break
+				}
+			}
+			if k < 0 {
+				k = len(securities)
+				securities = append(securities, resolve.Security{Name: name})
+			}
+			securities[k].IsPrimary = securities[k].IsPrimary || primary
+			if idnType := cr.field(row, colType); idnType != "" && value != "" {
+				securities[k].Identifiers = append(securities[k].Identifiers, resolve.Identifier{
+					Type:  resolve.IdentifierType(idnType),
+					Value: value,
+				})
+			}
+			entity.Securities[j].Detail = securities
+		default:
+			return nil, fmt.Errorf("row %d: unknown kind %q", i, kind)
+		}
+	}
+
+	return &entity, nil
+}
+
+func (cr *CSVReader) duration(row []string) (resolve.Duration, error) {
+	from, err := parseDate(cr.field(row, colFrom))
+	if err != nil {
+		return resolve.Duration{}, fmt.Errorf("from: %w", err)
+	}
+	duration := resolve.Duration{StartDate: from}
+
+	if s := cr.field(row, colUntil); s != "" {
+		until, err := parseDate(s)
+		if err != nil {
+			return resolve.Duration{}, fmt.Errorf("until: %w", err)
+		}
+		duration.EndDate = &until
+	}
+	return duration, nil
+}
+
+type durationKey struct {
+	from  time.Time
+	until time.Time
+	open  bool
+}
+
+func newDurationKey(d resolve.Duration) durationKey {
+	if d.EndDate == nil {
+		return durationKey{from: d.StartDate, open: true}
+	}
+	return durationKey{from: d.StartDate, until: *d.EndDate}
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+func parseOptionalBool(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+func joinRows(rows [][]string) string {
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		lines = append(lines, strings.Join(row, ","))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validateEntity checks the entity is complete enough to be written.
+func validateEntity(e *resolve.Entity) error {
+	if e.ID.IsNil() {
+		return errors.New("missing entity id")
+	}
+	if len(e.Name) == 0 {
+		return errors.New("missing name")
+	}
+	check := func(what string, d resolve.Duration) error {
+		if d.StartDate.IsZero() {
+			return fmt.Errorf("%s: missing from date", what)
+		}
+		if d.EndDate != nil && !d.EndDate.After(d.StartDate) {
+			return fmt.Errorf("%s: until %s is not after from %s", what, d.EndDate.Format(time.DateOnly), d.StartDate.Format(time.DateOnly))
+		}
+		return nil
+	}
+	for _, n := range e.Name {
+		if err := check("name", n.Duration); err != nil {
+			return err
+		}
+	}
+	for _, c := range e.Country {
+		if err := check("country", c.Duration); err != nil {
+			return err
+		}
+	}
+	for _, i := range e.Identifiers {
+		if err := check("identifiers", i.Duration); err != nil {
+			return err
+		}
+	}
+	for _, s := range e.Securities {
+		if err := check("securities", s.Duration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
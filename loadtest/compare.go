@@ -0,0 +1,115 @@
+package loadtest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Thresholds are the largest changes from the base report that aren't
+// regressions. Zero values are defaulted.
+type Thresholds struct {
+	// Latency is the relative increase of p50, p95 and p99, defaults to 0.1.
+	Latency float64
+	// Throughput is the relative decrease, defaults to 0.1.
+	Throughput float64
+	// ErrorRate is the absolute increase, defaults to 0.01.
+	ErrorRate float64
+	// MatchRate is the absolute decrease, defaults to 0.01. A lower match rate
+	// usually means the runs didn't look up the same data.
+	MatchRate float64
+}
+
+func (t *Thresholds) setDefaults() {
+	if t.Latency <= 0 {
+		t.Latency = 0.1
+	}
+	if t.Throughput <= 0 {
+		t.Throughput = 0.1
+	}
+	if t.ErrorRate <= 0 {
+		t.ErrorRate = 0.01
+	}
+	if t.MatchRate <= 0 {
+		t.MatchRate = 0.01
+	}
+}
+
+// Delta is the change in a metric between two reports.
+type Delta struct {
+	Metric string
+	Base   float64
+	New    float64
+	// Change is relative, or absolute for rates.
+	Change     float64
+	Absolute   bool
+	Regression bool
+}
+
+type Comparison struct {
+	Base   string
+	New    string
+	Deltas []Delta
+}
+
+// Regressions returns the deltas beyond their thresholds.
+func (c Comparison) Regressions() []Delta {
+	var regressions []Delta
+	for _, d := range c.Deltas {
+		if d.Regression {
+			regressions = append(regressions, d)
+		}
+	}
+	return regressions
+}
+
+func (c Comparison) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-12s %12s %12s %9s\n", "metric", c.Base, c.New, "change")
+	for _, d := range c.Deltas {
+		flag := ""
+		if d.Regression {
+			flag = "  REGRESSION"
+		}
+		change := fmt.Sprintf("%+.1f%%", 100*d.Change)
+		if d.Absolute {
+			change = fmt.Sprintf("%+.1fpp", 100*d.Change)
+		}
+		fmt.Fprintf(&sb, "%-12s %12.3f %12.3f %9s%s\n", d.Metric, d.Base, d.New, change, flag)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// Compare diffs the new report against the base, flagging the changes beyond
+// the thresholds as regressions.
+func Compare(base, next *Report, th Thresholds) Comparison {
+	th.setDefaults()
+
+	c := Comparison{Base: label(base, "base"), New: label(next, "new")}
+	// worse is the sign of a change for the worse
+	relative := func(metric string, b, n, worse, threshold float64) {
+		var change float64
+		if b != 0 {
+			change = (n - b) / b
+		}
+		c.Deltas = append(c.Deltas, Delta{Metric: metric, Base: b, New: n, Change: change, Regression: change*worse > threshold})
+	}
+	absolute := func(metric string, b, n, worse, threshold float64) {
+		change := n - b
+		c.Deltas = append(c.Deltas, Delta{Metric: metric, Base: b, New: n, Change: change, Absolute: true, Regression: change*worse > threshold})
+	}
+
+	relative("p50_ms", base.Latency.P50, next.Latency.P50, 1, th.Latency)
+	relative("p95_ms", base.Latency.P95, next.Latency.P95, 1, th.Latency)
+	relative("p99_ms", base.Latency.P99, next.Latency.P99, 1, th.Latency)
+	relative("throughput", base.Throughput, next.Throughput, -1, th.Throughput)
+	absolute("error_rate", base.ErrorRate, next.ErrorRate, 1, th.ErrorRate)
+	absolute("match_rate", base.MatchRate, next.MatchRate, -1, th.MatchRate)
+	return c
+}
+
+func label(r *Report, fallback string) string {
+	if r.Label != "" {
+		return r.Label
+	}
+	return fallback
+}
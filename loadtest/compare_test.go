@@ -0,0 +1,50 @@
+package loadtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	base := &Report{
+		Label:      "3 cores",
+		Throughput: 1000,
+		ErrorRate:  0.001,
+		MatchRate:  0.8,
+		Latency:    Latency{P50: 2, P95: 10, P99: 20},
+	}
+
+	// within the thresholds, or better
+	next := &Report{
+		Throughput: 950,
+		ErrorRate:  0.005,
+		MatchRate:  0.8,
+		Latency:    Latency{P50: 1, P95: 10.5, P99: 21},
+	}
+	c := Compare(base, next, Thresholds{})
+	require.Empty(t, c.Regressions(), c.String())
+	require.Equal(t, "new", c.New)
+	require.Len(t, c.Deltas, 6)
+
+	next = &Report{
+		Label:      "1 core",
+		Throughput: 500,
+		ErrorRate:  0.1,
+		MatchRate:  0.5,
+		Latency:    Latency{P50: 2, P95: 10, P99: 40},
+	}
+	c = Compare(base, next, Thresholds{})
+	var regressed []string
+	for _, d := range c.Regressions() {
+		regressed = append(regressed, d.Metric)
+	}
+	require.Equal(t, []string{"p99_ms", "throughput", "error_rate", "match_rate"}, regressed)
+	require.Contains(t, c.String(), "REGRESSION")
+	require.Contains(t, c.String(), "+100.0%")
+	require.Contains(t, c.String(), "-30.0pp")
+
+	// looser thresholds
+	c = Compare(base, next, Thresholds{Latency: 2, Throughput: 0.6, ErrorRate: 0.2, MatchRate: 0.5})
+	require.Empty(t, c.Regressions(), c.String())
+}
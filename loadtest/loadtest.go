@@ -0,0 +1,332 @@
+// Package loadtest drives lookups against a store at a target rate or
+// concurrency for a duration, and reports latency percentiles, throughput,
+// error rate and match rate as JSON, so that runs can be compared.
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"neo4j-starter/resolve"
+)
+
+// Target is implemented by n4j.Adapter and memstore.Store.
+type Target interface {
+	LookupEntities(ctx context.Context, lookups []resolve.Lookup) ([]resolve.LookupResult, error)
+}
+
+type Config struct {
+	// Rate is the target number of requests per second. Zero sends requests as
+	// fast as the workers complete them.
+	Rate float64 `json:"rate"`
+	// Concurrency is the number of requests in flight at most, defaults to 10.
+	Concurrency int `json:"concurrency"`
+	// Duration is how long requests are sent for, defaults to 30s. Requests in
+	// flight at the end are completed.
+	Duration time.Duration `json:"duration_ns"`
+	// BatchSize is the number of lookups per request, defaults to 1. Repeated
+	// lookups in a batch are sent once.
+	BatchSize int `json:"batch_size"`
+}
+
+func (c *Config) setDefaults() {
+	if c.Concurrency <= 0 {
+		c.Concurrency = 10
+	}
+	if c.Duration <= 0 {
+		c.Duration = 30 * time.Second
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 1
+	}
+}
+
+// Report is the result of a run. Rates are fractions, and latencies are in
+// milliseconds.
+type Report struct {
+	Label     string    `json:"label,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Config    Config    `json:"config"`
+	Elapsed   float64   `json:"elapsed_seconds"`
+
+	Requests int `json:"requests"`
+	Errors   int `json:"errors"`
+	// Lookups are the distinct lookups of the requests without errors, and
+	// Matched those that found at least one entity.
+	Lookups int `json:"lookups"`
+	Matched int `json:"matched"`
+
+	// Throughput is in lookups per second, RequestRate in requests per second.
+	Throughput  float64 `json:"throughput"`
+	RequestRate float64 `json:"request_rate"`
+	ErrorRate   float64 `json:"error_rate"`
+	MatchRate   float64 `json:"match_rate"`
+
+	Latency Latency `json:"latency_ms"`
+}
+
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Write writes the report as indented JSON.
+func (r *Report) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
+
+// ReadReport reads a report written by Report.Write.
+func ReadReport(r io.Reader) (*Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, fmt.Errorf("read report: %w", err)
+	}
+	return &report, nil
+}
+
+func (r *Report) String() string {
+	var sb strings.Builder
+	if r.Label != "" {
+		fmt.Fprintf(&sb, "%s: ", r.Label)
+	}
+	fmt.Fprintf(&sb, "%d requests, %d lookups in %.1fs\n", r.Requests, r.Lookups, r.Elapsed)
+	fmt.Fprintf(&sb, "throughput: %.1f lookups/s, %.1f requests/s\n", r.Throughput, r.RequestRate)
+	fmt.Fprintf(&sb, "errors: %.2f%%, matched: %.2f%%\n", 100*r.ErrorRate, 100*r.MatchRate)
+	fmt.Fprintf(&sb, "latency ms: mean %.2f, p50 %.2f, p95 %.2f, p99 %.2f, max %.2f",
+		r.Latency.Mean, r.Latency.P50, r.Latency.P95, r.Latency.P99, r.Latency.Max)
+	return sb.String()
+}
+
+// Run sends requests of lookups to the target for the configured duration,
+// cycling through the lookups in order.
+//
+// At a target rate, requests are scheduled at fixed intervals, and latency is
+// measured from when a request was scheduled rather than sent, so that time
+// spent waiting for a worker when the target can't keep up is included.
+func Run(ctx context.Context, target Target, lookups []resolve.Lookup, cfg Config) (*Report, error) {
+	cfg.setDefaults()
+	if len(lookups) == 0 {
+		return nil, errors.New("run: no lookups")
+	}
+
+	start := time.Now()
+	deadline := start.Add(cfg.Duration)
+
+	// the scheduled time of each request, zero when not rate limited
+	scheduled := make(chan time.Time)
+	go func() {
+		defer close(scheduled)
+		schedule(ctx, scheduled, start, deadline, cfg.Rate)
+	}()
+
+	// each request is of the distinct lookups of the next batch, so that a
+	// store returns a single unsuccessful result for each lookup without
+	// matches, and matches are counted whatever it does with repeated lookups
+	var next int64
+	batch := func() []resolve.Lookup {
+		n := atomic.AddInt64(&next, int64(cfg.BatchSize)) - int64(cfg.BatchSize)
+		b := make([]resolve.Lookup, 0, cfg.BatchSize)
+		seen := make(map[lookupKey]bool, cfg.BatchSize)
+		for i := 0; i < cfg.BatchSize; i++ {
+			lookup := lookups[(int(n)+i)%len(lookups)]
+			if key := newLookupKey(lookup); !seen[key] {
+				seen[key] = true
+				b = append(b, lookup)
+			}
+		}
+		return b
+	}
+
+	workers := make([]worker, cfg.Concurrency)
+	wg := &sync.WaitGroup{}
+	for i := range workers {
+		w := &workers[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for at := range scheduled {
+				if at.IsZero() {
+					at = time.Now()
+				}
+				b := batch()
+				results, err := target.LookupEntities(ctx, b)
+				w.record(time.Since(at), b, results, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("run: %w", err)
+	}
+
+	return newReport(start, time.Since(start), cfg, workers), nil
+}
+
+// schedule sends the scheduled time of each request until the deadline, at the
+// rate, or sends zero times as soon as a worker is free without a rate.
+func schedule(ctx context.Context, scheduled chan<- time.Time, start, deadline time.Time, rate float64) {
+	if rate <= 0 {
+		for time.Now().Before(deadline) {
+			select {
+			case scheduled <- time.Time{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		return
+	}
+
+	interval := time.Duration(float64(time.Second) / rate)
+	// the timer starts stopped and drained, as before Go 1.23 a fire left in
+	// the channel isn't cleared by Reset
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	defer timer.Stop()
+	for i := 0; ; i++ {
+		at := start.Add(time.Duration(i) * interval)
+		if !at.Before(deadline) {
+			return
+		}
+		if wait := time.Until(at); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+		// behind schedule, requests are sent as soon as workers are free
+		select {
+		case scheduled <- at:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// worker records the requests sent by a worker, so workers don't contend.
+type worker struct {
+	latencies []time.Duration
+	requests  int
+	errors    int
+	lookups   int
+	matched   int
+}
+
+func (w *worker) record(latency time.Duration, lookups []resolve.Lookup, results []resolve.LookupResult, err error) {
+	w.requests++
+	w.latencies = append(w.latencies, latency)
+	if err != nil {
+		w.errors++
+		return
+	}
+	w.lookups += len(lookups)
+
+	// the lookups are distinct, with a single unsuccessful result for each
+	// without matches
+	unmatched := 0
+	for _, r := range results {
+		if !r.Success {
+			unmatched++
+		}
+	}
+	if unmatched < len(lookups) {
+		w.matched += len(lookups) - unmatched
+	}
+}
+
+// lookupKey identifies a lookup as the stores do, with the date to the second.
+type lookupKey struct {
+	identifier resolve.Identifier
+	date       int64
+	dated      bool
+}
+
+func newLookupKey(lookup resolve.Lookup) lookupKey {
+	key := lookupKey{identifier: lookup.Identifier}
+	if lookup.Date != nil {
+		key.date, key.dated = lookup.Date.Unix(), true
+	}
+	return key
+}
+
+func newReport(start time.Time, elapsed time.Duration, cfg Config, workers []worker) *Report {
+	r := &Report{
+		StartedAt: start.UTC(),
+		Config:    cfg,
+		Elapsed:   elapsed.Seconds(),
+	}
+
+	var latencies []time.Duration
+	for _, w := range workers {
+		latencies = append(latencies, w.latencies...)
+		r.Requests += w.requests
+		r.Errors += w.errors
+		r.Lookups += w.lookups
+		r.Matched += w.matched
+	}
+
+	if r.Elapsed > 0 {
+		r.Throughput = float64(r.Lookups) / r.Elapsed
+		r.RequestRate = float64(r.Requests) / r.Elapsed
+	}
+	if r.Requests > 0 {
+		r.ErrorRate = float64(r.Errors) / float64(r.Requests)
+	}
+	if r.Lookups > 0 {
+		r.MatchRate = float64(r.Matched) / float64(r.Lookups)
+	}
+	r.Latency = newLatency(latencies)
+	return r
+}
+
+func newLatency(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	return Latency{
+		Mean: ms(total / time.Duration(len(latencies))),
+		P50:  ms(percentile(latencies, 0.50)),
+		P95:  ms(percentile(latencies, 0.95)),
+		P99:  ms(percentile(latencies, 0.99)),
+		Max:  ms(latencies[len(latencies)-1]),
+	}
+}
+
+// percentile returns the nearest-rank percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,190 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"neo4j-starter/memstore"
+	"neo4j-starter/resolve"
+	"neo4j-starter/resolve/resolvetest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTarget matches identifiers with the value "match", takes delay per
+// request and fails every failEvery requests.
+type fakeTarget struct {
+	delay     time.Duration
+	failEvery int64
+	requests  int64
+}
+
+func (f *fakeTarget) LookupEntities(_ context.Context, lookups []resolve.Lookup) ([]resolve.LookupResult, error) {
+	n := atomic.AddInt64(&f.requests, 1)
+	time.Sleep(f.delay)
+	if f.failEvery > 0 && n%f.failEvery == 0 {
+		return nil, errors.New("connection reset")
+	}
+
+	var results []resolve.LookupResult
+	for _, lookup := range lookups {
+		results = append(results, resolve.LookupResult{Success: lookup.Identifier.Value == "match"})
+	}
+	return results, nil
+}
+
+var lookups = []resolve.Lookup{
+	{Identifier: resolve.Identifier{Type: "isin", Value: "match"}},
+	{Identifier: resolve.Identifier{Type: "isin", Value: "unknown"}},
+}
+
+func TestRun_Concurrency(t *testing.T) {
+	target := &fakeTarget{delay: 10 * time.Millisecond, failEvery: 4}
+	r, err := Run(context.Background(), target, lookups, Config{
+		Concurrency: 4,
+		Duration:    200 * time.Millisecond,
+		BatchSize:   2,
+	})
+	require.NoError(t, err)
+
+	// 4 workers each sending a request per 10ms
+	require.InDelta(t, 80, r.Requests, 30)
+	require.Equal(t, int(target.requests), r.Requests)
+	require.Equal(t, r.Requests/4, r.Errors)
+	require.Equal(t, 2*(r.Requests-r.Errors), r.Lookups)
+	require.Equal(t, 0.5, r.MatchRate)
+	require.GreaterOrEqual(t, r.Latency.P50, 10.0)
+	require.LessOrEqual(t, r.Latency.P50, r.Latency.P95)
+	require.LessOrEqual(t, r.Latency.P95, r.Latency.P99)
+	require.LessOrEqual(t, r.Latency.P99, r.Latency.Max)
+}
+
+// Repeated lookups in a batch, to the second, are sent once, so matches are
+// counted for a target that returns results for each.
+func TestRun_RepeatedLookups(t *testing.T) {
+	date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := date.Add(500 * time.Millisecond)
+	repeated := []resolve.Lookup{
+		{Date: &date, Identifier: resolve.Identifier{Type: "isin", Value: "match"}},
+		{Date: &date, Identifier: resolve.Identifier{Type: "isin", Value: "unknown"}},
+		{Date: &later, Identifier: resolve.Identifier{Type: "isin", Value: "unknown"}},
+		{Date: &date, Identifier: resolve.Identifier{Type: "isin", Value: "unknown"}},
+	}
+
+	r, err := Run(context.Background(), &fakeTarget{delay: time.Millisecond}, repeated, Config{
+		Concurrency: 1,
+		Duration:    50 * time.Millisecond,
+		BatchSize:   4,
+	})
+	require.NoError(t, err)
+	require.Positive(t, r.Requests)
+	require.Equal(t, 2*r.Requests, r.Lookups)
+	require.Equal(t, 0.5, r.MatchRate)
+}
+
+func TestRun_Rate(t *testing.T) {
+	r, err := Run(context.Background(), &fakeTarget{}, lookups, Config{
+		Rate:     100,
+		Duration: 300 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	// scheduled every 10ms until the end
+	require.Equal(t, 30, r.Requests)
+	require.InDelta(t, 100, r.RequestRate, 20)
+}
+
+// Requests are only sent once their scheduled time has passed, so no latency,
+// measured from it, is negative.
+func TestSchedule_Rate(t *testing.T) {
+	scheduled := make(chan time.Time)
+	start := time.Now()
+	go func() {
+		schedule(context.Background(), scheduled, start, start.Add(100*time.Millisecond), 100)
+		close(scheduled)
+	}()
+
+	var n int
+	for at := range scheduled {
+		require.GreaterOrEqual(t, time.Since(at), time.Duration(0), "request %d", n)
+		n++
+	}
+	require.Equal(t, 10, n)
+}
+
+// Requests scheduled while the target is behind include the time waited for a
+// worker.
+func TestRun_RateBehind(t *testing.T) {
+	r, err := Run(context.Background(), &fakeTarget{delay: 20 * time.Millisecond}, lookups, Config{
+		Rate:        100,
+		Concurrency: 1,
+		Duration:    200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 20, r.Requests)
+	require.Greater(t, r.Latency.Max, 100.0)
+	require.Less(t, r.RequestRate, 60.0)
+}
+
+func TestRun_Canceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := Run(ctx, &fakeTarget{}, lookups, Config{Duration: time.Minute})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRun_Memstore(t *testing.T) {
+	ctx := context.Background()
+	gen := resolvetest.NewDataGen(1)
+	store := memstore.New()
+	require.NoError(t, store.CreateEntities(ctx, gen.NewEntities(100)))
+
+	r, err := Run(ctx, store, gen.NewLookupsWith(100, resolvetest.LookupConfig{Unknown: 0.5}), Config{
+		Duration:  100 * time.Millisecond,
+		BatchSize: 10,
+	})
+	require.NoError(t, err)
+	require.Positive(t, r.Requests)
+	require.Zero(t, r.Errors)
+	require.Greater(t, r.MatchRate, 0.2)
+	require.Less(t, r.MatchRate, 0.8)
+}
+
+func TestReport_WriteRead(t *testing.T) {
+	r := &Report{
+		Label:     "baseline",
+		StartedAt: time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC),
+		Config:    Config{Rate: 100, Concurrency: 10, Duration: time.Minute, BatchSize: 1},
+		Requests:  6000,
+		Latency:   Latency{P50: 1.5, P99: 12},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf))
+	require.Contains(t, buf.String(), `"p99": 12`)
+
+	read, err := ReadReport(&buf)
+	require.NoError(t, err)
+	require.Equal(t, r, read)
+
+	_, err = ReadReport(strings.NewReader("{"))
+	require.ErrorContains(t, err, "read report")
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(100-i) * time.Millisecond
+	}
+
+	l := newLatency(latencies)
+	require.Equal(t, Latency{Mean: 50.5, P50: 50, P95: 95, P99: 99, Max: 100}, l)
+	require.Equal(t, Latency{}, newLatency(nil))
+}
@@ -0,0 +1,216 @@
+// Package memstore is an in-process resolve store, for tests and datasets small
+// enough to fit in memory. Lookups resolve as with n4j.Adapter, which is
+// checked by the conformance suite in resolvetest.
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+)
+
+// Store holds entities in memory, indexed by identifier. It is safe for
+// concurrent use. Entities are kept as written, so must not be modified after
+// being written.
+type Store struct {
+	mu           sync.RWMutex
+	entities     map[uuid.UUID]*resolve.Entity
+	byIdentifier map[resolve.Identifier][]*resolve.Entity
+
+	// now is the time undated lookups resolve at
+	now func() time.Time
+}
+
+type Option func(*Store)
+
+// WithClock sets the time undated lookups resolve at, defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(s *Store) {
+		s.now = now
+	}
+}
+
+func New(opts ...Option) *Store {
+	s := &Store{
+		entities:     map[uuid.UUID]*resolve.Entity{},
+		byIdentifier: map[resolve.Identifier][]*resolve.Entity{},
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// CreateEntities adds the entities, failing without adding any if an entity
+// already exists.
+func (s *Store) CreateEntities(_ context.Context, entities []*resolve.Entity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make(map[uuid.UUID]bool, len(entities))
+	for _, e := range entities {
+		if _, ok := s.entities[e.ID]; ok || ids[e.ID] {
+			return fmt.Errorf("create entities: entity %s already exists", e.ID)
+		}
+		ids[e.ID] = true
+	}
+
+	for _, e := range entities {
+		s.entities[e.ID] = e
+		for _, idn := range entityIdentifiers(e) {
+			s.byIdentifier[idn] = append(s.byIdentifier[idn], e)
+		}
+	}
+	return nil
+}
+
+// entityIdentifiers returns the distinct identifiers of the entity and its
+// securities over its whole history.
+func entityIdentifiers(e *resolve.Entity) []resolve.Identifier {
+	var identifiers []resolve.Identifier
+	seen := map[resolve.Identifier]bool{}
+	add := func(idns []resolve.Identifier) {
+		for _, idn := range idns {
+			if !seen[idn] {
+				seen[idn] = true
+				identifiers = append(identifiers, idn)
+			}
+		}
+	}
+
+	for _, idnd := range e.Identifiers {
+		add(idnd.Detail)
+	}
+	for _, sd := range e.Securities {
+		for _, sec := range sd.Detail {
+			add(sec.Identifiers)
+		}
+	}
+	return identifiers
+}
+
+type lookupKey struct {
+	identifier resolve.Identifier
+	date       time.Time
+	dated      bool
+}
+
+// LookupEntities returns the results of each distinct lookup in order: a result
+// for each entity which has the identifier at the date, or the current time of
+// the store's clock if undated, or a single unsuccessful result.
+func (s *Store) LookupEntities(_ context.Context, lookups []resolve.Lookup) ([]resolve.LookupResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now().UTC()
+	results := make([]resolve.LookupResult, 0, len(lookups))
+	seen := make(map[lookupKey]bool, len(lookups))
+	for _, lookup := range lookups {
+		key := lookupKey{identifier: lookup.Identifier}
+		date := now
+		if lookup.Date != nil {
+			// dates are compared to the second, as when stored in the database
+			date = lookup.Date.UTC().Truncate(time.Second)
+			key.date = date
+			key.dated = true
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var found bool
+		for _, e := range s.byIdentifier[lookup.Identifier] {
+			at := e.AsOf(date)
+			if !hasIdentifier(at, lookup.Identifier) {
+				continue
+			}
+			found = true
+			results = append(results, resultsAt(e.ID, at)...)
+		}
+		if !found {
+			results = append(results, resolve.LookupResult{Success: false})
+		}
+	}
+
+	return results, nil
+}
+
+// hasIdentifier reports whether the entity or one of its securities has the
+// identifier.
+func hasIdentifier(e *resolve.Entity, idn resolve.Identifier) bool {
+	for _, idnd := range e.Identifiers {
+		if contains(idnd.Detail, idn) {
+			return true
+		}
+	}
+	for _, sd := range e.Securities {
+		for _, sec := range sd.Detail {
+			if contains(sec.Identifiers, idn) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resultsAt returns the entity with its details as of a date, with a result
+// per name if names overlap. Securities without identifiers are left out.
+func resultsAt(id uuid.UUID, at *resolve.Entity) []resolve.LookupResult {
+	identifiers := []resolve.Identifier{}
+	securities := []resolve.Security{}
+
+	seen := map[resolve.Identifier]bool{}
+	for _, idnd := range at.Identifiers {
+		for _, idn := range idnd.Detail {
+			if !seen[idn] {
+				seen[idn] = true
+				identifiers = append(identifiers, idn)
+			}
+		}
+	}
+
+	for _, sd := range at.Securities {
+		for _, sec := range sd.Detail {
+			if len(sec.Identifiers) > 0 {
+				securities = append(securities, resolve.Security{Name: sec.Name, IsPrimary: sec.IsPrimary})
+			}
+		}
+	}
+
+	result := func(name []resolve.DetailDuration[resolve.EntityName]) resolve.LookupResult {
+		return resolve.LookupResult{
+			Success: true,
+			Entity: &resolve.Entity{
+				ID:          id,
+				Name:        name,
+				Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{{Detail: identifiers}},
+				Securities:  []resolve.DetailDuration[[]resolve.Security]{{Detail: securities}},
+			},
+		}
+	}
+
+	if len(at.Name) == 0 {
+		return []resolve.LookupResult{result(nil)}
+	}
+	results := make([]resolve.LookupResult, 0, len(at.Name))
+	for _, nd := range at.Name {
+		results = append(results, result([]resolve.DetailDuration[resolve.EntityName]{{Detail: nd.Detail}}))
+	}
+	return results
+}
+
+func contains(identifiers []resolve.Identifier, idn resolve.Identifier) bool {
+	for _, i := range identifiers {
+		if i == idn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package memstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+	"neo4j-starter/resolve/resolvetest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	resolvetest.RunConformance(t, func(t *testing.T, now time.Time) resolvetest.Store {
+		return New(WithClock(func() time.Time { return now }))
+	})
+}
+
+func TestProperties(t *testing.T) {
+	resolvetest.RunProperties(t, func(t *testing.T, now time.Time) resolvetest.Store {
+		return New(WithClock(func() time.Time { return now }))
+	}, resolvetest.PropertyConfig{Cases: 500})
+}
+
+func TestStore_GeneratedLookups(t *testing.T) {
+	ctx := context.Background()
+	gen := resolvetest.NewDataGen(1)
+	entityCount := 500
+	entities := gen.NewEntities(entityCount)
+
+	s := New()
+	require.NoError(t, s.CreateEntities(ctx, entities))
+
+	check := func(t *testing.T, expected []resolvetest.ExpectedLookup) {
+		lookups := make([]resolve.Lookup, 0, len(expected))
+		for _, el := range expected {
+			lookups = append(lookups, el.Lookup)
+		}
+		results, err := resolvetest.LookupEach(ctx, s, lookups)
+		require.NoError(t, err)
+
+		c := resolvetest.Compare(expected, results)
+		require.Empty(t, c.Diffs, c.String())
+		require.Equal(t, 1.0, c.Total().Precision())
+		require.Equal(t, 1.0, c.Total().Recall())
+	}
+
+	t.Run("guessed", func(t *testing.T) {
+		check(t, gen.NewExpectedLookups(200, entityCount, gen.Now().AddDate(-5, 0, 0)))
+	})
+	t.Run("mix", func(t *testing.T) {
+		check(t, gen.NewExpectedLookupsWith(500, resolvetest.LookupConfig{
+			Unknown:    0.1,
+			Dates:      resolvetest.DateMix{Inside: 4, Before: 1, After: 1, Undated: 1},
+			Duplicates: 0.1,
+		}))
+	})
+}
+
+func TestStore_CreateEntities_Duplicate(t *testing.T) {
+	ctx := context.Background()
+	gen := resolvetest.NewDataGen(1)
+	entities := gen.NewEntities(2)
+
+	s := New()
+	require.NoError(t, s.CreateEntities(ctx, entities[:1]))
+
+	// nothing is added when an entity already exists
+	err := s.CreateEntities(ctx, []*resolve.Entity{entities[1], entities[0]})
+	require.ErrorContains(t, err, "already exists")
+
+	res, err := s.LookupEntities(ctx, []resolve.Lookup{{Identifier: entities[1].Identifiers[0].Detail[0]}})
+	require.NoError(t, err)
+	require.Equal(t, []resolve.LookupResult{{Success: false}}, res)
+}
@@ -0,0 +1,242 @@
+package n4j
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"neo4j-starter/resolve"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// Files written by AdminImportWriter. The nodes and relationships are the same
+// as those created by CreateEntities, with from and until stored as RFC3339
+// strings and a missing until left empty so that it is imported as null.
+const (
+	adminEntities              = "entities.csv"
+	adminNames                 = "names.csv"
+	adminCountries             = "countries.csv"
+	adminIdentifiers           = "identifiers.csv"
+	adminSecurities            = "securities.csv"
+	adminHasName               = "has_name.csv"
+	adminHasCountry            = "has_country.csv"
+	adminHasIdentifier         = "has_identifier.csv"
+	adminHasSecurity           = "has_security.csv"
+	adminSecurityHasIdentifier = "security_has_identifier.csv"
+)
+
+var adminHeaders = map[string][]string{
+	adminEntities:              {"id:ID(Entity)"},
+	adminNames:                 {":ID(Name)", "value"},
+	adminCountries:             {":ID(Country)", "value"},
+	adminIdentifiers:           {":ID(Identifier)", "type", "value"},
+	adminSecurities:            {":ID(Security)", "name", "primary:boolean"},
+	adminHasName:               {":START_ID(Entity)", ":END_ID(Name)", "from", "until"},
+	adminHasCountry:            {":START_ID(Entity)", ":END_ID(Country)", "from", "until"},
+	adminHasIdentifier:         {":START_ID(Entity)", ":END_ID(Identifier)", "from", "until"},
+	adminHasSecurity:           {":START_ID(Entity)", ":END_ID(Security)", "from", "until"},
+	adminSecurityHasIdentifier: {":START_ID(Security)", ":END_ID(Identifier)"},
+}
+
+type adminFile struct {
+	f   *os.File
+	buf *bufio.Writer
+	w   *csv.Writer
+}
+
+// AdminImportWriter writes entities as node and relationship CSV files for the
+// offline `neo4j-admin database import full` command, which is much faster
+// than CreateEntities for building large datasets. Identifiers are
+// deduplicated across entities, as CreateEntities MERGEs them.
+type AdminImportWriter struct {
+	dir         string
+	files       map[string]*adminFile
+	nextID      int
+	identifiers map[resolve.Identifier]string
+}
+
+func NewAdminImportWriter(dir string) (*AdminImportWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create dir: %w", err)
+	}
+
+	w := &AdminImportWriter{
+		dir:         dir,
+		files:       make(map[string]*adminFile, len(adminHeaders)),
+		identifiers: map[resolve.Identifier]string{},
+	}
+	for name, header := range adminHeaders {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			w.Close()
+			return nil, fmt.Errorf("create %s: %w", name, err)
+		}
+		buf := bufio.NewWriterSize(f, 1<<16)
+		af := &adminFile{f: f, buf: buf, w: csv.NewWriter(buf)}
+		w.files[name] = af
+
+		if err := af.w.Write(header); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("write header %s: %w", name, err)
+		}
+	}
+
+	return w, nil
+}
+
+func (w *AdminImportWriter) newID() string {
+	w.nextID++
+	return strconv.Itoa(w.nextID)
+}
+
+func (w *AdminImportWriter) write(name string, record ...string) error {
+	if err := w.files[name].w.Write(record); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// identifierID returns the node id of the identifier, writing the node the
+// first time it is seen.
+func (w *AdminImportWriter) identifierID(idn resolve.Identifier) (string, error) {
+	if id, ok := w.identifiers[idn]; ok {
+		return id, nil
+	}
+	id := w.newID()
+	w.identifiers[idn] = id
+	return id, w.write(adminIdentifiers, id, string(idn.Type), idn.Value)
+}
+
+func (w *AdminImportWriter) Write(entity *resolve.Entity) error {
+	entityID := entity.ID.String()
+	if err := w.write(adminEntities, entityID); err != nil {
+		return err
+	}
+
+	for _, nd := range entity.Name {
+		id := w.newID()
+		if err := w.write(adminNames, id, nd.Detail.Value); err != nil {
+			return err
+		}
+		from, until := adminDuration(nd.Duration)
+		if err := w.write(adminHasName, entityID, id, from, until); err != nil {
+			return err
+		}
+	}
+
+	for _, cd := range entity.Country {
+		id := w.newID()
+		if err := w.write(adminCountries, id, cd.Detail.Value); err != nil {
+			return err
+		}
+		from, until := adminDuration(cd.Duration)
+		if err := w.write(adminHasCountry, entityID, id, from, until); err != nil {
+			return err
+		}
+	}
+
+	for _, idnd := range entity.Identifiers {
+		from, until := adminDuration(idnd.Duration)
+		for _, idn := range idnd.Detail {
+			id, err := w.identifierID(idn)
+			if err != nil {
+				return err
+			}
+			if err := w.write(adminHasIdentifier, entityID, id, from, until); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, sd := range entity.Securities {
+		from, until := adminDuration(sd.Duration)
+		for _, sec := range sd.Detail {
+			secID := w.newID()
+			if err := w.write(adminSecurities, secID, sec.Name, strconv.FormatBool(sec.IsPrimary)); err != nil {
+				return err
+			}
+			if err := w.write(adminHasSecurity, entityID, secID, from, until); err != nil {
+				return err
+			}
+			for _, idn := range sec.Identifiers {
+				id, err := w.identifierID(idn)
+				if err != nil {
+					return err
+				}
+				if err := w.write(adminSecurityHasIdentifier, secID, id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close flushes and closes all files.
+func (w *AdminImportWriter) Close() error {
+	var firstErr error
+	for name, af := range w.files {
+		af.w.Flush()
+		err := af.w.Error()
+		if err == nil {
+			err = af.buf.Flush()
+		}
+		if cerr := af.f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("close %s: %w", name, err)
+		}
+	}
+	return firstErr
+}
+
+// ImportArgs returns the arguments for `neo4j-admin` to import the files into
+// database. The database must be stopped, and CreateIndexes should be run
+// once it is started again.
+func (w *AdminImportWriter) ImportArgs(database string) []string {
+	path := func(name string) string {
+		return filepath.Join(w.dir, name)
+	}
+	return []string{
+		"database", "import", "full",
+		"--nodes=Entity=" + path(adminEntities),
+		"--nodes=Name=" + path(adminNames),
+		"--nodes=Country=" + path(adminCountries),
+		"--nodes=Identifier=" + path(adminIdentifiers),
+		"--nodes=Security=" + path(adminSecurities),
+		"--relationships=HAS_NAME=" + path(adminHasName),
+		"--relationships=HAS_COUNTRY=" + path(adminHasCountry),
+		"--relationships=HAS_IDENTIFIER=" + path(adminHasIdentifier),
+		"--relationships=HAS_IDENTIFIER=" + path(adminSecurityHasIdentifier),
+		"--relationships=HAS_SECURITY=" + path(adminHasSecurity),
+		"--overwrite-destination",
+		database,
+	}
+}
+
+func adminDuration(d resolve.Duration) (string, string) {
+	from := *dateToOptionalString(&d.StartDate)
+	until := dateToOptionalString(d.EndDate)
+	if until == nil {
+		return from, ""
+	}
+	return from, *until
+}
+
+// CreateIndexes creates the indexes and constraints used by lookups. This is
+// done by CreateEntities, but is needed after an offline import.
+func (a *Adapter) CreateIndexes(ctx context.Context) error {
+	return a.resilient(ctx, func(ctx context.Context) error {
+		session := a.newSession(ctx, neo4j.AccessModeWrite)
+		defer session.Close(ctx)
+
+		return a.createIndex(ctx, session)
+	})
+}
@@ -0,0 +1,86 @@
+package n4j
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+	"neo4j-starter/resolve/resolvetest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readAdminCSV(t *testing.T, dir, name string) [][]string {
+	f, err := os.Open(filepath.Join(dir, name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	return records
+}
+
+func TestAdminImportWriter(t *testing.T) {
+	dir := t.TempDir()
+
+	gen := resolvetest.NewDataGen(1)
+	entities := gen.NewEntities(50)
+
+	// share an identifier between two entities, it should be written once
+	shared := resolve.Identifier{Type: "isin", Value: "XS0000000001"}
+	entities[0].Identifiers[0].Detail = append(entities[0].Identifiers[0].Detail, shared)
+	entities[1].Identifiers[0].Detail = append(entities[1].Identifiers[0].Detail, shared)
+
+	w, err := NewAdminImportWriter(dir)
+	require.NoError(t, err)
+	for _, e := range entities {
+		require.NoError(t, w.Write(e))
+	}
+	require.NoError(t, w.Close())
+
+	for name, header := range adminHeaders {
+		records := readAdminCSV(t, dir, name)
+		require.Equal(t, header, records[0], name)
+	}
+
+	var names, hasIdentifier, securities int
+	identifiers := map[resolve.Identifier]struct{}{}
+	for _, e := range entities {
+		names += len(e.Name)
+		for _, idnd := range e.Identifiers {
+			hasIdentifier += len(idnd.Detail)
+			for _, idn := range idnd.Detail {
+				identifiers[idn] = struct{}{}
+			}
+		}
+		for _, sd := range e.Securities {
+			securities += len(sd.Detail)
+			for _, sec := range sd.Detail {
+				for _, idn := range sec.Identifiers {
+					identifiers[idn] = struct{}{}
+				}
+			}
+		}
+	}
+
+	require.Len(t, readAdminCSV(t, dir, adminEntities), len(entities)+1)
+	require.Len(t, readAdminCSV(t, dir, adminNames), names+1)
+	require.Len(t, readAdminCSV(t, dir, adminHasName), names+1)
+	require.Len(t, readAdminCSV(t, dir, adminHasIdentifier), hasIdentifier+1)
+	require.Len(t, readAdminCSV(t, dir, adminSecurities), securities+1)
+	require.Len(t, readAdminCSV(t, dir, adminIdentifiers), len(identifiers)+1)
+
+	// open ended durations leave until empty, so it is imported as null
+	hasName := readAdminCSV(t, dir, adminHasName)
+	last := entities[0].Name[len(entities[0].Name)-1]
+	require.Nil(t, last.Duration.EndDate)
+	require.Equal(t, []string{
+		entities[0].ID.String(),
+		hasName[len(entities[0].Name)][1],
+		last.Duration.StartDate.Format(time.RFC3339),
+		"",
+	}, hasName[len(entities[0].Name)])
+}
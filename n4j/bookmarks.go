@@ -0,0 +1,63 @@
+package n4j
+
+import (
+	"context"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// In a cluster, reads can be routed to a follower that hasn't caught up with
+// a write yet. Bookmarks from write sessions make following read sessions wait
+// until the server has the write, so a lookup right after CreateEntities sees
+// the new entities. Sessions use the first of:
+//
+//   - the bookmark manager from WithBookmarkManager(ctx, ...)
+//   - the bookmarks from WithBookmarks(ctx, ...)
+//   - the adapter's bookmark manager, with WithCausalConsistency
+
+type (
+	bookmarkManagerKey struct{}
+	bookmarksKey       struct{}
+)
+
+// WithCausalConsistency makes every operation of the adapter see the writes of
+// previous operations, by sharing a bookmark manager between all sessions.
+// This makes reads wait for writes to be replicated, so only use it when
+// reads need to follow writes.
+func WithCausalConsistency() Option {
+	return func(a *Adapter) {
+		a.bookmarks = neo4j.NewBookmarkManager(neo4j.BookmarkManagerConfig{})
+	}
+}
+
+// WithBookmarkManager returns a context whose operations share bm, so that
+// each sees the writes of the previous ones, e.g. for a single "create then
+// resolve" flow. Bookmarks can be passed between processes with
+// bm.GetBookmarks and neo4j.BookmarkManagerConfig.InitialBookmarks.
+func WithBookmarkManager(ctx context.Context, bm neo4j.BookmarkManager) context.Context {
+	return context.WithValue(ctx, bookmarkManagerKey{}, bm)
+}
+
+// WithBookmarks returns a context whose operations wait for the transactions
+// of the bookmarks.
+func WithBookmarks(ctx context.Context, bookmarks neo4j.Bookmarks) context.Context {
+	return context.WithValue(ctx, bookmarksKey{}, bookmarks)
+}
+
+// newSession opens a session on the database, with the bookmarks for ctx.
+func (a *Adapter) newSession(ctx context.Context, mode neo4j.AccessMode) dbSession {
+	cfg := neo4j.SessionConfig{
+		DatabaseName: dbName,
+		AccessMode:   mode,
+	}
+
+	if bm, ok := ctx.Value(bookmarkManagerKey{}).(neo4j.BookmarkManager); ok {
+		cfg.BookmarkManager = bm
+	} else if bookmarks, ok := ctx.Value(bookmarksKey{}).(neo4j.Bookmarks); ok {
+		cfg.Bookmarks = bookmarks
+	} else {
+		cfg.BookmarkManager = a.bookmarks
+	}
+
+	return a.db.NewSession(ctx, cfg)
+}
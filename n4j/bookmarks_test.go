@@ -0,0 +1,54 @@
+package n4j
+
+import (
+	"context"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdapter_CausalConsistency(t *testing.T) {
+	ctx := context.Background()
+	db := &recordingDB{}
+	a := newAdapter(db, WithCausalConsistency())
+
+	// the lookup waits for the transactions of CreateEntities
+	require.NoError(t, a.CreateEntities(ctx, nil))
+	_, err := a.LookupEntities(ctx, testLookups)
+	require.NoError(t, err)
+
+	require.Len(t, db.readBookmarks, 1)
+	require.ElementsMatch(t, neo4j.Bookmarks{"tx:4"}, db.readBookmarks[0])
+	for _, cfg := range db.sessions {
+		require.Same(t, a.bookmarks, cfg.BookmarkManager)
+	}
+}
+
+func TestAdapter_Bookmarks(t *testing.T) {
+	ctx := context.Background()
+	db := &recordingDB{}
+	a := newAdapter(db)
+
+	// without bookmarks reads don't wait
+	require.NoError(t, a.CreateEntities(ctx, nil))
+	_, err := a.LookupEntities(ctx, testLookups)
+	require.NoError(t, err)
+	require.Empty(t, db.readBookmarks[0])
+
+	// a context scoped manager captures the bookmarks of writes
+	bm := neo4j.NewBookmarkManager(neo4j.BookmarkManagerConfig{})
+	flow := WithBookmarkManager(ctx, bm)
+	require.NoError(t, a.CreateEntities(flow, nil))
+	_, err = a.LookupEntities(flow, testLookups)
+	require.NoError(t, err)
+	require.Equal(t, neo4j.Bookmarks{"tx:9"}, db.readBookmarks[1])
+
+	// which can be passed to another request
+	bookmarks, err := bm.GetBookmarks(ctx)
+	require.NoError(t, err)
+	_, err = a.LookupEntities(WithBookmarks(ctx, bookmarks), testLookups)
+	require.NoError(t, err)
+	require.Equal(t, neo4j.Bookmarks{"tx:9"}, db.readBookmarks[2])
+	require.Equal(t, bookmarks, db.sessions[len(db.sessions)-1].Bookmarks)
+}
@@ -0,0 +1,300 @@
+package n4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+const deadlockDetected = "Neo.TransientError.Transaction.DeadlockDetected"
+
+type BulkConfig struct {
+	// Workers is the number of batches written concurrently, defaults to 4.
+	Workers int
+	// BatchSize is the target number of entities per transaction, defaults to
+	// 1000. Entities sharing identifiers are kept in the same batch, so a batch
+	// can be larger than this.
+	BatchSize int
+	// MaxRetries is the number of retries for a batch failing with a transient
+	// error, defaults to 5. A negative value disables retries.
+	MaxRetries int
+	// InitialBackoff is doubled on each retry up to MaxBackoff, with jitter.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// OnBatch is called after each batch has been written or has failed.
+	OnBatch func(BatchReport)
+}
+
+func (c *BulkConfig) setDefaults() {
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 1000
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = 5
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = 100 * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 5 * time.Second
+	}
+}
+
+type BatchReport struct {
+	Batch     int
+	Entities  int
+	Attempts  int
+	Deadlocks int
+	// Duration includes the time spent on retries.
+	Duration time.Duration
+	Err      error
+}
+
+type BulkStats struct {
+	Entities  int
+	Batches   int
+	Retries   int
+	Deadlocks int
+	Elapsed   time.Duration
+	// MaxBatch is the slowest batch duration.
+	MaxBatch time.Duration
+}
+
+// CreateEntitiesParallel writes entities using several concurrent
+// transactions. Batches are partitioned so that no two batches MERGE the same
+// Identifier, and batches failing with a transient error such as a deadlock are
+// retried with backoff. The first permanent error cancels the remaining batches.
+func (a *Adapter) CreateEntitiesParallel(ctx context.Context, entities []*resolve.Entity, cfg BulkConfig) (_ BulkStats, err error) {
+	cfg.setDefaults()
+	start := time.Now()
+
+	ctx, op := a.tel.start(ctx, opCreateParallel, len(entities))
+	defer op.end(&err)
+
+	// create indexes once up front, rather than in every concurrent batch
+	err = a.resilient(ctx, func(ctx context.Context) error {
+		session := a.newSession(ctx, neo4j.AccessModeWrite)
+		defer session.Close(ctx)
+
+		return a.createIndex(ctx, session)
+	})
+	if err != nil {
+		return BulkStats{}, err
+	}
+
+	batches := partitionBatches(entities, cfg.BatchSize)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan int)
+	reports := make(chan BatchReport)
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				reports <- a.writeBatch(ctx, cfg, i, batches[i])
+			}
+		}()
+	}
+
+	go func() {
+		defer close(work)
+		for i := range batches {
+			select {
+			case work <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(reports)
+	}()
+
+	var stats BulkStats
+	var firstErr error
+	for r := range reports {
+		if cfg.OnBatch != nil {
+			cfg.OnBatch(r)
+		}
+		stats.Retries += r.Attempts - 1
+		stats.Deadlocks += r.Deadlocks
+		if r.Duration > stats.MaxBatch {
+			stats.MaxBatch = r.Duration
+		}
+		if r.Err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("batch %d: %w", r.Batch, r.Err)
+				cancel()
+			}
+			continue
+		}
+		stats.Entities += r.Entities
+		stats.Batches++
+	}
+	stats.Elapsed = time.Since(start)
+
+	return stats, firstErr
+}
+
+func (a *Adapter) writeBatch(ctx context.Context, cfg BulkConfig, i int, batch []*resolve.Entity) BatchReport {
+	start := time.Now()
+	report := BatchReport{
+		Batch:    i,
+		Entities: len(batch),
+	}
+
+	ctx, op := a.tel.start(ctx, opCreateBatch, len(batch))
+	defer op.end(&report.Err)
+
+	qb := createEntitiesQuery(batch, a.tags())
+
+	report.Attempts, report.Err = retryTransient(ctx, cfg, func() error {
+		// batches have their own retries, so only go through the breaker
+		err := a.guard(ctx, func(ctx context.Context) error {
+			return a.runWrite(ctx, qb)
+		})
+		if isDeadlock(err) {
+			report.Deadlocks++
+		}
+		return err
+	})
+	a.invalidateEntities(batch)
+	report.Duration = time.Since(start)
+
+	return report
+}
+
+// runWrite runs the query in an explicit transaction, so that retries are
+// under our control rather than the driver's.
+func (a *Adapter) runWrite(ctx context.Context, qb *queryBuilder) error {
+	session := a.newSession(ctx, neo4j.AccessModeWrite)
+	defer session.Close(ctx)
+
+	tx, err := session.BeginTransaction(ctx, neo4j.WithTxTimeout(20*time.Minute))
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Close(ctx)
+
+	start := time.Now()
+	result, err := tx.Run(ctx, a.queryText(qb), qb.params)
+	if err != nil {
+		return fmt.Errorf("run: %w", err)
+	}
+	summary, err := result.Consume(ctx)
+	if err != nil {
+		return fmt.Errorf("consume: %w", err)
+	}
+	a.recordSummary(ctx, qb, summary, time.Since(start))
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// retryTransient calls fn until it succeeds, fails with a permanent error, or
+// the retries are used up. It returns the number of attempts made.
+func retryTransient(ctx context.Context, cfg BulkConfig, fn func() error) (int, error) {
+	return retry(ctx, RetryPolicy{
+		MaxAttempts:    cfg.MaxRetries + 1,
+		InitialBackoff: cfg.InitialBackoff,
+		MaxBackoff:     cfg.MaxBackoff,
+	}, nil, fn)
+}
+
+func isDeadlock(err error) bool {
+	var neo4jErr *neo4j.Neo4jError
+	return errors.As(err, &neo4jErr) && neo4jErr.Code == deadlockDetected
+}
+
+// partitionBatches groups entities into batches of about batchSize, so that
+// entities sharing an identifier (directly or through their securities) end
+// up in the same batch. Concurrent batches then never MERGE the same
+// Identifier node, which is what causes the lock contention. Entity order is
+// kept within and across batches where possible.
+func partitionBatches(entities []*resolve.Entity, batchSize int) [][]*resolve.Entity {
+	// union find over entity indexes
+	parent := make([]int, len(entities))
+	for i := range parent {
+		parent[i] = i
+	}
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	owner := map[resolve.Identifier]int{}
+	for i, entity := range entities {
+		for _, idn := range entityIdentifiers(entity) {
+			j, ok := owner[idn]
+			if !ok {
+				owner[idn] = i
+				continue
+			}
+			if ri, rj := find(i), find(j); ri != rj {
+				parent[ri] = rj
+			}
+		}
+	}
+
+	// collect components in order of their first entity
+	components := map[int][]*resolve.Entity{}
+	var order []int
+	for i, entity := range entities {
+		root := find(i)
+		if _, ok := components[root]; !ok {
+			order = append(order, root)
+		}
+		components[root] = append(components[root], entity)
+	}
+
+	var batches [][]*resolve.Entity
+	var batch []*resolve.Entity
+	for _, root := range order {
+		component := components[root]
+		if len(batch) > 0 && len(batch)+len(component) > batchSize {
+			batches = append(batches, batch)
+			batch = nil
+		}
+		batch = append(batch, component...)
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+func entityIdentifiers(entity *resolve.Entity) []resolve.Identifier {
+	var identifiers []resolve.Identifier
+	for _, idns := range entity.Identifiers {
+		identifiers = append(identifiers, idns.Detail...)
+	}
+	for _, secs := range entity.Securities {
+		for _, sec := range secs.Detail {
+			identifiers = append(identifiers, sec.Identifiers...)
+		}
+	}
+	return identifiers
+}
@@ -0,0 +1,91 @@
+package n4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+	"neo4j-starter/resolve/resolvetest"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionBatches(t *testing.T) {
+	gen := resolvetest.NewDataGen(1)
+	entities := gen.NewEntities(100)
+
+	// make entities 3, 50 and 97 share an isin with entity 0
+	shared := resolve.Identifier{Type: "isin", Value: "XS0000000001"}
+	for _, i := range []int{0, 3, 50, 97} {
+		entities[i].Identifiers[0].Detail = append(entities[i].Identifiers[0].Detail, shared)
+	}
+
+	batches := partitionBatches(entities, 10)
+
+	var total int
+	batchOf := map[resolve.Identifier]int{}
+	for b, batch := range batches {
+		total += len(batch)
+		for _, e := range batch {
+			for _, idn := range entityIdentifiers(e) {
+				if other, ok := batchOf[idn]; ok {
+					require.Equal(t, other, b, "identifier %v in more than one batch", idn)
+				}
+				batchOf[idn] = b
+			}
+		}
+	}
+	require.Equal(t, len(entities), total)
+
+	// the shared entities are pulled into the batch of the first one
+	require.Equal(t, entities[0], batches[0][0])
+	require.Contains(t, batches[0], entities[97])
+}
+
+func TestIsTransient(t *testing.T) {
+	deadlock := &neo4j.Neo4jError{Code: deadlockDetected}
+	syntax := &neo4j.Neo4jError{Code: "Neo.ClientError.Statement.SyntaxError"}
+
+	require.True(t, isTransient(fmt.Errorf("commit: %w", deadlock)))
+	require.True(t, isDeadlock(fmt.Errorf("commit: %w", deadlock)))
+	require.False(t, isTransient(syntax))
+	require.False(t, isDeadlock(syntax))
+	require.False(t, isTransient(errors.New("other")))
+	require.False(t, isTransient(nil))
+}
+
+func TestRetryTransient(t *testing.T) {
+	ctx := context.Background()
+	cfg := BulkConfig{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	cfg.setDefaults()
+
+	deadlock := &neo4j.Neo4jError{Code: deadlockDetected}
+
+	var calls int
+	attempts, err := retryTransient(ctx, cfg, func() error {
+		calls++
+		if calls < 3 {
+			return deadlock
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts)
+
+	attempts, err = retryTransient(ctx, cfg, func() error {
+		return deadlock
+	})
+	require.ErrorIs(t, err, deadlock)
+	require.Equal(t, 4, attempts)
+
+	permanent := errors.New("permanent")
+	attempts, err = retryTransient(ctx, cfg, func() error {
+		return permanent
+	})
+	require.ErrorIs(t, err, permanent)
+	require.Equal(t, 1, attempts)
+}
@@ -0,0 +1,319 @@
+package n4j
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"neo4j-starter/resolve"
+)
+
+// LookupCacheConfig configures the lookup cache enabled with WithLookupCache.
+type LookupCacheConfig struct {
+	// Size is the maximum number of cached lookups, the least recently used
+	// lookup is evicted when it is full.
+	Size int
+	// TTL is how long a lookup is cached for, zero caches lookups until they
+	// are evicted or invalidated. Undated lookups resolve at the time they were
+	// cached until then.
+	TTL time.Duration
+}
+
+// CacheStats counts lookup cache activity since the adapter was created.
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// Invalidations is the number of entries removed because the adapter wrote
+	// to an entity with their identifier.
+	Invalidations int64
+	Len           int
+}
+
+// WithLookupCache caches lookup results in front of LookupEntities and
+// LookupEntitiesConcurrent. Lookups are cached by identifier and date, and are
+// invalidated when the adapter writes an entity with the identifier, so the
+// cache is only safe to use when this adapter is the only writer. Cached
+// results are shared between callers and must not be modified.
+func WithLookupCache(cfg LookupCacheConfig) Option {
+	return func(a *Adapter) {
+		a.cache = newLookupCache(cfg)
+	}
+}
+
+// LookupCacheStats returns the lookup cache stats, which are zero if the cache
+// is not enabled.
+func (a *Adapter) LookupCacheStats() CacheStats {
+	if a.cache == nil {
+		return CacheStats{}
+	}
+	return a.cache.Stats()
+}
+
+// lookupKey identifies a lookup by the values it is queried with, so that
+// dates in different time zones or with sub-second precision share a key.
+//
+// The identifier is kept as given rather than trimmed or case folded, as that
+// is how the queries match it: identifiers are stored and looked up exactly, so
+// ones differing in case or whitespace are different identifiers with their
+// own results. Normalizing the key alone would return the results of one for
+// the other.
+type lookupKey struct {
+	identifier resolve.Identifier
+	date       string
+}
+
+func newLookupKey(lookup resolve.Lookup) lookupKey {
+	key := lookupKey{identifier: lookup.Identifier}
+	if date := dateToOptionalString(lookup.Date); date != nil {
+		key.date = *date
+	}
+	return key
+}
+
+type cacheEntry struct {
+	key     lookupKey
+	results []resolve.LookupResult
+	expires time.Time
+}
+
+type lookupCache struct {
+	mu  sync.Mutex
+	cfg LookupCacheConfig
+	now func() time.Time
+
+	lru          *list.List
+	entries      map[lookupKey]*list.Element
+	byIdentifier map[resolve.Identifier]map[lookupKey]struct{}
+
+	// gen is incremented on every invalidation, so that results read before a
+	// write are not cached after it
+	gen   uint64
+	stats CacheStats
+}
+
+func newLookupCache(cfg LookupCacheConfig) *lookupCache {
+	if cfg.Size <= 0 {
+		cfg.Size = 10_000
+	}
+	return &lookupCache{
+		cfg:          cfg,
+		now:          time.Now,
+		lru:          list.New(),
+		entries:      map[lookupKey]*list.Element{},
+		byIdentifier: map[resolve.Identifier]map[lookupKey]struct{}{},
+	}
+}
+
+// get returns the cached results of the lookup, and whether it was cached.
+func (c *lookupCache) get(key lookupKey) ([]resolve.LookupResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if ok && c.cfg.TTL > 0 && c.now().After(el.Value.(*cacheEntry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).results, true
+}
+
+// generation returns the generation to pass to put for results read after
+// this call.
+func (c *lookupCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches the results of the lookup, unless the cache was invalidated
+// since gen.
+func (c *lookupCache) put(gen uint64, key lookupKey, results []resolve.LookupResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+
+	entry := &cacheEntry{
+		key:     key,
+		results: results,
+	}
+	if c.cfg.TTL > 0 {
+		entry.expires = c.now().Add(c.cfg.TTL)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	keys, ok := c.byIdentifier[key.identifier]
+	if !ok {
+		keys = map[lookupKey]struct{}{}
+		c.byIdentifier[key.identifier] = keys
+	}
+	keys[key] = struct{}{}
+
+	for c.lru.Len() > c.cfg.Size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate removes the lookups of the identifiers, for all dates.
+func (c *lookupCache) invalidate(identifiers []resolve.Identifier) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, idn := range identifiers {
+		for key := range c.byIdentifier[idn] {
+			c.remove(c.entries[key])
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// clear removes all lookups.
+func (c *lookupCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.stats.Invalidations += int64(c.lru.Len())
+	c.lru.Init()
+	c.entries = map[lookupKey]*list.Element{}
+	c.byIdentifier = map[resolve.Identifier]map[lookupKey]struct{}{}
+}
+
+func (c *lookupCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.key)
+
+	keys := c.byIdentifier[entry.key.identifier]
+	delete(keys, entry.key)
+	if len(keys) == 0 {
+		delete(c.byIdentifier, entry.key.identifier)
+	}
+}
+
+func (c *lookupCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Len = c.lru.Len()
+	return stats
+}
+
+// cachedLookups returns the results of the lookups in order, using query for
+// the lookups that are not cached. Without a cache, query is used for all
+// lookups and its results are returned as is.
+func (a *Adapter) cachedLookups(ctx context.Context, op *operation, lookups []resolve.Lookup, query lookupQuery) ([]resolve.LookupResult, error) {
+	if a.cache == nil {
+		rows, err := query(ctx, lookups)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]resolve.LookupResult, 0, len(rows))
+		for _, row := range rows {
+			res = append(res, row.result)
+		}
+		return res, nil
+	}
+
+	found, err := a.lookupByKey(ctx, op, lookups, query)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]resolve.LookupResult, 0, len(lookups))
+	seen := make(map[lookupKey]bool, len(lookups))
+	for _, lookup := range lookups {
+		key := newLookupKey(lookup)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		res = append(res, found[key]...)
+	}
+
+	return res, nil
+}
+
+// lookupQuery looks up entities in the db, returning the rows of every lookup.
+type lookupQuery func(context.Context, []resolve.Lookup) ([]lookupRow, error)
+
+// lookupByKey returns the results of the lookups by key, using query for the
+// lookups that are not cached.
+func (a *Adapter) lookupByKey(ctx context.Context, op *operation, lookups []resolve.Lookup, query lookupQuery) (map[lookupKey][]resolve.LookupResult, error) {
+	found := make(map[lookupKey][]resolve.LookupResult, len(lookups))
+
+	misses := lookups
+	if a.cache != nil {
+		misses = nil
+		for _, lookup := range lookups {
+			key := newLookupKey(lookup)
+			if _, ok := found[key]; ok {
+				continue
+			}
+			if res, ok := a.cache.get(key); ok {
+				found[key] = res
+				continue
+			}
+			// mark as seen, so a repeated lookup is only queried once
+			found[key] = nil
+			misses = append(misses, lookup)
+		}
+		op.recordCache(len(lookups)-len(misses), len(misses))
+	}
+
+	if len(misses) == 0 {
+		return found, nil
+	}
+
+	var gen uint64
+	if a.cache != nil {
+		gen = a.cache.generation()
+	}
+	rows, err := query(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+
+	queried := make(map[lookupKey][]resolve.LookupResult, len(misses))
+	for _, row := range rows {
+		queried[row.key] = append(queried[row.key], row.result)
+	}
+	for key, res := range queried {
+		found[key] = res
+		if a.cache != nil {
+			a.cache.put(gen, key, res)
+		}
+	}
+
+	return found, nil
+}
+
+// invalidateEntities removes the cached lookups of the identifiers of the
+// entities, which is called after the adapter has written them.
+func (a *Adapter) invalidateEntities(entities []*resolve.Entity) {
+	if a.cache == nil {
+		return
+	}
+	var identifiers []resolve.Identifier
+	for _, entity := range entities {
+		identifiers = append(identifiers, entityIdentifiers(entity)...)
+	}
+	a.cache.invalidate(identifiers)
+}
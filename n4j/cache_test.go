@@ -0,0 +1,148 @@
+package n4j
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testLookupKey(value string) lookupKey {
+	return lookupKey{identifier: resolve.Identifier{Type: "isin", Value: value}, date: "2021-02-09T00:00:00Z"}
+}
+
+func TestLookupCache_LRU(t *testing.T) {
+	c := newLookupCache(LookupCacheConfig{Size: 2})
+	gen := c.generation()
+
+	c.put(gen, testLookupKey("A"), []resolve.LookupResult{{Success: true}})
+	c.put(gen, testLookupKey("B"), []resolve.LookupResult{{Success: false}})
+
+	// A is now the most recently used, so B is evicted
+	_, ok := c.get(testLookupKey("A"))
+	require.True(t, ok)
+	c.put(gen, testLookupKey("C"), nil)
+
+	_, ok = c.get(testLookupKey("B"))
+	require.False(t, ok)
+	res, ok := c.get(testLookupKey("A"))
+	require.True(t, ok)
+	require.Equal(t, []resolve.LookupResult{{Success: true}}, res)
+
+	require.Equal(t, CacheStats{Hits: 2, Misses: 1, Evictions: 1, Len: 2}, c.Stats())
+}
+
+func TestLookupCache_TTL(t *testing.T) {
+	now := time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC)
+	c := newLookupCache(LookupCacheConfig{Size: 10, TTL: time.Minute})
+	c.now = func() time.Time { return now }
+
+	c.put(c.generation(), testLookupKey("A"), nil)
+
+	now = now.Add(time.Minute)
+	_, ok := c.get(testLookupKey("A"))
+	require.True(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok = c.get(testLookupKey("A"))
+	require.False(t, ok)
+	require.Equal(t, 0, c.Stats().Len)
+}
+
+func TestLookupCache_Invalidate(t *testing.T) {
+	c := newLookupCache(LookupCacheConfig{Size: 10})
+	gen := c.generation()
+
+	a1 := testLookupKey("A")
+	a2 := testLookupKey("A")
+	a2.date = "2022-02-09T00:00:00Z"
+	c.put(gen, a1, nil)
+	c.put(gen, a2, nil)
+	c.put(gen, testLookupKey("B"), nil)
+
+	c.invalidate([]resolve.Identifier{a1.identifier})
+
+	_, ok := c.get(a1)
+	require.False(t, ok)
+	_, ok = c.get(a2)
+	require.False(t, ok)
+	_, ok = c.get(testLookupKey("B"))
+	require.True(t, ok)
+	require.Equal(t, int64(2), c.Stats().Invalidations)
+
+	// results read before the invalidation are not cached
+	c.put(gen, a1, nil)
+	_, ok = c.get(a1)
+	require.False(t, ok)
+
+	c.clear()
+	require.Equal(t, 0, c.Stats().Len)
+}
+
+func TestNewLookupKey(t *testing.T) {
+	utc := time.Date(2021, 2, 9, 12, 0, 0, 500, time.UTC)
+	local := utc.In(time.FixedZone("X", 3600))
+	idn := resolve.Identifier{Type: "isin", Value: "A"}
+
+	require.Equal(t, newLookupKey(resolve.Lookup{Date: &utc, Identifier: idn}), newLookupKey(resolve.Lookup{Date: &local, Identifier: idn}))
+	require.Equal(t, lookupKey{identifier: idn}, newLookupKey(resolve.Lookup{Identifier: idn}))
+
+	// identifiers are matched exactly, so differ by case and whitespace as
+	// the query params do
+	for _, other := range []resolve.Identifier{{Type: "ISIN", Value: "A"}, {Type: "isin", Value: "a"}, {Type: "isin", Value: " A"}} {
+		l := resolve.Lookup{Identifier: other}
+		require.NotEqual(t, newLookupKey(resolve.Lookup{Identifier: idn}), newLookupKey(l))
+		require.Equal(t, string(other.Type), lookupParams(l)["type"])
+		require.Equal(t, other.Value, lookupParams(l)["value"])
+	}
+}
+
+func TestAdapter_CachedLookups(t *testing.T) {
+	a := NewAdapter(nil, WithLookupCache(LookupCacheConfig{Size: 10}))
+	ctx, op := a.tel.start(context.Background(), opLookup, -1)
+	defer op.end(nil)
+
+	date := time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC)
+	lookup := func(value string) resolve.Lookup {
+		return resolve.Lookup{Date: &date, Identifier: resolve.Identifier{Type: "isin", Value: value}}
+	}
+
+	var queried [][]resolve.Lookup
+	query := func(_ context.Context, lookups []resolve.Lookup) ([]lookupRow, error) {
+		queried = append(queried, lookups)
+		rows := make([]lookupRow, 0, len(lookups))
+		for _, l := range lookups {
+			rows = append(rows, lookupRow{
+				key:    newLookupKey(l),
+				result: resolve.LookupResult{Success: l.Identifier.Value != "missing"},
+			})
+		}
+		return rows, nil
+	}
+
+	res, err := a.cachedLookups(ctx, op, []resolve.Lookup{lookup("A"), lookup("missing"), lookup("A")}, query)
+	require.NoError(t, err)
+	require.Equal(t, []resolve.LookupResult{{Success: true}, {Success: false}}, res)
+	require.Equal(t, [][]resolve.Lookup{{lookup("A"), lookup("missing")}}, queried)
+
+	// only B is queried, the order of the results follows the lookups
+	res, err = a.cachedLookups(ctx, op, []resolve.Lookup{lookup("missing"), lookup("B"), lookup("A")}, query)
+	require.NoError(t, err)
+	require.Equal(t, []resolve.LookupResult{{Success: false}, {Success: true}, {Success: true}}, res)
+	require.Equal(t, []resolve.Lookup{lookup("B")}, queried[1])
+
+	// writing an entity with identifier A invalidates it
+	a.invalidateEntities([]*resolve.Entity{{
+		Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{{Detail: []resolve.Identifier{lookup("A").Identifier}}},
+	}})
+	_, err = a.cachedLookups(ctx, op, []resolve.Lookup{lookup("A"), lookup("B")}, query)
+	require.NoError(t, err)
+	require.Equal(t, []resolve.Lookup{lookup("A")}, queried[2])
+
+	stats := a.LookupCacheStats()
+	require.Equal(t, int64(3), stats.Hits)
+	require.Equal(t, int64(4), stats.Misses)
+}
@@ -0,0 +1,342 @@
+package n4j
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// WithTenant tags the nodes written by the adapter with the tenant, so they
+// can be removed with CleanupScoped.
+func WithTenant(tenant string) Option {
+	return func(a *Adapter) {
+		a.tenant = tenant
+	}
+}
+
+// WithDataset tags the nodes written by the adapter with the dataset, e.g. the
+// name of a test or import run, so they can be removed with CleanupScoped.
+func WithDataset(dataset string) Option {
+	return func(a *Adapter) {
+		a.dataset = dataset
+	}
+}
+
+// tags returns the properties set on the nodes written by the adapter.
+func (a *Adapter) tags() map[string]any {
+	tags := map[string]any{}
+	if a.tenant != "" {
+		tags["tenant"] = a.tenant
+	}
+	if a.dataset != "" {
+		tags["dataset"] = a.dataset
+	}
+	return tags
+}
+
+// CleanupConfig selects the nodes removed by CleanupScoped. Without a label,
+// tenant or dataset every node is removed.
+type CleanupConfig struct {
+	// Label only removes nodes with the label, e.g. Entity.
+	Label string
+	// Tenant and Dataset only remove nodes written by an adapter created with
+	// WithTenant or WithDataset. Identifiers are shared so aren't tagged, and
+	// the ones related to removed nodes are removed once they have no
+	// relationships left.
+	Tenant  string
+	Dataset string
+	// BatchSize is the number of nodes removed per transaction, defaults to
+	// 10_000.
+	BatchSize int
+	// DryRun counts the nodes that would be removed without removing them.
+	DryRun bool
+	// OnBatch is called after each batch has been removed.
+	OnBatch func(CleanupProgress)
+}
+
+func (c *CleanupConfig) setDefaults() {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 10_000
+	}
+}
+
+// tagged reports whether nodes are selected by tenant or dataset.
+func (c *CleanupConfig) tagged() bool {
+	return c.Tenant != "" || c.Dataset != ""
+}
+
+type CleanupProgress struct {
+	Batch   int
+	Deleted int
+	// Identifiers is the number of identifiers the batch removed as they had
+	// no relationships left.
+	Identifiers int
+	// Total is the number of nodes removed so far, including identifiers.
+	Total int
+}
+
+type CleanupStats struct {
+	// Nodes is the number of selected nodes removed, or that would be removed
+	// for a dry run.
+	Nodes int
+	// Identifiers is the number of identifiers related to the selected nodes
+	// removed as they had no relationships left, only for a tenant or dataset.
+	Identifiers int
+	Batches     int
+	Elapsed     time.Duration
+}
+
+// CleanupScoped removes the nodes selected by cfg with their relationships,
+// one batch per transaction. Indexes are kept. The context is checked between
+// batches, so a cleanup can be stopped and is resumed by running it again,
+// with the stats counting the nodes removed so far.
+func (a *Adapter) CleanupScoped(ctx context.Context, cfg CleanupConfig) (_ CleanupStats, err error) {
+	ctx, op := a.tel.start(ctx, opCleanupScoped, -1)
+	defer op.end(&err)
+
+	if a.cache != nil && !cfg.DryRun {
+		defer a.cache.clear()
+	}
+
+	return a.cleanupScope(ctx, cfg)
+}
+
+func (a *Adapter) cleanupScope(ctx context.Context, cfg CleanupConfig) (CleanupStats, error) {
+	cfg.setDefaults()
+	start := time.Now()
+
+	var stats CleanupStats
+	if cfg.DryRun {
+		err := a.resilient(ctx, func(ctx context.Context) error {
+			var err error
+			stats, err = a.countScope(ctx, cfg)
+			return err
+		})
+		stats.Elapsed = time.Since(start)
+		return stats, err
+	}
+
+	qb := cleanupQuery(cfg)
+	var err error
+	for {
+		if err = ctx.Err(); err != nil {
+			err = fmt.Errorf("cleanup stopped after %d nodes: %w", stats.Nodes+stats.Identifiers, err)
+			break
+		}
+
+		var batch deletedBatch
+		err = a.resilient(ctx, func(ctx context.Context) error {
+			var err error
+			batch, err = a.deleteBatch(ctx, qb)
+			return err
+		})
+		if err != nil || batch.Deleted == 0 {
+			break
+		}
+
+		stats.Batches++
+		stats.Nodes += batch.Deleted
+		stats.Identifiers += batch.Orphans
+		if cfg.OnBatch != nil {
+			cfg.OnBatch(CleanupProgress{
+				Batch:       stats.Batches,
+				Deleted:     batch.Deleted,
+				Identifiers: batch.Orphans,
+				Total:       stats.Nodes + stats.Identifiers,
+			})
+		}
+		if batch.Deleted < cfg.BatchSize {
+			break
+		}
+	}
+	stats.Elapsed = time.Since(start)
+
+	return stats, err
+}
+
+// scopeMatch returns the MATCH clause for the nodes selected by cfg, as n.
+func scopeMatch(qb *queryBuilder, cfg CleanupConfig) string {
+	match := "MATCH (n)"
+	if cfg.Label != "" {
+		match = "MATCH (n:" + quoteName(cfg.Label) + ")"
+	}
+	if conds := scopeConds(qb, cfg, "n"); len(conds) > 0 {
+		match += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return match
+}
+
+// scopeConds returns the conditions on the tenant and dataset of v.
+func scopeConds(qb *queryBuilder, cfg CleanupConfig, v string) []string {
+	var conds []string
+	if cfg.Tenant != "" {
+		conds = append(conds, v+".tenant = $tenant")
+		qb.params["tenant"] = cfg.Tenant
+	}
+	if cfg.Dataset != "" {
+		conds = append(conds, v+".dataset = $dataset")
+		qb.params["dataset"] = cfg.Dataset
+	}
+	return conds
+}
+
+// quoteName quotes a label so it can't change the query.
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// cleanupQuery removes a batch of the nodes selected by cfg. For a tenant or
+// dataset, the identifiers related to the batch are removed in the same
+// transaction once nothing else relates to them, so identifiers related to
+// other data, or already without relationships, are left alone.
+func cleanupQuery(cfg CleanupConfig) *queryBuilder {
+	qb := newQueryBuilder()
+	qb.WriteString(scopeMatch(qb, cfg))
+	qb.params["batchSize"] = cfg.BatchSize
+	if !cfg.tagged() {
+		qb.WriteString(`
+			WITH n LIMIT $batchSize
+			DETACH DELETE n
+			RETURN count(*) AS deleted, 0 AS orphans
+		`)
+		return qb
+	}
+
+	qb.WriteString(`
+		WITH n LIMIT $batchSize
+		OPTIONAL MATCH (n)--(i:Identifier)
+		WITH collect(DISTINCT n) AS nodes, collect(DISTINCT i) AS identifiers
+		FOREACH (n IN nodes | DETACH DELETE n)
+		WITH size(nodes) AS deleted, identifiers
+		CALL {
+			WITH identifiers
+			UNWIND identifiers AS i
+			WITH i
+			WHERE NOT (i)--()
+			DELETE i
+			RETURN count(i) AS orphans
+		}
+		RETURN deleted, orphans
+	`)
+	return qb
+}
+
+// countQuery counts the nodes selected by cfg, and the identifiers related to
+// them that would have no relationships left once they are removed.
+func countQuery(cfg CleanupConfig) *queryBuilder {
+	qb := newQueryBuilder()
+	qb.WriteString(scopeMatch(qb, cfg))
+	qb.WriteString(`
+		WITH count(n) AS nodes
+	`)
+	if !cfg.tagged() {
+		qb.WriteString(`RETURN nodes, 0 AS identifiers`)
+		return qb
+	}
+
+	// an identifier is kept if anything outside the scope relates to it
+	qb.WriteString(`
+		OPTIONAL MATCH (i:Identifier)--(m)
+			WHERE ` + inScope(qb, cfg, "m") + ` AND NOT EXISTS {
+				MATCH (i)--(o)
+				WHERE NOT coalesce(` + inScope(qb, cfg, "o") + `, false)
+			}
+		RETURN nodes, count(DISTINCT i) AS identifiers
+	`)
+	return qb
+}
+
+// inScope returns the condition on v being one of the nodes selected by cfg.
+func inScope(qb *queryBuilder, cfg CleanupConfig, v string) string {
+	conds := scopeConds(qb, cfg, v)
+	if cfg.Label != "" {
+		conds = append([]string{v + ":" + quoteName(cfg.Label)}, conds...)
+	}
+	return strings.Join(conds, " AND ")
+}
+
+func (a *Adapter) countScope(ctx context.Context, cfg CleanupConfig) (CleanupStats, error) {
+	session := a.newSession(ctx, neo4j.AccessModeRead)
+	defer session.Close(ctx)
+
+	qb := countQuery(cfg)
+	stats, err := executeRead(ctx, session, func(tx dbTx) (CleanupStats, error) {
+		start := time.Now()
+		result, err := tx.Run(ctx, a.queryText(qb), qb.params)
+		if err != nil {
+			return CleanupStats{}, fmt.Errorf("run: %w", err)
+		}
+
+		var row struct {
+			Nodes       int `neo4j:"nodes"`
+			Identifiers int `neo4j:"identifiers"`
+		}
+		if result.Next(ctx) {
+			if err := decodeRecord(result.Record(), &row); err != nil {
+				return CleanupStats{}, err
+			}
+		}
+		if err = result.Err(); err != nil {
+			return CleanupStats{}, fmt.Errorf("result error: %w", err)
+		}
+
+		summary, err := result.Consume(ctx)
+		if err != nil {
+			return CleanupStats{}, fmt.Errorf("consume: %w", err)
+		}
+		a.recordSummary(ctx, qb, summary, time.Since(start))
+
+		return CleanupStats{Nodes: row.Nodes, Identifiers: row.Identifiers}, nil
+	})
+	if err != nil {
+		return CleanupStats{}, fmt.Errorf("count cleanup: %w", err)
+	}
+	return stats, nil
+}
+
+// deletedBatch is the number of nodes a batch deleted, and of identifiers
+// deleted as they had no relationships left.
+type deletedBatch struct {
+	Deleted int `neo4j:"deleted"`
+	Orphans int `neo4j:"orphans"`
+}
+
+// deleteBatch runs a query deleting a batch of nodes and returns the number
+// deleted.
+func (a *Adapter) deleteBatch(ctx context.Context, qb *queryBuilder) (deletedBatch, error) {
+	session := a.newSession(ctx, neo4j.AccessModeWrite)
+	defer session.Close(ctx)
+
+	batch, err := executeWrite(ctx, session, func(tx dbTx) (deletedBatch, error) {
+		start := time.Now()
+		result, err := tx.Run(ctx, a.queryText(qb), qb.params)
+		if err != nil {
+			return deletedBatch{}, fmt.Errorf("run: %w", err)
+		}
+
+		var row deletedBatch
+		if result.Next(ctx) {
+			if err := decodeRecord(result.Record(), &row); err != nil {
+				return deletedBatch{}, err
+			}
+		}
+		if err = result.Err(); err != nil {
+			return deletedBatch{}, fmt.Errorf("result error: %w", err)
+		}
+
+		summary, err := result.Consume(ctx)
+		if err != nil {
+			return deletedBatch{}, fmt.Errorf("consume: %w", err)
+		}
+		a.recordSummary(ctx, qb, summary, time.Since(start))
+
+		return row, nil
+	}, neo4j.WithTxTimeout(20*time.Minute))
+	if err != nil {
+		return deletedBatch{}, fmt.Errorf("execute delete: %w", err)
+	}
+	return batch, nil
+}
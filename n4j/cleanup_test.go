@@ -0,0 +1,122 @@
+package n4j
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/require"
+)
+
+// deletingDB returns a fake database deleting nodes in batches of the
+// batchSize param, and for a tenant or dataset up to as many identifiers left
+// without relationships by each batch.
+func deletingDB(nodes, identifiers int) *recordingDB {
+	db := &recordingDB{}
+	db.respond = func(query string, params map[string]any) []map[string]any {
+		deleted := nodes
+		if batchSize := params["batchSize"].(int); deleted > batchSize {
+			deleted = batchSize
+		}
+		nodes -= deleted
+
+		var orphans int
+		if strings.Contains(query, "NOT (i)--()") {
+			orphans = identifiers
+			if orphans > deleted {
+				orphans = deleted
+			}
+			identifiers -= orphans
+		}
+		return []map[string]any{{"deleted": int64(deleted), "orphans": int64(orphans)}}
+	}
+	return db
+}
+
+func TestCleanupQuery(t *testing.T) {
+	qb := cleanupQuery(CleanupConfig{BatchSize: 10})
+	require.True(t, strings.HasPrefix(qb.String(), "MATCH (n)\n"))
+
+	// labels are quoted so they can't change the query
+	qb = cleanupQuery(CleanupConfig{Label: "Entity) DETACH DELETE (x", Dataset: "test", BatchSize: 10})
+	require.True(t, strings.HasPrefix(qb.String(), "MATCH (n:`Entity) DETACH DELETE (x`) WHERE n.dataset = $dataset\n"))
+	require.Equal(t, map[string]any{"dataset": "test", "batchSize": 10}, qb.params)
+
+	// only the identifiers related to the batch are removed, not every
+	// identifier without relationships
+	require.Contains(t, qb.String(), "OPTIONAL MATCH (n)--(i:Identifier)")
+	require.NotContains(t, qb.String(), "MATCH (i:Identifier)")
+
+	qb = countQuery(CleanupConfig{Label: "Entity", Tenant: "a", Dataset: "test"})
+	require.Contains(t, qb.String(), "WHERE n.tenant = $tenant AND n.dataset = $dataset")
+	require.Contains(t, qb.String(), "OPTIONAL MATCH (i:Identifier)--(m)\n\t\t\tWHERE m:`Entity` AND m.tenant = $tenant AND m.dataset = $dataset AND NOT EXISTS")
+	require.Contains(t, qb.String(), "WHERE NOT coalesce(o:`Entity` AND o.tenant = $tenant AND o.dataset = $dataset, false)")
+}
+
+func TestAdapter_CleanupScoped(t *testing.T) {
+	ctx := context.Background()
+	a := newAdapter(deletingDB(25, 7))
+
+	var progress []CleanupProgress
+	stats, err := a.CleanupScoped(ctx, CleanupConfig{
+		Dataset:   "test",
+		BatchSize: 10,
+		OnBatch: func(p CleanupProgress) {
+			progress = append(progress, p)
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 25, stats.Nodes)
+	require.Equal(t, 7, stats.Identifiers)
+	require.Equal(t, 3, stats.Batches)
+	require.Equal(t, []CleanupProgress{
+		{Batch: 1, Deleted: 10, Identifiers: 7, Total: 17},
+		{Batch: 2, Deleted: 10, Total: 27},
+		{Batch: 3, Deleted: 5, Total: 32},
+	}, progress)
+}
+
+func TestAdapter_CleanupScoped_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := newAdapter(deletingDB(25, 0))
+
+	// the batch in progress completes, and running again resumes the cleanup
+	stats, err := a.CleanupScoped(ctx, CleanupConfig{
+		BatchSize: 10,
+		OnBatch:   func(CleanupProgress) { cancel() },
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 10, stats.Nodes)
+
+	stats, err = a.CleanupScoped(context.Background(), CleanupConfig{BatchSize: 10})
+	require.NoError(t, err)
+	require.Equal(t, 15, stats.Nodes)
+	require.Zero(t, stats.Identifiers)
+}
+
+func TestAdapter_CleanupScoped_DryRun(t *testing.T) {
+	db := &recordingDB{}
+	db.reply(map[string]any{"nodes": int64(12), "identifiers": int64(3)})
+	a := newAdapter(db)
+
+	stats, err := a.CleanupScoped(context.Background(), CleanupConfig{Tenant: "a", DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, 12, stats.Nodes)
+	require.Equal(t, 3, stats.Identifiers)
+	require.Zero(t, stats.Batches)
+
+	require.Len(t, db.sessions, 1)
+	require.Equal(t, neo4j.AccessModeRead, db.sessions[0].AccessMode)
+}
+
+func TestCreateEntitiesQuery_Tags(t *testing.T) {
+	a := NewAdapter(nil, WithTenant("a"), WithDataset("test"))
+	qb := createEntitiesQuery(nil, a.tags())
+	require.Equal(t, map[string]any{"tenant": "a", "dataset": "test"}, qb.params["tags"])
+
+	qb = createEntitiesQuery(nil, NewAdapter(nil).tags())
+	require.Empty(t, qb.params["tags"])
+}
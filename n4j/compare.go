@@ -0,0 +1,194 @@
+package n4j
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"neo4j-starter/resolve"
+	"neo4j-starter/resolve/resolvetest"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// CompareConfig configures CompareLookupStrategies.
+type CompareConfig struct {
+	// BatchSize is the number of lookups per query, defaults to 1000.
+	BatchSize int
+	// MaxDiffs is the most diffs kept, defaults to 100. Every diff is counted.
+	MaxDiffs int
+}
+
+func (c *CompareConfig) setDefaults() {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 1000
+	}
+	if c.MaxDiffs <= 0 {
+		c.MaxDiffs = 100
+	}
+}
+
+// StrategyTiming is the time a strategy took for the batches of lookups.
+type StrategyTiming struct {
+	Strategy string
+	Total    time.Duration
+	P50      time.Duration
+	P95      time.Duration
+	Max      time.Duration
+}
+
+// StrategyDiff is a lookup for which the strategies returned different results.
+type StrategyDiff struct {
+	Lookup resolve.Lookup
+	Base   []resolve.LookupResult
+	Other  []resolve.LookupResult
+}
+
+type StrategyComparison struct {
+	Lookups int
+	Batches int
+	Base    StrategyTiming
+	Other   StrategyTiming
+	// DiffCount counts the distinct lookups with different results, the first
+	// of which are in Diffs.
+	DiffCount int
+	Diffs     []StrategyDiff
+}
+
+func (c StrategyComparison) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d lookups in %d batches, %d with different results\n", c.Lookups, c.Batches, c.DiffCount)
+	fmt.Fprintf(&sb, "%-12s %10s %10s %10s %10s\n", "strategy", "total", "p50", "p95", "max")
+	for _, t := range []StrategyTiming{c.Base, c.Other} {
+		fmt.Fprintf(&sb, "%-12s %10v %10v %10v %10v\n", t.Strategy,
+			t.Total.Round(time.Millisecond), t.P50.Round(time.Microsecond), t.P95.Round(time.Microsecond), t.Max.Round(time.Microsecond))
+	}
+	for _, d := range c.Diffs {
+		fmt.Fprintf(&sb, "%s: %s %s, %s %s\n", resolvetest.DescribeLookup(d.Lookup),
+			c.Base.Strategy, resolvetest.DescribeResults(d.Base), c.Other.Strategy, resolvetest.DescribeResults(d.Other))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// CompareLookupStrategies runs the lookups in batches with both strategies,
+// bypassing the lookup cache, and diffs their results. Each batch is run by
+// both strategies in turn, alternating which goes first so neither always
+// benefits from the other warming the page cache.
+func (a *Adapter) CompareLookupStrategies(ctx context.Context, lookups []resolve.Lookup, base, other LookupStrategy, cfg CompareConfig) (_ *StrategyComparison, err error) {
+	ctx, op := a.tel.start(ctx, opCompareStrategy, len(lookups))
+	defer op.end(&err)
+
+	cfg.setDefaults()
+
+	c := &StrategyComparison{
+		Lookups: len(lookups),
+		Base:    StrategyTiming{Strategy: base.Name()},
+		Other:   StrategyTiming{Strategy: other.Name()},
+	}
+	var baseTimes, otherTimes []time.Duration
+	seen := map[lookupKey]bool{}
+
+	for i := 0; i < len(lookups); i += cfg.BatchSize {
+		batch := lookups[i:]
+		if len(batch) > cfg.BatchSize {
+			batch = batch[:cfg.BatchSize]
+		}
+
+		var baseRows, otherRows []lookupRow
+		var baseTime, otherTime time.Duration
+		runBase := func() (err error) {
+			baseRows, baseTime, err = a.timeStrategy(ctx, base, batch)
+			return err
+		}
+		runOther := func() (err error) {
+			otherRows, otherTime, err = a.timeStrategy(ctx, other, batch)
+			return err
+		}
+		first, second := runBase, runOther
+		if c.Batches%2 == 1 {
+			first, second = runOther, runBase
+		}
+		if err := first(); err != nil {
+			return nil, err
+		}
+		if err := second(); err != nil {
+			return nil, err
+		}
+		c.Batches++
+		baseTimes = append(baseTimes, baseTime)
+		otherTimes = append(otherTimes, otherTime)
+
+		baseResults, otherResults := groupRows(baseRows), groupRows(otherRows)
+		for _, lookup := range batch {
+			key := newLookupKey(lookup)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if sameResults(baseResults[key], otherResults[key]) {
+				continue
+			}
+			c.DiffCount++
+			if len(c.Diffs) < cfg.MaxDiffs {
+				c.Diffs = append(c.Diffs, StrategyDiff{Lookup: lookup, Base: baseResults[key], Other: otherResults[key]})
+			}
+		}
+	}
+
+	c.Base.setTimes(baseTimes)
+	c.Other.setTimes(otherTimes)
+	return c, nil
+}
+
+// timeStrategy runs the lookups with the strategy in a read transaction,
+// returning the rows and the time taken.
+func (a *Adapter) timeStrategy(ctx context.Context, s LookupStrategy, lookups []resolve.Lookup) ([]lookupRow, time.Duration, error) {
+	session := a.newSession(ctx, neo4j.AccessModeRead)
+	defer session.Close(ctx)
+
+	var rows []lookupRow
+	start := time.Now()
+	err := a.resilient(ctx, func(ctx context.Context) error {
+		var err error
+		rows, err = executeRead(ctx, session, func(tx dbTx) ([]lookupRow, error) {
+			return a.queryStrategy(ctx, tx, s, lookups)
+		})
+		return err
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("lookup with %s: %w", s.Name(), err)
+	}
+	return rows, time.Since(start), nil
+}
+
+func (t *StrategyTiming) setTimes(times []time.Duration) {
+	if len(times) == 0 {
+		return
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	for _, d := range times {
+		t.Total += d
+	}
+	t.P50 = times[(len(times)-1)/2]
+	t.P95 = times[(len(times)-1)*95/100]
+	t.Max = times[len(times)-1]
+}
+
+// groupRows returns the results of the rows by lookup.
+func groupRows(rows []lookupRow) map[lookupKey][]resolve.LookupResult {
+	results := map[lookupKey][]resolve.LookupResult{}
+	for _, row := range rows {
+		results[row.key] = append(results[row.key], row.result)
+	}
+	return results
+}
+
+// sameResults reports whether the results are the same in any order. Repeated
+// results count, so a strategy returning a result twice differs from one
+// returning it once.
+func sameResults(a, b []resolve.LookupResult) bool {
+	return reflect.DeepEqual(resolvetest.CanonicalResults(a), resolvetest.CanonicalResults(b))
+}
@@ -0,0 +1,80 @@
+package n4j
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+	"neo4j-starter/resolve/resolvetest"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/require"
+)
+
+// connectOrSkip connects to the local server, skipping the test if there is
+// none.
+func connectOrSkip(t *testing.T) neo4j.DriverWithContext {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	driver, cleanup, err := Connect(ctx)
+	t.Cleanup(cleanup)
+	if err != nil {
+		t.Skipf("no neo4j server: %v", err)
+	}
+	return driver
+}
+
+func TestConformance(t *testing.T) {
+	driver := connectOrSkip(t)
+
+	resolvetest.RunConformance(t, func(t *testing.T, now time.Time) resolvetest.Store {
+		a := NewAdapter(driver, WithClock(func() time.Time { return now }))
+		require.NoError(t, a.Cleanup(context.Background()))
+		return a
+	})
+}
+
+func TestProperties(t *testing.T) {
+	driver := connectOrSkip(t)
+
+	// each case and shrinking step cleans up the database, so check fewer
+	resolvetest.RunProperties(t, func(t *testing.T, now time.Time) resolvetest.Store {
+		a := NewAdapter(driver, WithClock(func() time.Time { return now }))
+		require.NoError(t, a.Cleanup(context.Background()))
+		return a
+	}, resolvetest.PropertyConfig{Cases: 20})
+}
+
+// projectionStore rebuilds the lookup projection after every write, so the
+// Projection strategy sees the entities.
+type projectionStore struct {
+	*Adapter
+}
+
+func (s projectionStore) CreateEntities(ctx context.Context, entities []*resolve.Entity) error {
+	if err := s.Adapter.CreateEntities(ctx, entities); err != nil {
+		return err
+	}
+	_, err := s.BuildLookupProjection(ctx)
+	return err
+}
+
+func TestConformance_LookupStrategies(t *testing.T) {
+	driver := connectOrSkip(t)
+
+	for _, s := range LookupStrategies() {
+		s := s
+		t.Run(s.Name(), func(t *testing.T) {
+			resolvetest.RunConformance(t, func(t *testing.T, now time.Time) resolvetest.Store {
+				a := NewAdapter(driver, WithLookupStrategy(s), WithClock(func() time.Time { return now }))
+				require.NoError(t, a.Cleanup(context.Background()))
+				if s == Projection {
+					return projectionStore{a}
+				}
+				return a
+			})
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package n4j
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replaceParams replaces each $param token in query with the Cypher literal of
+// its value. Tokens are matched on the whole parameter name, so $a never
+// replaces the start of $ab, and string literals and comments in the query are
+// left untouched. Params that are not in params are kept as is.
+func replaceParams(query string, params map[string]any) string {
+	var sb strings.Builder
+	sb.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := skipQuoted(query, i)
+			sb.WriteString(query[i:end])
+			i = end
+		case c == '/' && strings.HasPrefix(query[i:], "//"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			sb.WriteString(query[i : i+end])
+			i += end
+		case c == '$':
+			name, end := paramName(query, i+1)
+			v, ok := params[name]
+			if name == "" || !ok {
+				sb.WriteString(query[i:end])
+			} else {
+				sb.WriteString(cypherLiteral(v))
+			}
+			i = end
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+
+	return sb.String()
+}
+
+// skipQuoted returns the index after the quoted string or identifier starting
+// at start.
+func skipQuoted(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// paramName returns the parameter name starting at start, which may be
+// backtick quoted, and the index after it.
+func paramName(query string, start int) (string, int) {
+	if start < len(query) && query[start] == '`' {
+		end := skipQuoted(query, start)
+		return strings.Trim(query[start:end], "`"), end
+	}
+	end := start
+	for end < len(query) && isIdentChar(query[end]) {
+		end++
+	}
+	return query[start:end], end
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// literal is a param value already rendered as Cypher.
+type literal string
+
+// cypherLiteral renders v as a Cypher literal, supporting the same types the
+// driver accepts as params: nil, strings, numbers, booleans, times, and
+// (nested) lists and maps. Pointers are dereferenced.
+func cypherLiteral(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case literal:
+		return string(t)
+	case string:
+		return quoteString(t)
+	case bool:
+		return strconv.FormatBool(t)
+	case time.Time:
+		return fmt.Sprintf("datetime(%s)", quoteString(t.Format(time.RFC3339Nano)))
+	case time.Duration:
+		return fmt.Sprintf("duration({nanoseconds: %d})", t.Nanoseconds())
+	case []byte:
+		// the driver sends []byte as a byte array, which has no literal
+		return quoteString(string(t))
+	case fmt.Stringer:
+		// e.g. uuid.UUID, which would otherwise render as a list of bytes
+		if k := reflect.ValueOf(v).Kind(); k == reflect.Struct || k == reflect.Array {
+			return quoteString(t.String())
+		}
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return "null"
+		}
+		return cypherLiteral(rv.Elem().Interface())
+	case reflect.String:
+		return quoteString(rv.String())
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return floatLiteral(rv.Float())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return "[]"
+		}
+		items := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, cypherLiteral(rv.Index(i).Interface()))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		keys := make([]string, 0, rv.Len())
+		values := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k := fmt.Sprint(iter.Key().Interface())
+			keys = append(keys, k)
+			values[k] = iter.Value().Interface()
+		}
+		// sort keys so the output is stable
+		sort.Strings(keys)
+		items := make([]string, 0, len(keys))
+		for _, k := range keys {
+			items = append(items, quoteKey(k)+": "+cypherLiteral(values[k]))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+
+	return quoteString(fmt.Sprint(v))
+}
+
+func floatLiteral(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "0.0/0.0"
+	case math.IsInf(f, 1):
+		return "1.0/0.0"
+	case math.IsInf(f, -1):
+		return "-1.0/0.0"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	// keep it a float in Cypher, e.g. 1 -> 1.0
+	if !strings.ContainsAny(s, ".eEn") {
+		s += ".0"
+	}
+	return s
+}
+
+var stringEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"\b", `\b`,
+	"\f", `\f`,
+)
+
+func quoteString(s string) string {
+	return "'" + stringEscaper.Replace(s) + "'"
+}
+
+// quoteKey backtick quotes map keys that are not plain identifiers.
+func quoteKey(k string) string {
+	plain := k != "" && !('0' <= k[0] && k[0] <= '9')
+	for i := 0; i < len(k) && plain; i++ {
+		plain = isIdentChar(k[i])
+	}
+	if plain {
+		return k
+	}
+	return "`" + strings.ReplaceAll(k, "`", "``") + "`"
+}
@@ -0,0 +1,67 @@
+package n4j
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCypherLiteral(t *testing.T) {
+	s := "2021-02-09T00:00:00Z"
+	var nilString *string
+
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"nil", nil, "null"},
+		{"string", "ESG Book", "'ESG Book'"},
+		{"escaped string", "O'Neil \\ \"Co\"\nLtd", `'O\'Neil \\ "Co"\nLtd'`},
+		{"string pointer", &s, "'2021-02-09T00:00:00Z'"},
+		{"nil string pointer", nilString, "null"},
+		{"int", 42, "42"},
+		{"negative int64", int64(-7), "-7"},
+		{"float", 1.5, "1.5"},
+		{"whole float", 2.0, "2.0"},
+		{"nan", math.NaN(), "0.0/0.0"},
+		{"bool", true, "true"},
+		{"uuid", uuid.Must(uuid.FromString("1b4e28ba-2fa1-11d2-883f-0016d3cca427")), "'1b4e28ba-2fa1-11d2-883f-0016d3cca427'"},
+		{"time", time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC), "datetime('2021-02-09T00:00:00Z')"},
+		{"empty list", []any{}, "[]"},
+		{"nested list", [][]any{{"isin", "X1", &s}, {"cusip", "C1", nilString}}, "[['isin', 'X1', '2021-02-09T00:00:00Z'], ['cusip', 'C1', null]]"},
+		{"string slice", [][]string{{"isin", "X1"}}, "[['isin', 'X1']]"},
+		{"map", map[string]any{"value": "X1", "type": "isin", "from": nil}, "{from: null, type: 'isin', value: 'X1'}"},
+		{"map with quoted keys", map[string]any{"a b": 1, "1x": 2}, "{`1x`: 2, `a b`: 1}"},
+		{"nested map", map[string]any{"ids": []map[string]any{{"n": 1}}}, "{ids: [{n: 1}]}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, cypherLiteral(tt.v))
+		})
+	}
+}
+
+func TestQueryBuilder_ToQueryWithParams(t *testing.T) {
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		WITH $ab AS ab, $a AS a, $` + "`a b`" + ` AS quoted
+		MATCH (n {value: '$a'}) // $a in a comment
+		WHERE n.x IN $list AND n.y = $missing
+		RETURN $a
+	`)
+	qb.params["a"] = "A"
+	qb.params["ab"] = 1
+	qb.params["a b"] = true
+	qb.params["list"] = []any{"x", nil}
+
+	require.Equal(t, `
+WITH 1 AS ab, 'A' AS a, true AS quoted
+MATCH (n {value: '$a'}) // $a in a comment
+WHERE n.x IN ['x', null] AND n.y = $missing
+RETURN 'A'
+`, qb.ToQueryWithParams())
+}
@@ -0,0 +1,139 @@
+package n4j
+
+import (
+	"context"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// The adapter runs queries through the narrow interfaces below rather than the
+// driver's, which have unexported methods so can't be faked outside the driver.
+// driverDB implements them with the driver, and tests use a fake recording the
+// queries run.
+
+// database opens sessions.
+type database interface {
+	NewSession(ctx context.Context, cfg neo4j.SessionConfig) dbSession
+}
+
+type dbSession interface {
+	ExecuteRead(ctx context.Context, work txWork, configurers ...func(*neo4j.TransactionConfig)) (any, error)
+	ExecuteWrite(ctx context.Context, work txWork, configurers ...func(*neo4j.TransactionConfig)) (any, error)
+	BeginTransaction(ctx context.Context, configurers ...func(*neo4j.TransactionConfig)) (dbExplicitTx, error)
+	Close(ctx context.Context) error
+}
+
+// txWork runs the queries of a managed transaction, which may be retried.
+type txWork func(tx dbTx) (any, error)
+
+type dbTx interface {
+	Run(ctx context.Context, cypher string, params map[string]any) (dbResult, error)
+}
+
+type dbExplicitTx interface {
+	dbTx
+	Commit(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// dbResult is the part of neo4j.ResultWithContext used by the adapter.
+type dbResult interface {
+	Next(ctx context.Context) bool
+	Record() *neo4j.Record
+	Err() error
+	Consume(ctx context.Context) (neo4j.ResultSummary, error)
+}
+
+// executeRead runs work in a read transaction, like neo4j.ExecuteRead.
+func executeRead[T any](ctx context.Context, session dbSession, work func(tx dbTx) (T, error), configurers ...func(*neo4j.TransactionConfig)) (T, error) {
+	res, err := session.ExecuteRead(ctx, func(tx dbTx) (any, error) {
+		return work(tx)
+	}, configurers...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return res.(T), nil
+}
+
+// executeWrite runs work in a write transaction, like neo4j.ExecuteWrite.
+func executeWrite[T any](ctx context.Context, session dbSession, work func(tx dbTx) (T, error), configurers ...func(*neo4j.TransactionConfig)) (T, error) {
+	res, err := session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
+		return work(tx)
+	}, configurers...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return res.(T), nil
+}
+
+type driverDB struct {
+	driver neo4j.DriverWithContext
+}
+
+func (d driverDB) NewSession(ctx context.Context, cfg neo4j.SessionConfig) dbSession {
+	return driverSession{d.driver.NewSession(ctx, cfg)}
+}
+
+type driverSession struct {
+	session neo4j.SessionWithContext
+}
+
+func (s driverSession) ExecuteRead(ctx context.Context, work txWork, configurers ...func(*neo4j.TransactionConfig)) (any, error) {
+	return s.session.ExecuteRead(ctx, managedWork(work), configurers...)
+}
+
+func (s driverSession) ExecuteWrite(ctx context.Context, work txWork, configurers ...func(*neo4j.TransactionConfig)) (any, error) {
+	return s.session.ExecuteWrite(ctx, managedWork(work), configurers...)
+}
+
+func (s driverSession) BeginTransaction(ctx context.Context, configurers ...func(*neo4j.TransactionConfig)) (dbExplicitTx, error) {
+	tx, err := s.session.BeginTransaction(ctx, configurers...)
+	if err != nil {
+		return nil, err
+	}
+	return driverExplicitTx{tx}, nil
+}
+
+func (s driverSession) Close(ctx context.Context) error {
+	return s.session.Close(ctx)
+}
+
+func managedWork(work txWork) neo4j.ManagedTransactionWork {
+	return func(tx neo4j.ManagedTransaction) (any, error) {
+		return work(driverTx{tx})
+	}
+}
+
+type driverTx struct {
+	tx neo4j.ManagedTransaction
+}
+
+func (tx driverTx) Run(ctx context.Context, cypher string, params map[string]any) (dbResult, error) {
+	result, err := tx.tx.Run(ctx, cypher, params)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type driverExplicitTx struct {
+	tx neo4j.ExplicitTransaction
+}
+
+func (tx driverExplicitTx) Run(ctx context.Context, cypher string, params map[string]any) (dbResult, error) {
+	result, err := tx.tx.Run(ctx, cypher, params)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (tx driverExplicitTx) Commit(ctx context.Context) error {
+	return tx.tx.Commit(ctx)
+}
+
+func (tx driverExplicitTx) Close(ctx context.Context) error {
+	return tx.tx.Close(ctx)
+}
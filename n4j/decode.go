@@ -0,0 +1,219 @@
+package n4j
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j/dbtype"
+)
+
+// DecodeError is returned when a value can't be decoded into the destination
+// field. Path is the location of the value, e.g. identifiers[2].type.
+type DecodeError struct {
+	Path string
+	Msg  string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode %s: %s", e.Path, e.Msg)
+}
+
+const decodeTag = "neo4j"
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// decodeRecord decodes the record into dst, which must be a pointer to a
+// struct. Fields are matched to record keys using the `neo4j:"key"` tag,
+// untagged fields are ignored apart from embedded structs.
+//
+// Nodes, relationships and maps decode into structs by their properties, lists
+// into slices, and null or missing values into nil pointers and slices. A null
+// value for any other field is an error. time.Time fields accept temporal
+// values as well as RFC3339 strings, which is how dates are stored, and types
+// implementing encoding.TextUnmarshaler (such as uuid.UUID) accept strings.
+func decodeRecord(record *neo4j.Record, dst any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode record: destination must be a non-nil pointer to a struct, got %T", dst)
+	}
+
+	values := make(map[string]any, len(record.Keys))
+	for i, key := range record.Keys {
+		values[key] = record.Values[i]
+	}
+	return decodeStruct(values, rv.Elem(), "")
+}
+
+func decodeStruct(values map[string]any, dst reflect.Value, path string) error {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, ok := field.Tag.Lookup(decodeTag)
+		if !ok && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			// fields of untagged embedded structs are decoded as if they were
+			// part of the outer struct
+			if err := decodeStruct(values, dst.Field(i), path); err != nil {
+				return err
+			}
+			continue
+		}
+		if !ok || key == "-" || !field.IsExported() {
+			continue
+		}
+		if err := decodeValue(values[key], dst.Field(i), joinPath(path, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func decodeValue(src any, dst reflect.Value, path string) error {
+	if src == nil {
+		switch dst.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		return &DecodeError{Path: path, Msg: fmt.Sprintf("null value for non-nullable %s", dst.Type())}
+	}
+
+	mismatch := func() error {
+		return &DecodeError{Path: path, Msg: fmt.Sprintf("cannot decode %T into %s", src, dst.Type())}
+	}
+
+	if dst.Type() == timeType {
+		t, err := decodeTime(src)
+		if err != nil {
+			return &DecodeError{Path: path, Msg: err.Error()}
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	if dst.Kind() != reflect.Pointer && reflect.PointerTo(dst.Type()).Implements(textUnmarshalerType) {
+		s, ok := src.(string)
+		if !ok {
+			return mismatch()
+		}
+		if err := dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return &DecodeError{Path: path, Msg: err.Error()}
+		}
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Pointer:
+		v := reflect.New(dst.Type().Elem())
+		if err := decodeValue(src, v.Elem(), path); err != nil {
+			return err
+		}
+		dst.Set(v)
+	case reflect.Interface:
+		v := reflect.ValueOf(src)
+		if !v.Type().AssignableTo(dst.Type()) {
+			return mismatch()
+		}
+		dst.Set(v)
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetString(s)
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := src.(int64)
+		if !ok {
+			return mismatch()
+		}
+		if dst.OverflowInt(n) {
+			return &DecodeError{Path: path, Msg: fmt.Sprintf("%d overflows %s", n, dst.Type())}
+		}
+		dst.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		switch n := src.(type) {
+		case float64:
+			dst.SetFloat(n)
+		case int64:
+			dst.SetFloat(float64(n))
+		default:
+			return mismatch()
+		}
+	case reflect.Slice:
+		list, ok := src.([]any)
+		if !ok {
+			return mismatch()
+		}
+		s := reflect.MakeSlice(dst.Type(), len(list), len(list))
+		for i, item := range list {
+			if err := decodeValue(item, s.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+	case reflect.Struct:
+		props, ok := properties(src)
+		if !ok {
+			return mismatch()
+		}
+		return decodeStruct(props, dst, path)
+	default:
+		return &DecodeError{Path: path, Msg: fmt.Sprintf("unsupported destination type %s", dst.Type())}
+	}
+
+	return nil
+}
+
+// properties returns the values a struct is decoded from.
+func properties(src any) (map[string]any, bool) {
+	switch v := src.(type) {
+	case neo4j.Node:
+		return v.Props, true
+	case *neo4j.Node:
+		return v.Props, true
+	case neo4j.Relationship:
+		return v.Props, true
+	case *neo4j.Relationship:
+		return v.Props, true
+	case map[string]any:
+		return v, true
+	}
+	return nil, false
+}
+
+func decodeTime(src any) (time.Time, error) {
+	switch v := src.(type) {
+	case time.Time:
+		return v, nil
+	case dbtype.Date:
+		return v.Time(), nil
+	case dbtype.LocalDateTime:
+		return v.Time(), nil
+	case string:
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(v))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse time: %w", err)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot decode %T into time.Time", src)
+}
@@ -0,0 +1,141 @@
+package n4j
+
+import (
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/require"
+)
+
+func newRecord(values map[string]any) *neo4j.Record {
+	record := &neo4j.Record{}
+	for k, v := range values {
+		record.Keys = append(record.Keys, k)
+		record.Values = append(record.Values, v)
+	}
+	return record
+}
+
+func node(props map[string]any, labels ...string) neo4j.Node {
+	return neo4j.Node{Labels: labels, Props: props}
+}
+
+func TestDecodeRecord(t *testing.T) {
+	type dated struct {
+		identifierNode
+		From  time.Time  `neo4j:"from"`
+		Until *time.Time `neo4j:"until"`
+	}
+	type row struct {
+		Entity  *entityNode `neo4j:"entity"`
+		Count   int         `neo4j:"count"`
+		Score   float64     `neo4j:"score"`
+		Tags    []string    `neo4j:"tags"`
+		Dated   []dated     `neo4j:"dated"`
+		Nested  [][]int64   `neo4j:"nested"`
+		Missing *string     `neo4j:"missing"`
+		Raw     any         `neo4j:"raw"`
+		Ignored string
+	}
+
+	record := newRecord(map[string]any{
+		"entity": node(map[string]any{"id": "1b4e28ba-2fa1-11d2-883f-0016d3cca427"}, "Entity"),
+		"count":  int64(3),
+		"score":  int64(2),
+		"tags":   []any{"a", "b"},
+		"dated": []any{
+			map[string]any{"type": "isin", "value": "X1", "from": "2015-01-01T00:00:00Z", "until": nil},
+			map[string]any{"type": "isin", "value": "X2", "from": "2015-01-01T00:00:00Z", "until": "2020-06-01T00:00:00Z"},
+		},
+		"nested": []any{[]any{int64(1)}, []any{}},
+		"raw":    int64(7),
+	})
+
+	var got row
+	require.NoError(t, decodeRecord(record, &got))
+
+	until := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, row{
+		Entity: &entityNode{ID: uuid.Must(uuid.FromString("1b4e28ba-2fa1-11d2-883f-0016d3cca427"))},
+		Count:  3,
+		Score:  2,
+		Tags:   []string{"a", "b"},
+		Dated: []dated{
+			{identifierNode: identifierNode{Type: "isin", Value: "X1"}, From: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{identifierNode: identifierNode{Type: "isin", Value: "X2"}, From: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), Until: &until},
+		},
+		Nested: [][]int64{{1}, {}},
+		Raw:    int64(7),
+	}, got)
+}
+
+func TestDecodeRecord_Errors(t *testing.T) {
+	type row struct {
+		Identifiers []identifierNode `neo4j:"identifiers"`
+	}
+
+	tests := []struct {
+		name  string
+		value any
+		err   string
+	}{
+		{
+			"type mismatch",
+			[]any{node(map[string]any{"type": "isin", "value": "X1"}), node(map[string]any{"type": "isin", "value": int64(1)})},
+			"decode identifiers[1].value: cannot decode int64 into string",
+		},
+		{
+			"null for non-nullable",
+			[]any{node(map[string]any{"type": nil, "value": "X1"})},
+			"decode identifiers[0].type: null value for non-nullable resolve.IdentifierType",
+		},
+		{
+			"not a list",
+			"X1",
+			"decode identifiers: cannot decode string into []n4j.identifierNode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got row
+			err := decodeRecord(newRecord(map[string]any{"identifiers": tt.value}), &got)
+			var decodeErr *DecodeError
+			require.ErrorAs(t, err, &decodeErr)
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestMapLookupRecord(t *testing.T) {
+	row, err := mapLookupRecord(newRecord(map[string]any{
+		"lookup":               map[string]any{"type": "isin", "value": "X1", "date": nil},
+		"entity":               nil,
+		"name":                 nil,
+		"identifiers":          []any{},
+		"securities":           []any{},
+		"security_identifiers": []any{},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, resolve.LookupResult{Success: false}, row.result)
+	require.Equal(t, lookupKey{identifier: resolve.Identifier{Type: "isin", Value: "X1"}}, row.key)
+
+	row, err = mapLookupRecord(newRecord(map[string]any{
+		"lookup":               map[string]any{"type": "isin", "value": "X1", "date": "2021-02-09T00:00:00Z"},
+		"entity":               node(map[string]any{"id": "1b4e28ba-2fa1-11d2-883f-0016d3cca427"}),
+		"name":                 node(map[string]any{"value": "Acme Plc"}),
+		"identifiers":          []any{node(map[string]any{"type": "sray_entity_id", "value": "42"})},
+		"securities":           []any{node(map[string]any{"name": "Acme Ord", "primary": true})},
+		"security_identifiers": []any{node(map[string]any{"type": "isin", "value": "X1"})},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "2021-02-09T00:00:00Z", row.key.date)
+	res := row.result
+	require.True(t, res.Success)
+	require.Equal(t, "Acme Plc", res.Entity.Name[0].Detail.Value)
+	require.Equal(t, []resolve.Identifier{{Type: "sray_entity_id", Value: "42"}}, res.Entity.Identifiers[0].Detail)
+	require.Equal(t, []resolve.Security{{Name: "Acme Ord", IsPrimary: true}}, res.Entity.Securities[0].Detail)
+}
@@ -0,0 +1,208 @@
+package n4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// ErrEntityNotFound is returned when deleting an entity that doesn't exist.
+var ErrEntityNotFound = errors.New("entity not found")
+
+// AuditAction is the kind of deletion an audit record is for.
+type AuditAction string
+
+const (
+	// AuditEnd is a soft delete by EndEntity.
+	AuditEnd AuditAction = "end"
+	// AuditErase is a hard delete by EraseEntity.
+	AuditErase AuditAction = "erase"
+)
+
+// AuditRecord describes a deletion, whether it succeeded or not.
+type AuditRecord struct {
+	Action   AuditAction `json:"action"`
+	EntityID uuid.UUID   `json:"entity_id"`
+	// Date is the date relationships were ended at, only set for AuditEnd.
+	Date *time.Time `json:"date,omitempty"`
+	// Reason is given by the caller, e.g. the reference of a retention request.
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+
+	RelationshipsEnded int `json:"relationships_ended,omitempty"`
+	// NodesDeleted includes the entity, its names, countries and securities,
+	// and IdentifiersDeleted the identifiers left without relationships.
+	NodesDeleted       int `json:"nodes_deleted,omitempty"`
+	IdentifiersDeleted int `json:"identifiers_deleted,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// WithAuditLog calls fn with the audit record of every deletion, including
+// failed ones. fn should persist the record before returning, e.g. by writing
+// it as a line of JSON to an append-only file.
+func WithAuditLog(fn func(AuditRecord)) Option {
+	return func(a *Adapter) {
+		a.audit = fn
+	}
+}
+
+// EndEntity soft deletes an entity by ending its open names, countries,
+// identifiers and securities at date, so the history up to date is kept and
+// lookups from date onwards don't find it. Relationships starting after date
+// are ended at their start, so they are never valid.
+func (a *Adapter) EndEntity(ctx context.Context, id uuid.UUID, date time.Time, reason string) (rec AuditRecord, err error) {
+	ctx, op := a.tel.start(ctx, opEndEntity, -1)
+	defer op.end(&err)
+
+	date = date.UTC()
+	rec = AuditRecord{Action: AuditEnd, EntityID: id, Date: &date, Reason: reason}
+	defer func() { a.emitAudit(&rec, err) }()
+
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		MATCH (e:Entity {id: $id})
+		OPTIONAL MATCH (e)-[r:HAS_NAME|HAS_COUNTRY|HAS_IDENTIFIER|HAS_SECURITY]->()
+			WHERE r.until IS NULL OR r.until > $date
+		SET r.until = CASE WHEN r.from > $date THEN r.from ELSE $date END
+		WITH e, count(r) AS ended
+		RETURN ended,
+			[(e)-[:HAS_IDENTIFIER]->(i:Identifier) | {type: i.type, value: i.value}] +
+			[(e)-[:HAS_SECURITY]->(:Security)-[:HAS_IDENTIFIER]->(i:Identifier) | {type: i.type, value: i.value}] AS identifiers
+	`)
+	qb.params["id"] = id.String()
+	qb.params["date"] = dateToOptionalString(&date)
+
+	var row struct {
+		Ended       int              `neo4j:"ended"`
+		Identifiers []identifierNode `neo4j:"identifiers"`
+	}
+	if err := a.writeEntity(ctx, qb, &row); err != nil {
+		return rec, fmt.Errorf("end entity %s: %w", id, err)
+	}
+	rec.RelationshipsEnded = row.Ended
+	a.invalidateIdentifiers(row.Identifiers)
+
+	return rec, nil
+}
+
+// EraseEntity hard deletes an entity with its names, countries and securities,
+// and the identifiers no longer related to anything. Nothing of the entity is
+// kept apart from the audit record, so only use it when the history has to go
+// as well, otherwise use EndEntity.
+func (a *Adapter) EraseEntity(ctx context.Context, id uuid.UUID, reason string) (rec AuditRecord, err error) {
+	ctx, op := a.tel.start(ctx, opEraseEntity, -1)
+	defer op.end(&err)
+
+	rec = AuditRecord{Action: AuditErase, EntityID: id, Reason: reason}
+	defer func() { a.emitAudit(&rec, err) }()
+
+	// identifiers are shared between entities, so are only deleted once the
+	// relationships of the entity and its securities are gone
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		MATCH (e:Entity {id: $id})
+		WITH e,
+			[(e)-[:HAS_NAME|HAS_COUNTRY|HAS_SECURITY]->(n) | n] AS owned,
+			[(e)-[:HAS_IDENTIFIER]->(i:Identifier) | i] +
+			[(e)-[:HAS_SECURITY]->(:Security)-[:HAS_IDENTIFIER]->(i:Identifier) | i] AS identifiers
+		WITH e, owned, identifiers,
+			[i IN identifiers | {type: i.type, value: i.value}] AS identifier_values
+		FOREACH (n IN owned | DETACH DELETE n)
+		DETACH DELETE e
+		WITH size(owned) + 1 AS deleted, identifiers, identifier_values
+		CALL {
+			WITH identifiers
+			UNWIND identifiers AS i
+			WITH DISTINCT i
+			WHERE NOT (i)--()
+			DELETE i
+			RETURN count(i) AS orphans
+		}
+		RETURN deleted, orphans, identifier_values AS identifiers
+	`)
+	qb.params["id"] = id.String()
+
+	var row struct {
+		Deleted     int              `neo4j:"deleted"`
+		Orphans     int              `neo4j:"orphans"`
+		Identifiers []identifierNode `neo4j:"identifiers"`
+	}
+	if err := a.writeEntity(ctx, qb, &row); err != nil {
+		return rec, fmt.Errorf("erase entity %s: %w", id, err)
+	}
+	rec.NodesDeleted = row.Deleted
+	rec.IdentifiersDeleted = row.Orphans
+	a.invalidateIdentifiers(row.Identifiers)
+
+	return rec, nil
+}
+
+// writeEntity runs a write query returning a single row for the entity and
+// decodes it into dst. No row means the entity doesn't exist.
+func (a *Adapter) writeEntity(ctx context.Context, qb *queryBuilder, dst any) error {
+	return a.resilient(ctx, func(ctx context.Context) error {
+		session := a.newSession(ctx, neo4j.AccessModeWrite)
+		defer session.Close(ctx)
+
+		_, err := session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
+			start := time.Now()
+			result, err := tx.Run(ctx, a.queryText(qb), qb.params)
+			if err != nil {
+				return nil, fmt.Errorf("run: %w", err)
+			}
+
+			found := result.Next(ctx)
+			if found {
+				if err := decodeRecord(result.Record(), dst); err != nil {
+					return nil, err
+				}
+			}
+			if err = result.Err(); err != nil {
+				return nil, fmt.Errorf("result error: %w", err)
+			}
+
+			summary, err := result.Consume(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("consume: %w", err)
+			}
+			a.recordSummary(ctx, qb, summary, time.Since(start))
+
+			if !found {
+				return nil, ErrEntityNotFound
+			}
+			return nil, nil
+		})
+		return err
+	})
+}
+
+// invalidateIdentifiers removes the cached lookups of the identifiers of a
+// deleted entity.
+func (a *Adapter) invalidateIdentifiers(nodes []identifierNode) {
+	if a.cache == nil {
+		return
+	}
+	identifiers := make([]resolve.Identifier, 0, len(nodes))
+	for _, idn := range nodes {
+		identifiers = append(identifiers, idn.identifier())
+	}
+	a.cache.invalidate(identifiers)
+}
+
+// emitAudit completes rec and passes it to the audit log.
+func (a *Adapter) emitAudit(rec *AuditRecord, err error) {
+	rec.At = time.Now().UTC()
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if a.audit != nil {
+		a.audit(*rec)
+	}
+}
@@ -0,0 +1,93 @@
+package n4j
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdapter_EndEntity(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.Must(uuid.NewV4())
+	date := time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC)
+
+	db := &recordingDB{}
+	db.reply(map[string]any{
+		"ended":       int64(3),
+		"identifiers": []any{map[string]any{"type": "isin", "value": "US0000000001"}},
+	})
+
+	var audit []AuditRecord
+	a := newAdapter(db,
+		WithLookupCache(LookupCacheConfig{Size: 10}),
+		WithAuditLog(func(rec AuditRecord) { audit = append(audit, rec) }),
+	)
+	a.cache.put(a.cache.generation(), testLookupKey("US0000000001"), nil)
+
+	rec, err := a.EndEntity(ctx, id, date.In(time.FixedZone("CET", 3600)), "ticket-1")
+	require.NoError(t, err)
+	params := db.Queries()[0].Params
+	require.Equal(t, id.String(), params["id"])
+	require.Equal(t, "2021-02-09T00:00:00Z", *params["date"].(*string))
+
+	require.Equal(t, AuditEnd, rec.Action)
+	require.Equal(t, id, rec.EntityID)
+	require.Equal(t, date, *rec.Date)
+	require.Equal(t, "ticket-1", rec.Reason)
+	require.Equal(t, 3, rec.RelationshipsEnded)
+	require.False(t, rec.At.IsZero())
+	require.Equal(t, []AuditRecord{rec}, audit)
+
+	// the lookups of the entity's identifiers are invalidated
+	require.Equal(t, 0, a.LookupCacheStats().Len)
+}
+
+func TestAdapter_EraseEntity(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.Must(uuid.NewV4())
+
+	db := &recordingDB{}
+	db.reply(map[string]any{
+		"deleted":     int64(4),
+		"orphans":     int64(1),
+		"identifiers": []any{},
+	})
+
+	var audit []AuditRecord
+	a := newAdapter(db, WithAuditLog(func(rec AuditRecord) { audit = append(audit, rec) }))
+
+	rec, err := a.EraseEntity(ctx, id, "ticket-2")
+	require.NoError(t, err)
+	require.Equal(t, AuditErase, rec.Action)
+	require.Nil(t, rec.Date)
+	require.Equal(t, 4, rec.NodesDeleted)
+	require.Equal(t, 1, rec.IdentifiersDeleted)
+	require.Empty(t, rec.Error)
+	require.Equal(t, []AuditRecord{rec}, audit)
+}
+
+func TestAdapter_DeleteErrors(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.Must(uuid.NewV4())
+
+	var audit []AuditRecord
+	db := &recordingDB{}
+	db.reply()
+	db.fail(errSyntax)
+	a := newAdapter(db, WithAuditLog(func(rec AuditRecord) { audit = append(audit, rec) }))
+
+	// failed deletions are audited too
+	_, err := a.EndEntity(ctx, id, time.Now(), "")
+	require.ErrorIs(t, err, ErrEntityNotFound)
+	_, err = a.EraseEntity(ctx, id, "")
+	require.ErrorIs(t, err, errSyntax)
+
+	require.Len(t, audit, 2)
+	require.Contains(t, audit[0].Error, "entity not found")
+	require.Equal(t, AuditErase, audit[1].Action)
+	require.Contains(t, audit[1].Error, "invalid input")
+	require.Len(t, db.Queries(), 2)
+}
@@ -0,0 +1,210 @@
+package n4j
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+const defaultExportPageSize = 1000
+
+// ExportEntities streams every entity with its full history to fn, in order of
+// entity id. Entities are read in pages of pageSize, each page in its own read
+// transaction, so the export does not hold a transaction open for the whole
+// database.
+func (a *Adapter) ExportEntities(ctx context.Context, pageSize int, fn func(*resolve.Entity) error) (err error) {
+	ctx, op := a.tel.start(ctx, opExport, -1)
+	defer op.end(&err)
+
+	session := a.newSession(ctx, neo4j.AccessModeRead)
+	defer session.Close(ctx)
+
+	if pageSize <= 0 {
+		pageSize = defaultExportPageSize
+	}
+
+	var after string
+	for {
+		var entities []*resolve.Entity
+		err := a.resilient(ctx, func(ctx context.Context) error {
+			var err error
+			entities, err = executeRead(ctx, session, a.getEntityPage(ctx, after, pageSize))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("export entities after %q: %w", after, err)
+		}
+
+		for _, entity := range entities {
+			if err := fn(entity); err != nil {
+				return err
+			}
+		}
+
+		if len(entities) < pageSize {
+			return nil
+		}
+		after = entities[len(entities)-1].ID.String()
+	}
+}
+
+func (a *Adapter) getEntityPage(ctx context.Context, after string, limit int) func(tx dbTx) ([]*resolve.Entity, error) {
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		MATCH (e:Entity)
+			WHERE e.id > $after
+		WITH e ORDER BY e.id LIMIT $limit
+		RETURN e.id AS id,
+			[(e)-[h:HAS_NAME]->(n:Name) | {value: n.value, from: h.from, until: h.until}] AS names,
+			[(e)-[h:HAS_COUNTRY]->(c:Country) | {value: c.value, from: h.from, until: h.until}] AS countries,
+			[(e)-[h:HAS_IDENTIFIER]->(i:Identifier) | {type: i.type, value: i.value, from: h.from, until: h.until}] AS identifiers,
+			[(e)-[h:HAS_SECURITY]->(s:Security) | {
+				name: s.name, primary: s.primary, from: h.from, until: h.until,
+				identifiers: [(s)-[:HAS_IDENTIFIER]->(si:Identifier) | {type: si.type, value: si.value}]
+			}] AS securities
+		ORDER BY id
+	`)
+	qb.params["after"] = after
+	qb.params["limit"] = limit
+
+	return func(tx dbTx) ([]*resolve.Entity, error) {
+		start := time.Now()
+		result, err := tx.Run(ctx, a.queryText(qb), qb.params)
+		if err != nil {
+			return nil, fmt.Errorf("run: %w", err)
+		}
+
+		entities := make([]*resolve.Entity, 0, limit)
+		for result.Next(ctx) {
+			entity, err := mapExportRecord(result.Record())
+			if err != nil {
+				return nil, err
+			}
+			entities = append(entities, entity)
+		}
+		if err = result.Err(); err != nil {
+			return nil, fmt.Errorf("result error: %w", err)
+		}
+
+		summary, err := result.Consume(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("consume: %w", err)
+		}
+		a.recordSummary(ctx, qb, summary, time.Since(start))
+
+		return entities, nil
+	}
+}
+
+// exportRecord is a row returned by getEntityPage.
+type exportRecord struct {
+	ID          uuid.UUID         `neo4j:"id"`
+	Names       []datedValue      `neo4j:"names"`
+	Countries   []datedValue      `neo4j:"countries"`
+	Identifiers []datedIdentifier `neo4j:"identifiers"`
+	Securities  []datedSecurity   `neo4j:"securities"`
+}
+
+type datedValue struct {
+	Value string     `neo4j:"value"`
+	From  time.Time  `neo4j:"from"`
+	Until *time.Time `neo4j:"until"`
+}
+
+type datedIdentifier struct {
+	identifierNode
+	From  time.Time  `neo4j:"from"`
+	Until *time.Time `neo4j:"until"`
+}
+
+type datedSecurity struct {
+	securityNode
+	Identifiers []identifierNode `neo4j:"identifiers"`
+	From        time.Time        `neo4j:"from"`
+	Until       *time.Time       `neo4j:"until"`
+}
+
+func mapExportRecord(record *neo4j.Record) (*resolve.Entity, error) {
+	var row exportRecord
+	if err := decodeRecord(record, &row); err != nil {
+		return nil, fmt.Errorf("decode entity: %w", err)
+	}
+
+	entity := resolve.Entity{ID: row.ID}
+
+	for _, n := range row.Names {
+		entity.Name = append(entity.Name, resolve.DetailDuration[resolve.EntityName]{
+			Detail:   resolve.EntityName{Value: n.Value},
+			Duration: resolve.Duration{StartDate: n.From, EndDate: n.Until},
+		})
+	}
+
+	for _, c := range row.Countries {
+		entity.Country = append(entity.Country, resolve.DetailDuration[resolve.EntityCountry]{
+			Detail:   resolve.EntityCountry{Value: c.Value},
+			Duration: resolve.Duration{StartDate: c.From, EndDate: c.Until},
+		})
+	}
+
+	// each identifier has its own relation, group them back into durations
+	identifierIdx := map[periodKey]int{}
+	for _, i := range row.Identifiers {
+		duration := resolve.Duration{StartDate: i.From, EndDate: i.Until}
+		key := durationKey(duration)
+		j, ok := identifierIdx[key]
+		if !ok {
+			j = len(entity.Identifiers)
+			identifierIdx[key] = j
+			entity.Identifiers = append(entity.Identifiers, resolve.DetailDuration[[]resolve.Identifier]{
+				Duration: duration,
+			})
+		}
+		entity.Identifiers[j].Detail = append(entity.Identifiers[j].Detail, i.identifier())
+	}
+
+	securityIdx := map[periodKey]int{}
+	for _, s := range row.Securities {
+		security := resolve.Security{
+			Name:      s.Name,
+			IsPrimary: s.Primary != nil && *s.Primary,
+		}
+		for _, si := range s.Identifiers {
+			security.Identifiers = append(security.Identifiers, si.identifier())
+		}
+
+		duration := resolve.Duration{StartDate: s.From, EndDate: s.Until}
+		key := durationKey(duration)
+		j, ok := securityIdx[key]
+		if !ok {
+			j = len(entity.Securities)
+			securityIdx[key] = j
+			entity.Securities = append(entity.Securities, resolve.DetailDuration[[]resolve.Security]{
+				Duration: duration,
+			})
+		}
+		entity.Securities[j].Detail = append(entity.Securities[j].Detail, security)
+	}
+
+	entity.Normalize()
+
+	return &entity, nil
+}
+
+type periodKey struct {
+	from, until int64
+	open        bool
+}
+
+// durationKey returns a comparable value for grouping details by duration,
+// as the EndDate pointers differ between relations.
+func durationKey(d resolve.Duration) periodKey {
+	if d.EndDate == nil {
+		return periodKey{from: d.StartDate.Unix(), open: true}
+	}
+	return periodKey{from: d.StartDate.Unix(), until: d.EndDate.Unix()}
+}
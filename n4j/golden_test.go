@@ -0,0 +1,190 @@
+package n4j
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/require"
+)
+
+// e.g. go test ./n4j -run Golden -update
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// requireGolden compares the queries with testdata/<name>.golden, which has the
+// exact Cypher of each query and its params with their Go types.
+func requireGolden(t *testing.T, name string, queries []recordedQuery) {
+	t.Helper()
+
+	var sb strings.Builder
+	for i, q := range queries {
+		fmt.Fprintf(&sb, "// query %d (%s)\n%s\n// params\n", i+1, modeName(q.Mode), strings.TrimSpace(dedent(q.Cypher)))
+		writeParams(&sb, reflect.ValueOf(q.Params), "")
+		sb.WriteString("\n")
+	}
+	got := sb.String()
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(got), 0o644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "run with -update to create the golden file")
+	require.Equal(t, string(want), got)
+}
+
+func modeName(mode neo4j.AccessMode) string {
+	if mode == neo4j.AccessModeRead {
+		return "read"
+	}
+	return "write"
+}
+
+// dedent removes the indentation of the query literals in the source.
+func dedent(query string) string {
+	lines := strings.Split(query, "\n")
+	indent := ""
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lead := line[:len(line)-len(strings.TrimLeft(line, "\t"))]
+		if indent == "" || len(lead) < len(indent) {
+			indent = lead
+		}
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, indent)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeParams writes v as an indented tree with the type of every value, with
+// map keys sorted so the output is stable.
+func writeParams(sb *strings.Builder, v reflect.Value, indent string) {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid, reflect.Interface:
+		sb.WriteString("nil\n")
+	case reflect.Map:
+		fmt.Fprintf(sb, "%s\n", v.Type())
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			fmt.Fprintf(sb, "%s%s: ", indent+"  ", k)
+			writeParams(sb, v.MapIndex(k), indent+"  ")
+		}
+	case reflect.Slice:
+		fmt.Fprintf(sb, "%s len %d\n", v.Type(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			fmt.Fprintf(sb, "%s- ", indent+"  ")
+			writeParams(sb, v.Index(i), indent+"  ")
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			fmt.Fprintf(sb, "%s nil\n", v.Type())
+			return
+		}
+		fmt.Fprintf(sb, "%s %#v\n", v.Type(), v.Elem().Interface())
+	default:
+		fmt.Fprintf(sb, "%s %#v\n", v.Type(), v.Interface())
+	}
+}
+
+func TestGolden_CreateEntities(t *testing.T) {
+	from := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	entity := &resolve.Entity{
+		ID: uuid.Must(uuid.FromString("1b4e28ba-2fa1-11d2-883f-0016d3cca427")),
+		Name: []resolve.DetailDuration[resolve.EntityName]{
+			{Detail: resolve.EntityName{Value: "Acme Ltd"}, Duration: resolve.Duration{StartDate: from, EndDate: &until}},
+			{Detail: resolve.EntityName{Value: "Acme Plc"}, Duration: resolve.Duration{StartDate: until}},
+		},
+		Country: []resolve.DetailDuration[resolve.EntityCountry]{
+			{Detail: resolve.EntityCountry{Value: "GB"}, Duration: resolve.Duration{StartDate: from}},
+		},
+		Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{
+			{Detail: []resolve.Identifier{{Type: "sray_entity_id", Value: "42"}}, Duration: resolve.Duration{StartDate: from}},
+		},
+		Securities: []resolve.DetailDuration[[]resolve.Security]{
+			{
+				Detail: []resolve.Security{
+					{Name: "Acme Ord", Identifiers: []resolve.Identifier{{Type: "isin", Value: "GB0002634946"}}, IsPrimary: true},
+				},
+				Duration: resolve.Duration{StartDate: from, EndDate: &until},
+			},
+		},
+	}
+
+	db := &recordingDB{}
+	a := newAdapter(db, WithDataset("golden"))
+	require.NoError(t, a.CreateEntities(context.Background(), []*resolve.Entity{entity}))
+
+	requireGolden(t, "create_entities", db.Queries())
+}
+
+func TestGolden_LookupEntities(t *testing.T) {
+	date := time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC)
+	lookups := []resolve.Lookup{
+		{Date: &date, Identifier: resolve.Identifier{Type: "isin", Value: "GB0002634946"}},
+		{Identifier: resolve.Identifier{Type: "sray_entity_id", Value: "missing"}},
+	}
+
+	db := &recordingDB{}
+	db.reply(
+		map[string]any{
+			"lookup":               map[string]any{"type": "isin", "value": "GB0002634946", "date": "2021-02-09T00:00:00Z"},
+			"entity":               node(map[string]any{"id": "1b4e28ba-2fa1-11d2-883f-0016d3cca427"}, "Entity"),
+			"name":                 node(map[string]any{"value": "Acme Plc"}, "Name"),
+			"identifiers":          []any{node(map[string]any{"type": "sray_entity_id", "value": "42"}, "Identifier")},
+			"securities":           []any{node(map[string]any{"name": "Acme Ord", "primary": true}, "Security")},
+			"security_identifiers": []any{node(map[string]any{"type": "isin", "value": "GB0002634946"}, "Identifier")},
+		},
+		map[string]any{
+			"lookup":               map[string]any{"type": "sray_entity_id", "value": "missing", "date": nil},
+			"entity":               nil,
+			"name":                 nil,
+			"identifiers":          []any{},
+			"securities":           []any{},
+			"security_identifiers": []any{},
+		},
+	)
+
+	a := newAdapter(db)
+	a.now = func() time.Time { return date.AddDate(1, 0, 0) }
+	res, err := a.LookupEntities(context.Background(), lookups)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.True(t, res[0].Success)
+	require.Equal(t, "Acme Plc", res[0].Entity.Name[0].Detail.Value)
+	require.False(t, res[1].Success)
+
+	requireGolden(t, "lookup_entities", db.Queries())
+}
+
+func TestRecordingDB_Fail(t *testing.T) {
+	db := &recordingDB{}
+	db.fail(errSyntax)
+
+	a := newAdapter(db)
+	_, err := a.LookupEntities(context.Background(), testLookups)
+	require.ErrorIs(t, err, errSyntax)
+	require.Len(t, db.Queries(), 1)
+	require.Equal(t, neo4j.AccessModeRead, db.Queries()[0].Mode)
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,19 +14,71 @@ import (
 
 	"github.com/gofrs/uuid"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	dbName = "neo4j"
+
+	connectTimeout = 30 * time.Second
 )
 
 type Adapter struct {
-	driver neo4j.DriverWithContext
+	db database
+
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	tel            *telemetry
+
+	profile   bool
+	slowQuery time.Duration
+	slowLog   *log.Logger
+	onQuery   func(QuerySummary)
+
+	cache *lookupCache
+
+	retry   *RetryPolicy
+	budget  *retryBudget
+	breaker *breaker
+
+	bookmarks neo4j.BookmarkManager
+
+	audit func(AuditRecord)
+
+	tenant  string
+	dataset string
+
+	// now is the time undated lookups resolve at
+	now func() time.Time
+
+	lookupStrategy LookupStrategy
 }
 
-func NewAdapter(driver neo4j.DriverWithContext) *Adapter {
-	return &Adapter{
-		driver: driver,
+func NewAdapter(driver neo4j.DriverWithContext, opts ...Option) *Adapter {
+	return newAdapter(driverDB{driver}, opts...)
+}
+
+// newAdapter returns an adapter running queries on db, which tests use to run
+// queries on a fake.
+func newAdapter(db database, opts ...Option) *Adapter {
+	a := &Adapter{
+		db:             db,
+		now:            time.Now,
+		lookupStrategy: UnwindLookups,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.tel = newTelemetry(a.tracerProvider, a.meterProvider)
+
+	return a
+}
+
+// WithClock sets the time undated lookups resolve at, defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(a *Adapter) {
+		a.now = now
 	}
 }
 
@@ -43,38 +94,44 @@ func newQueryBuilder() *queryBuilder {
 	}
 }
 
-// ToQueryWithParams generates a query with the params replaced by values and
-// whitespace cleaned up. This can be used to log the query so it can be used
-// with EXPLAIN and PROFILE. However it does not work with slices.
+// ToQueryWithParams generates a query with the params replaced by Cypher
+// literals and whitespace cleaned up. This can be used to log the query so it
+// can be used with EXPLAIN and PROFILE.
 func (qb *queryBuilder) ToQueryWithParams() string {
-	s := qb.String()
-
-	var sortedKeys [][]string
-	// sort key of from longest to shortest to avoid replacing params too early
-	for k, v := range qb.params {
-		sortedKeys = append(sortedKeys, []string{k, fmt.Sprint(v)})
-	}
-	sort.Slice(sortedKeys, func(i, j int) bool {
-		return len(sortedKeys[i][0]) > len(sortedKeys[j][0])
-	})
-
-	for _, kv := range sortedKeys {
-		s = strings.ReplaceAll(s, fmt.Sprintf(`$%s`, kv[0]), fmt.Sprintf(`'%v'`, kv[1]))
-	}
+	return cleanQuery(replaceParams(qb.String(), qb.params))
+}
 
+// cleanQuery removes the indentation and blank lines of a query.
+func cleanQuery(s string) string {
 	s = strings.ReplaceAll(s, "\t", "")
 	s = strings.ReplaceAll(s, "\n\n", "\n")
 	return s
 }
 
-// Cleanup removes all existing nodes and relations.
-func (a *Adapter) Cleanup(ctx context.Context) error {
-	session := a.driver.NewSession(ctx, neo4j.SessionConfig{DatabaseName: dbName})
+// Cleanup removes all existing nodes and relations, and drops the indexes. Use
+// CleanupScoped to only remove some of the data.
+func (a *Adapter) Cleanup(ctx context.Context) (err error) {
+	ctx, op := a.tel.start(ctx, opCleanup, -1)
+	defer op.end(&err)
+
+	if a.cache != nil {
+		defer a.cache.clear()
+	}
+
+	if err := a.resilient(ctx, a.dropIndexes); err != nil {
+		return err
+	}
+	_, err = a.cleanupScope(ctx, CleanupConfig{})
+	return err
+}
+
+func (a *Adapter) dropIndexes(ctx context.Context) error {
+	session := a.newSession(ctx, neo4j.AccessModeWrite)
 	defer session.Close(ctx)
 
 	var err error
 
-	_, err = session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+	_, err = session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
 		_, err := tx.Run(ctx, `
 			DROP CONSTRAINT entity_id IF EXISTS
 			`, map[string]any{},
@@ -85,7 +142,7 @@ func (a *Adapter) Cleanup(ctx context.Context) error {
 		return fmt.Errorf("execute drop identifier_type_value: %w", err)
 	}
 
-	_, err = session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+	_, err = session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
 		_, err := tx.Run(ctx, `
 			DROP INDEX identifier_type_value IF EXISTS
 			`, map[string]any{},
@@ -96,7 +153,7 @@ func (a *Adapter) Cleanup(ctx context.Context) error {
 		return fmt.Errorf("execute drop identifier_type_value: %w", err)
 	}
 
-	_, err = session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+	_, err = session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
 		_, err := tx.Run(ctx, `
 			DROP INDEX identifier_duration IF EXISTS
 			`, map[string]any{},
@@ -107,24 +164,25 @@ func (a *Adapter) Cleanup(ctx context.Context) error {
 		return fmt.Errorf("execute drop identifier_duration: %w", err)
 	}
 
-	// Use implicit transactions to delete nodes in batches
-	_, err = session.Run(ctx, `
-		MATCH (n)
-		CALL {
-			WITH n
-			DETACH DELETE n
-		} IN TRANSACTIONS OF 10000 ROWS
-	`, nil, neo4j.WithTxTimeout(20*time.Minute))
+	_, err = session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
+		_, err := tx.Run(ctx, `
+			DROP INDEX lookup_projection IF EXISTS
+			`, map[string]any{},
+		)
+		return nil, err
+	})
 	if err != nil {
-		return fmt.Errorf("execute delete: %w", err)
+		return fmt.Errorf("execute drop lookup_projection: %w", err)
 	}
 
 	return nil
 }
 
-func createIndex(ctx context.Context, session neo4j.SessionWithContext) error {
-	var err error
-	_, err = session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+func (a *Adapter) createIndex(ctx context.Context, session dbSession) (err error) {
+	ctx, op := a.tel.start(ctx, opSchema, -1)
+	defer op.end(&err)
+
+	_, err = session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
 		_, err := tx.Run(ctx, `
 			CREATE CONSTRAINT entity_id IF NOT EXISTS
 			FOR (e:Entity) REQUIRE e.id IS UNIQUE
@@ -139,7 +197,7 @@ func createIndex(ctx context.Context, session neo4j.SessionWithContext) error {
 	// Can also use NODE KEY to enforce a unique constraint on identifier type,value
 	// But this is an enterprise feature. The index speeds up lookup but cannot
 	// enforce uniqueness.
-	_, err = session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+	_, err = session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
 		_, err := tx.Run(ctx, `
 			CREATE INDEX identifier_type_value IF NOT EXISTS
 			FOR (idn:Identifier) ON (idn.type,idn.value)
@@ -151,7 +209,7 @@ func createIndex(ctx context.Context, session neo4j.SessionWithContext) error {
 		return fmt.Errorf("create index identifier_type_value: %w", err)
 	}
 
-	_, err = session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+	_, err = session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
 		_, err := tx.Run(ctx, `
 			CREATE INDEX identifier_duration IF NOT EXISTS
 			FOR ()-[h:HAS_IDENTIFIER]-() ON (h.from,h.until)
@@ -166,308 +224,382 @@ func createIndex(ctx context.Context, session neo4j.SessionWithContext) error {
 	return nil
 }
 
-func (a *Adapter) LookupEntities(ctx context.Context, lookups []resolve.Lookup) ([]resolve.LookupResult, error) {
-	session := a.driver.NewSession(ctx, neo4j.SessionConfig{DatabaseName: dbName, AccessMode: neo4j.AccessModeRead})
-	defer session.Close(ctx)
-
-	// timerStart := time.Now()
+func (a *Adapter) LookupEntities(ctx context.Context, lookups []resolve.Lookup) (_ []resolve.LookupResult, err error) {
+	ctx, op := a.tel.start(ctx, opLookup, len(lookups))
+	defer op.end(&err)
 
-	res, err := neo4j.ExecuteRead(ctx, session, getLookupResults(ctx, lookups))
+	res, err := a.cachedLookups(ctx, op, lookups, a.queryLookups)
 	if err != nil {
 		return nil, fmt.Errorf("lookup entities: %w", err)
 	}
-	// fmt.Printf("time taken: %v\n", time.Since(timerStart))
+	op.recordLookups(countFound(res), len(res))
 
 	return res, nil
 }
 
-func (a *Adapter) LookupEntitiesConcurrent(ctx context.Context, lookups []resolve.Lookup, threads int) ([]resolve.LookupResult, error) {
-	session := a.driver.NewSession(ctx, neo4j.SessionConfig{DatabaseName: dbName, AccessMode: neo4j.AccessModeRead})
-	defer session.Close(ctx)
+func (a *Adapter) LookupEntitiesConcurrent(ctx context.Context, lookups []resolve.Lookup, threads int) (_ []resolve.LookupResult, err error) {
+	ctx, op := a.tel.start(ctx, opLookupConcurrent, len(lookups))
+	defer op.end(&err)
+
+	res, err := a.cachedLookups(ctx, op, lookups, func(ctx context.Context, lookups []resolve.Lookup) ([]lookupRow, error) {
+		session := a.newSession(ctx, neo4j.AccessModeRead)
+		defer session.Close(ctx)
 
-	// timerStart := time.Now()
+		// the first failed chunk cancels the others
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
 
-	wg := &sync.WaitGroup{}
+		wg := &sync.WaitGroup{}
 
-	n := len(lookups)
+		n := len(lookups)
 
-	countPerChunk := n / threads
+		// at least one lookup per chunk, e.g. when only a few lookups missed
+		// the cache
+		countPerChunk := int(math.Max(1, float64(n/threads)))
 
-	lookupRes := make(chan []resolve.LookupResult)
+		lookupRes := make(chan chunkRows)
 
-	var i int
-	for i < n {
-		wg.Add(1)
+		var i int
+		for i < n {
+			wg.Add(1)
 
-		j := int(math.Min(float64(n), float64(i+countPerChunk)))
-		lookupChunk := lookups[i:j]
-		i = j
+			j := int(math.Min(float64(n), float64(i+countPerChunk)))
+			lookupChunk := lookups[i:j]
+			i = j
 
-		go runQuery(wg, ctx, session, lookupRes, lookupChunk)
+			go a.runQuery(wg, ctx, session, lookupRes, lookupChunk)
+		}
+		// Wait for all runner routines to be done before closing log
+		go func() {
+			wg.Wait()
+			close(lookupRes)
+		}()
+
+		rows := make([]lookupRow, 0, len(lookups))
+
+		var firstErr error
+		for r := range lookupRes {
+			if r.err != nil {
+				if firstErr == nil {
+					firstErr = r.err
+					cancel()
+				}
+				continue
+			}
+			rows = append(rows, r.rows...)
+		}
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return rows, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("lookup entities: %w", err)
 	}
-	// Wait for all runner routines to be done before closing log
-	go func() {
-		wg.Wait()
-		close(lookupRes)
-	}()
+	op.recordLookups(countFound(res), len(res))
 
-	res := make([]resolve.LookupResult, 0, len(lookups))
+	return res, nil
+}
 
-	for r := range lookupRes {
-		res = append(res, r...)
-	}
+// queryLookups runs the lookups in a single read transaction.
+func (a *Adapter) queryLookups(ctx context.Context, lookups []resolve.Lookup) ([]lookupRow, error) {
+	session := a.newSession(ctx, neo4j.AccessModeRead)
+	defer session.Close(ctx)
 
-	// fmt.Printf("time taken: %v\n", time.Since(timerStart))
+	var rows []lookupRow
+	err := a.resilient(ctx, func(ctx context.Context) error {
+		var err error
+		rows, err = executeRead(ctx, session, a.getLookupResults(ctx, lookups))
+		return err
+	})
+	return rows, err
+}
 
-	return res, nil
+// chunkRows are the rows of a chunk of lookups, or the error looking them up.
+type chunkRows struct {
+	rows []lookupRow
+	err  error
 }
 
-// Run Neo4j query with random sleep time, returning the sleep time in ms
-func runQuery(wg *sync.WaitGroup, ctx context.Context, session neo4j.SessionWithContext, lookupRes chan []resolve.LookupResult, lookups []resolve.Lookup) error {
+// runQuery looks up a chunk of lookups, sending the rows or the error to
+// lookupRes.
+func (a *Adapter) runQuery(wg *sync.WaitGroup, ctx context.Context, session dbSession, lookupRes chan chunkRows, lookups []resolve.Lookup) (err error) {
 	defer wg.Done() // will communicate that routine is done
 
-	res, err := neo4j.ExecuteRead(ctx, session, getLookupResults(ctx, lookups))
+	ctx, op := a.tel.start(ctx, opLookupChunk, len(lookups))
+	defer op.end(&err)
+
+	var res []lookupRow
+	err = a.resilient(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = executeRead(ctx, session, a.getLookupResults(ctx, lookups))
+		return err
+	})
+
+	lookupRes <- chunkRows{rows: res, err: err}
+	return err
+}
+
+// getLookupResults queries the lookups with the adapter's lookup strategy.
+func (a *Adapter) getLookupResults(ctx context.Context, lookups []resolve.Lookup) func(tx dbTx) ([]lookupRow, error) {
+	return func(tx dbTx) ([]lookupRow, error) {
+		return a.queryStrategy(ctx, tx, a.lookupStrategy, lookups)
+	}
+}
+
+// readRecords runs the query, passing each record to fn, and records its
+// summary.
+func (a *Adapter) readRecords(ctx context.Context, tx dbTx, qb *queryBuilder, fn func(*neo4j.Record) error) error {
+	start := time.Now()
+	result, err := tx.Run(ctx, a.queryText(qb), qb.params)
 	if err != nil {
-		return fmt.Errorf("lookup entities: %w", err)
+		return fmt.Errorf("run: %w", err)
 	}
 
-	lookupRes <- res
-	return nil
-}
+	for result.Next(ctx) {
+		if err := fn(result.Record()); err != nil {
+			return err
+		}
+	}
 
-func getLookupResults(ctx context.Context, lookups []resolve.Lookup) func(tx neo4j.ManagedTransaction) ([]resolve.LookupResult, error) {
-	// fmt.Println("getLookupResults: ", lookups[0].Identifier, len(lookups))
+	if err = result.Err(); err != nil {
+		return fmt.Errorf("result error: %w", err)
+	}
 
-	lookupList := make([][]any, 0, len(lookups))
-	for _, lookup := range lookups {
-		lookupList = append(lookupList, []any{
-			string(lookup.Identifier.Type),
-			lookup.Identifier.Value,
-			dateToOptionalString(lookup.Date), // can be null
-		})
+	summary, err := result.Consume(ctx)
+	if err != nil {
+		return fmt.Errorf("consume: %w", err)
 	}
+	a.recordSummary(ctx, qb, summary, time.Since(start))
 
-	qb := newQueryBuilder()
-	qb.WriteString(`
-		WITH $lookupList as lookups
-		UNWIND lookups AS lookup
-		OPTIONAL MATCH (idn:Identifier {type: lookup[0],value: lookup[1]})
-		OPTIONAL MATCH (idn)--(:Entity|Security)-[:HAS_SECURITY*0..1]-(entity:Entity)
-		OPTIONAL MATCH (entity)-[hi:HAS_IDENTIFIER]->(i:Identifier)
-			WHERE (hi.from <= lookup[2] and (hi.until IS NULL OR lookup[2] < hi.until))
-		OPTIONAL MATCH (entity)-[hn:HAS_NAME]->(name:Name)
-			WHERE (hn.from <= lookup[2] and (hn.until IS NULL OR lookup[2] < hn.until))
-		OPTIONAL MATCH (entity)-[hs:HAS_SECURITY]->(security:Security)-->(si:Identifier)
-			WHERE (hs.from <= lookup[2] and (hs.until IS NULL OR lookup[2] < hs.until))
-		RETURN lookup,entity,collect(distinct(i)) as identifiers, name, collect(distinct(security)) as securities, collect(distinct(si)) as security_identifiers
-	`)
-	qb.params["lookupList"] = lookupList
+	return nil
+}
 
-	return func(tx neo4j.ManagedTransaction) ([]resolve.LookupResult, error) {
-		result, err := tx.Run(ctx, qb.String(), qb.params)
-		if err != nil {
-			return nil, fmt.Errorf("run: %w", err)
+func countFound(results []resolve.LookupResult) int {
+	var found int
+	for _, r := range results {
+		if r.Success {
+			found++
 		}
+	}
+	return found
+}
 
-		var lookups []resolve.LookupResult
+// lookupRecord is a row returned by a lookup query, entity and name are nil
+// if nothing was found.
+type lookupRecord struct {
+	Lookup *lookupNode `neo4j:"lookup"`
+	entityDetails
+}
 
-		for result.Next(ctx) {
-			var entity resolve.Entity
+// entityDetails are the details of an entity matched by a lookup, at the
+// lookup date.
+type entityDetails struct {
+	Entity              *entityNode      `neo4j:"entity"`
+	Name                *nameNode        `neo4j:"name"`
+	Identifiers         []identifierNode `neo4j:"identifiers"`
+	Securities          []securityNode   `neo4j:"securities"`
+	SecurityIdentifiers []identifierNode `neo4j:"security_identifiers"`
+}
 
-			record := result.Record()
+// lookupNode is the lookup param a row was found for.
+type lookupNode struct {
+	Type  resolve.IdentifierType `neo4j:"type"`
+	Value string                 `neo4j:"value"`
+	Date  *string                `neo4j:"date"`
+}
 
-			entityNode, _, err := neo4j.GetRecordValue[neo4j.Node](record, "entity")
-			if err != nil {
-				return nil, fmt.Errorf("get record value for entity: %w", err)
-			}
+type entityNode struct {
+	ID uuid.UUID `neo4j:"id"`
+}
 
-			id, err := neo4j.GetProperty[string](entityNode, "id")
-			if err != nil {
-				lookups = append(lookups, resolve.LookupResult{
-					Success: false,
-				})
-				continue
-			}
+type nameNode struct {
+	Value string `neo4j:"value"`
+}
 
-			entity.ID, err = uuid.FromString(id)
-			if err != nil {
-				return nil, fmt.Errorf("uuid from string: %w", err)
-			}
+type identifierNode struct {
+	Type  resolve.IdentifierType `neo4j:"type"`
+	Value string                 `neo4j:"value"`
+}
 
-			nameNode, _, err := neo4j.GetRecordValue[neo4j.Node](record, "name")
-			if err != nil {
-				return nil, fmt.Errorf("get name: %w", err)
-			}
-			name, ok := nameNode.Props["value"]
-			if ok {
-				s := name.(string)
-				entity.Name = []resolve.DetailDuration[resolve.EntityName]{
-					{
-						Detail: resolve.EntityName{
-							Value: s,
-						},
-					},
-				}
-			}
+type securityNode struct {
+	Name    string `neo4j:"name"`
+	Primary *bool  `neo4j:"primary"`
+}
 
-			rawIdentifiers, ok := record.Get("identifiers")
-			if ok {
-				identifierNodes := rawIdentifiers.([]any)
-				identifiers := make([]resolve.Identifier, 0, len(identifierNodes))
-				for _, identifier := range identifierNodes {
-					identifierNode := identifier.(neo4j.Node)
-
-					identifiers = append(identifiers, resolve.Identifier{
-						Type:  resolve.IdentifierType(fmt.Sprint(identifierNode.Props["type"])),
-						Value: fmt.Sprint(identifierNode.Props["value"]),
-					})
-				}
-				entity.Identifiers = append(entity.Identifiers, resolve.DetailDuration[[]resolve.Identifier]{
-					Detail: identifiers,
-				})
-				// return the 'point in time' identifiers, not the full history
-			}
+func (idn identifierNode) identifier() resolve.Identifier {
+	return resolve.Identifier{
+		Type:  idn.Type,
+		Value: idn.Value,
+	}
+}
 
-			rawSecurities, ok := record.Get("securities")
-			if ok {
-				securityNodes := rawSecurities.([]any)
-				securities := make([]resolve.Security, 0, len(securityNodes))
-				for _, security := range securityNodes {
-					securityNodes := security.(neo4j.Node)
-					securities = append(securities, resolve.Security{
-						Name: fmt.Sprint(securityNodes.Props["name"]),
-					})
-				}
-				entity.Securities = append(entity.Securities, resolve.DetailDuration[[]resolve.Security]{
-					Detail: securities,
-				})
-			}
+// key returns the key of the lookup, which is the zero key for nil.
+func (l *lookupNode) key() lookupKey {
+	var key lookupKey
+	if l != nil {
+		key = lookupKey{
+			identifier: resolve.Identifier{Type: l.Type, Value: l.Value},
+		}
+		if l.Date != nil {
+			key.date = *l.Date
+		}
+	}
+	return key
+}
+
+// lookupRow is a lookup result with the key of the lookup it was found for.
+type lookupRow struct {
+	key    lookupKey
+	result resolve.LookupResult
+}
 
-			// TODO - map identifiers, security, security identifers in result
-			_, _ = record.Get("security_identifiers")
+func mapLookupRecord(record *neo4j.Record) (lookupRow, error) {
+	var row lookupRecord
+	if err := decodeRecord(record, &row); err != nil {
+		return lookupRow{}, fmt.Errorf("decode lookup: %w", err)
+	}
+	return lookupRow{key: row.Lookup.key(), result: row.result()}, nil
+}
 
-			lookups = append(lookups, resolve.LookupResult{
-				Success: true,
-				Entity:  &entity,
-			})
-		}
+// result returns the lookup result of the details, which is unsuccessful
+// without an entity.
+func (d entityDetails) result() resolve.LookupResult {
+	if d.Entity == nil {
+		return resolve.LookupResult{Success: false}
+	}
 
-		if err = result.Err(); err != nil {
-			return nil, fmt.Errorf("result error: %w", err)
+	entity := resolve.Entity{ID: d.Entity.ID}
+
+	if d.Name != nil {
+		entity.Name = []resolve.DetailDuration[resolve.EntityName]{
+			{
+				Detail: resolve.EntityName{
+					Value: d.Name.Value,
+				},
+			},
 		}
+	}
+
+	// return the 'point in time' identifiers, not the full history
+	identifiers := make([]resolve.Identifier, 0, len(d.Identifiers))
+	for _, idn := range d.Identifiers {
+		identifiers = append(identifiers, idn.identifier())
+	}
+	entity.Identifiers = append(entity.Identifiers, resolve.DetailDuration[[]resolve.Identifier]{
+		Detail: identifiers,
+	})
+
+	securities := make([]resolve.Security, 0, len(d.Securities))
+	for _, sec := range d.Securities {
+		securities = append(securities, resolve.Security{
+			Name:      sec.Name,
+			IsPrimary: sec.Primary != nil && *sec.Primary,
+		})
+	}
+	entity.Securities = append(entity.Securities, resolve.DetailDuration[[]resolve.Security]{
+		Detail: securities,
+	})
+
+	// TODO - map security identifiers to their securities in result
 
-		return lookups, err
+	return resolve.LookupResult{
+		Success: true,
+		Entity:  &entity,
 	}
 }
 
-func (a *Adapter) CreateEntities(ctx context.Context, entities []*resolve.Entity) error {
-	session := a.driver.NewSession(ctx, neo4j.SessionConfig{DatabaseName: dbName, AccessMode: neo4j.AccessModeWrite})
+func (a *Adapter) CreateEntities(ctx context.Context, entities []*resolve.Entity) (err error) {
+	ctx, op := a.tel.start(ctx, opCreate, len(entities))
+	defer op.end(&err)
+	defer a.invalidateEntities(entities)
+
+	return a.resilient(ctx, func(ctx context.Context) error {
+		return a.createEntities(ctx, entities)
+	})
+}
+
+func (a *Adapter) createEntities(ctx context.Context, entities []*resolve.Entity) error {
+	session := a.newSession(ctx, neo4j.AccessModeWrite)
 	defer session.Close(ctx)
 
-	var err error
-	err = createIndex(ctx, session)
+	err := a.createIndex(ctx, session)
 	if err != nil {
 		return err
 	}
 
-	qb := newQueryBuilder()
+	qb := createEntitiesQuery(entities, a.tags())
 
-	// create entity, name, entity identifiers
-	// TODO - country
-	entityList := make([][]any, 0, len(entities))
-	for _, entity := range entities {
-		// ideally would be able to define schema using types, but it seems the
-		// n4j driver only accepts arrays and maps, and not structs
-		// 'any' is required in order to support nulls
-
-		// nameDurations: [][]{name, from, until(opt)}
-		nameDurations := make([][]any, 0, len(entity.Name))
-		for _, nameDuration := range entity.Name {
-			nameDurations = append(nameDurations, []any{
-				nameDuration.Detail.Value,
-				dateToOptionalString(&nameDuration.Duration.StartDate),
-				dateToOptionalString(nameDuration.Duration.EndDate),
-			})
-		}
+	// fmt.Println(qb.ToQueryWithParams())
 
-		// identifierDurations: [][]{[]string{idn_type,idn_value},from,until(opt)}
-		identifierDurations := make([][]any, 0, len(entity.Identifiers))
-		for _, identifierDuration := range entity.Identifiers {
-			idns := make([][]string, 0, len(identifierDuration.Detail))
-			for _, idn := range identifierDuration.Detail {
-				idns = append(idns, []string{string(idn.Type), idn.Value})
-			}
-			identifierDurations = append(identifierDurations, []any{
-				idns,
-				dateToOptionalString(&identifierDuration.Duration.StartDate),
-				dateToOptionalString(identifierDuration.Duration.EndDate),
-			})
+	_, err = session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
+		start := time.Now()
+		result, err := tx.Run(ctx, a.queryText(qb), qb.params)
+		if err != nil {
+			return nil, err
 		}
-
-		// securityDurations: [][]{name,from,until(opt),[][]string{idn_type,idn_value}}
-		securityDurations := make([]any, 0, len(entity.Securities))
-		for _, secDuration := range entity.Securities {
-			securities := make([]any, 0, len(secDuration.Detail))
-			for _, sec := range secDuration.Detail {
-				idns := make([][]string, 0, len(sec.Identifiers))
-				for _, idn := range sec.Identifiers {
-					idns = append(idns, []string{string(idn.Type), idn.Value})
-				}
-
-				securities = append(securities, []any{
-					sec.Name,
-					dateToOptionalString(&secDuration.Duration.StartDate),
-					dateToOptionalString(secDuration.Duration.EndDate),
-					idns,
-				})
-			}
-			securityDurations = append(securityDurations, securities)
+		summary, err := result.Consume(ctx)
+		if err != nil {
+			return nil, err
 		}
+		a.recordSummary(ctx, qb, summary, time.Since(start))
+		return nil, nil
+	},
+		neo4j.WithTxTimeout(20*time.Minute),
+	)
+	if err != nil {
+		return fmt.Errorf("create entities: %w", err)
+	}
+
+	return nil
+}
 
-		e := []any{entity.ID.String(), nameDurations, identifierDurations, securityDurations}
+// createEntitiesQuery creates the entities, with the tags set on every node
+// apart from the identifiers, which are shared.
+func createEntitiesQuery(entities []*resolve.Entity, tags map[string]any) *queryBuilder {
+	qb := newQueryBuilder()
 
-		entityList = append(entityList, e)
+	// create entity, name, country, entity identifiers, securities
+	entityList := make([]map[string]any, 0, len(entities))
+	for _, entity := range entities {
+		entityList = append(entityList, entityParams(entity))
 	}
 
 	qb.WriteString(`
 		WITH $entityList as entities
 		UNWIND entities AS e
-		CREATE (ent:Entity {id: e[0]})
-		FOREACH (nd IN e[1] | CREATE (ent)-[:HAS_NAME {from: nd[1], until: nd[2]}]->(:Name {value: nd[0]}))
-		FOREACH (idnd IN e[2] |
-			FOREACH (idn IN idnd[0] |
-				MERGE (im:Identifier {type: idn[0],value: idn[1]})
-				CREATE (ent)-[:HAS_IDENTIFIER {from: idnd[1], until: idnd[2]}]->(im)
+		CREATE (ent:Entity {id: e.id})
+		SET ent += $tags
+		FOREACH (n IN e.names |
+			CREATE (ent)-[:HAS_NAME {from: n.from, until: n.until}]->(nm:Name {value: n.value})
+			SET nm += $tags
+		)
+		FOREACH (c IN e.countries |
+			CREATE (ent)-[:HAS_COUNTRY {from: c.from, until: c.until}]->(cn:Country {value: c.value})
+			SET cn += $tags
+		)
+		FOREACH (idnd IN e.identifiers |
+			FOREACH (idn IN idnd.identifiers |
+				MERGE (im:Identifier {type: idn.type,value: idn.value})
+				CREATE (ent)-[:HAS_IDENTIFIER {from: idnd.from, until: idnd.until}]->(im)
 			)
 		)
-		FOREACH (s IN e[3] |
-			FOREACH (sd IN s |
-				CREATE (ent)-[:HAS_SECURITY {from: sd[1], until: sd[2]}]->(sec:Security {name: sd[0]})
-				FOREACH (idn IN sd[3] |
-					MERGE (im:Identifier {type: idn[0],value: idn[1]})
-					CREATE (sec)-[:HAS_IDENTIFIER]->(im)
-				)
+		FOREACH (s IN e.securities |
+			CREATE (ent)-[:HAS_SECURITY {from: s.from, until: s.until}]->(sec:Security {name: s.name, primary: s.primary})
+			SET sec += $tags
+			FOREACH (idn IN s.identifiers |
+				MERGE (im:Identifier {type: idn.type,value: idn.value})
+				CREATE (sec)-[:HAS_IDENTIFIER]->(im)
 			)
 		)
 	`)
 	qb.params["entityList"] = entityList
+	qb.params["tags"] = tags
 
 	// note: we could put duration on security identifier instead of security, so
 	// that lookup can use the identifier link similar to entity identifier
 
-	// fmt.Println(qb.ToQueryWithParams())
-
-	_, err = session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
-		_, err := tx.Run(ctx, qb.String(), qb.params)
-		return nil, err
-	},
-		neo4j.WithTxTimeout(20*time.Minute),
-	)
-	if err != nil {
-		return fmt.Errorf("create entities: %w", err)
-	}
-
-	return nil
+	return qb
 }
 
 func dateToOptionalString(d *time.Time) *string {
@@ -488,8 +620,9 @@ func Connect(ctx context.Context) (neo4j.DriverWithContext, func(), error) {
 
 	driver, err := neo4j.NewDriverWithContext(dbUri, neo4j.BasicAuth(dbUser, dbPassword, ""))
 
+	// ctx may have a deadline for connecting, so isn't used for closing
 	cleanup := func() {
-		if err := driver.Close(ctx); err != nil {
+		if err := driver.Close(context.Background()); err != nil {
 			log.Println(fmt.Errorf("close driver: %w", err))
 		}
 	}
@@ -497,8 +630,9 @@ func Connect(ctx context.Context) (neo4j.DriverWithContext, func(), error) {
 		return nil, cleanup, fmt.Errorf("new driver: %w", err)
 	}
 
-	if err := driver.VerifyConnectivity(ctx); err != nil {
-		return nil, cleanup, fmt.Errorf("verify connectivity: %w", err)
+	// the server may still be starting up, e.g. when run with docker compose
+	if err := WaitForConnectivity(ctx, driver, connectTimeout); err != nil {
+		return nil, cleanup, err
 	}
 
 	return driver, cleanup, nil
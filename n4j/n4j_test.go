@@ -2,11 +2,13 @@ package n4j
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"math"
 	"testing"
 	"time"
 
+	"neo4j-starter/resolve"
 	"neo4j-starter/resolve/resolvetest"
 
 	"github.com/stretchr/testify/require"
@@ -14,6 +16,9 @@ import (
 
 // These tests require a neo4j server to be running on localhost.
 
+// e.g. go test ./n4j -run=^$ -bench=LookupEntities -profile
+var profile = flag.Bool("profile", false, "run benchmark queries with PROFILE and report db hits")
+
 func TestAdapter_Cleanup(t *testing.T) {
 	ctx := context.Background()
 
@@ -68,33 +73,49 @@ func TestAdapter_LookupEntities(t *testing.T) {
 	require.NoError(t, err)
 
 	a := NewAdapter(driver)
-
-	lookupDate, err := time.Parse(time.RFC3339, "2021-02-09T00:00:00Z")
+	err = a.Cleanup(ctx)
 	require.NoError(t, err)
 
-	const maxEntityCount = 100_000
+	const entityCount = 10_000
 	const lookupCount = 1000
 
+	// entities and lookups come from the same generator, which knows the
+	// results expected of each lookup
 	gen := resolvetest.NewDataGen(1)
-
-	lookups := gen.NewLookups(lookupCount, maxEntityCount, lookupDate)
-
-	// fmt.Println(PrettyPrint(lookups))
-
-	lookupResults, err := a.LookupEntities(ctx, lookups)
+	err = a.CreateEntities(ctx, gen.NewEntities(entityCount))
 	require.NoError(t, err)
-	// count might not be equal when looking up same identifiers
-	// require.Equal(t, len(lookups), len(lookupResults), "should get same count as in lookup")
-
-	// fmt.Println(PrettyPrint(lookupResults))
 
-	var found int
-	for _, res := range lookupResults {
-		if res.Success {
-			found++
+	// every identifier type, dated around when securities change hands
+	expected := gen.NewExpectedLookupsWith(lookupCount, resolvetest.LookupConfig{
+		Unknown:    0.1,
+		Dates:      resolvetest.DateMix{Inside: 4, Before: 1, After: 1, Undated: 1},
+		Duplicates: 0.05,
+	})
+
+	in := make(chan resolve.Lookup)
+	out := make(chan StreamResult)
+	go func() {
+		defer close(in)
+		for _, el := range expected {
+			in <- el.Lookup
 		}
+	}()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.StreamLookups(ctx, in, out, StreamConfig{})
+	}()
+
+	results := make([][]resolve.LookupResult, 0, len(expected))
+	for res := range out {
+		results = append(results, res.Results)
+	}
+	require.NoError(t, <-errc)
+
+	comparison := resolvetest.Compare(expected, results)
+	fmt.Print(comparison)
+	for _, diff := range comparison.Diffs {
+		t.Error(diff)
 	}
-	fmt.Println("found entities:", found)
 }
 
 func TestAdapter_LookupEntitiesConcurrent(t *testing.T) {
@@ -130,6 +151,92 @@ func TestAdapter_LookupEntitiesConcurrent(t *testing.T) {
 	fmt.Println("found entities:", found)
 }
 
+func TestAdapter_ExportEntities(t *testing.T) {
+	ctx := context.Background()
+
+	driver, cleanup, err := Connect(ctx)
+	defer cleanup()
+	require.NoError(t, err)
+
+	a := NewAdapter(driver)
+	err = a.Cleanup(ctx)
+	require.NoError(t, err)
+
+	gen := resolvetest.NewDataGen(1)
+	testEntities := gen.NewEntities(500)
+
+	err = a.CreateEntities(ctx, testEntities)
+	require.NoError(t, err)
+
+	// the export is ordered by id with details in canonical order
+	expected := make(map[string]*resolve.Entity, len(testEntities))
+	for _, e := range testEntities {
+		e.Normalize()
+		expected[e.ID.String()] = e
+	}
+
+	var exported int
+	var lastID string
+	err = a.ExportEntities(ctx, 100, func(e *resolve.Entity) error {
+		require.Greater(t, e.ID.String(), lastID)
+		lastID = e.ID.String()
+
+		require.Equal(t, expected[e.ID.String()], e)
+		exported++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(testEntities), exported)
+}
+
+func TestAdapter_DeleteEntities(t *testing.T) {
+	ctx := context.Background()
+
+	driver, cleanup, err := Connect(ctx)
+	defer cleanup()
+	require.NoError(t, err)
+
+	a := NewAdapter(driver)
+	err = a.Cleanup(ctx)
+	require.NoError(t, err)
+
+	gen := resolvetest.NewDataGen(1)
+	testEntities := gen.NewEntities(10)
+
+	err = a.CreateEntities(ctx, testEntities)
+	require.NoError(t, err)
+
+	ended, erased := testEntities[0].ID, testEntities[1].ID
+	date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rec, err := a.EndEntity(ctx, ended, date, "test")
+	require.NoError(t, err)
+	require.Positive(t, rec.RelationshipsEnded)
+
+	rec, err = a.EraseEntity(ctx, erased, "test")
+	require.NoError(t, err)
+	require.Greater(t, rec.NodesDeleted, 1)
+
+	_, err = a.EraseEntity(ctx, erased, "test")
+	require.ErrorIs(t, err, ErrEntityNotFound)
+
+	// the ended entity keeps its history, the erased one is gone
+	var exported int
+	err = a.ExportEntities(ctx, 100, func(e *resolve.Entity) error {
+		require.NotEqual(t, erased, e.ID)
+		if e.ID == ended {
+			for _, n := range e.Name {
+				require.NotNil(t, n.Duration.EndDate)
+				require.False(t, n.Duration.EndDate.After(date))
+			}
+		}
+		exported++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(testEntities)-1, exported)
+}
+
 func Benchmark_LookupEntities(b *testing.B) {
 	const timeout = 20 * time.Minute
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
@@ -139,7 +246,11 @@ func Benchmark_LookupEntities(b *testing.B) {
 	defer cleanup()
 	require.NoError(b, err)
 
-	a := NewAdapter(driver)
+	var opts []Option
+	if *profile {
+		opts = append(opts, WithProfile())
+	}
+	a := NewAdapter(driver, opts...)
 
 	const (
 		lookupCount = 1000
@@ -153,15 +264,29 @@ func Benchmark_LookupEntities(b *testing.B) {
 	b.Run("lookup_10k_entities_1k", lookupEntities(ctx, a, entityCount, lookupCount, seed))
 
 	entityCount = 100_000
-	insertEntities(b, ctx, a, entityCount, seed)
+	gen := insertEntities(b, ctx, a, entityCount, seed)
 	b.Run("lookup_100k_entities_1k", lookupEntities(ctx, a, entityCount, lookupCount, seed))
+	b.Run("lookup_100k_entities_1k_mixed", lookupEntitiesMixed(ctx, a, gen, lookupCount, resolvetest.LookupConfig{
+		Types: map[resolve.IdentifierType]float64{
+			"isin":           4,
+			"cusip":          2,
+			"asset_id":       2,
+			"sray_entity_id": 1,
+			"fs_entity_id":   1,
+		},
+		Unknown:    0.2,
+		Dates:      resolvetest.DateMix{Inside: 8, Before: 1, After: 1},
+		Duplicates: 0.1,
+	}))
 
 	entityCount = 1_000_000
 	insertEntities(b, ctx, a, entityCount, seed)
 	b.Run("lookup_1m_entities_1k", lookupEntities(ctx, a, entityCount, lookupCount, seed))
 }
 
-func insertEntities(b *testing.B, ctx context.Context, a *Adapter, entityCount int, seed int64) {
+// insertEntities replaces the entities in the database with generated ones,
+// returning the generator to generate lookups of them.
+func insertEntities(b *testing.B, ctx context.Context, a *Adapter, entityCount int, seed int64) *resolvetest.DataGen {
 	b.StopTimer()
 
 	err := a.Cleanup(ctx)
@@ -169,25 +294,23 @@ func insertEntities(b *testing.B, ctx context.Context, a *Adapter, entityCount i
 
 	gen := resolvetest.NewDataGen(seed)
 	testEntities := gen.NewEntities(entityCount)
-	batchsize := 1000
-	var cursor int
-
-	// start := time.Now()
-	// log.Println("inserting entities:", entityCount)
-
-	for cursor < entityCount {
-		max := int(math.Min(float64(entityCount), float64(cursor+batchsize)))
-		err := a.CreateEntities(ctx, testEntities[cursor:max])
-		require.NoError(b, err, fmt.Sprintf("error at cursor: %d", cursor))
 
-		cursor += batchsize
-	}
+	stats, err := a.CreateEntitiesParallel(ctx, testEntities, BulkConfig{
+		Workers:   8,
+		BatchSize: 1000,
+	})
+	require.NoError(b, err)
 
-	// log.Println("entities inserted, time taken:", time.Since(start))
+	b.Logf("inserted %d entities in %d batches, time taken: %v, slowest batch: %v, retries: %d, deadlocks: %d",
+		stats.Entities, stats.Batches, stats.Elapsed, stats.MaxBatch, stats.Retries, stats.Deadlocks)
+	return gen
 }
 
 func lookupEntities(ctx context.Context, a *Adapter, entityCount, lookupCount int, seed int64) func(b *testing.B) {
 	return func(b *testing.B) {
+		stats := &QueryStats{}
+		ctx := WithQueryStats(ctx, stats)
+
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
 			b.StopTimer()
@@ -201,5 +324,33 @@ func lookupEntities(ctx context.Context, a *Adapter, entityCount, lookupCount in
 			require.NoError(b, err)
 		}
 		b.StopTimer()
+
+		if *profile {
+			b.ReportMetric(float64(stats.DbHits())/float64(b.N), "dbhits/op")
+		}
+	}
+}
+
+// lookupEntitiesMixed looks up the entities generated by gen, in the traffic
+// mix of cfg.
+func lookupEntitiesMixed(ctx context.Context, a *Adapter, gen *resolvetest.DataGen, lookupCount int, cfg resolvetest.LookupConfig) func(b *testing.B) {
+	return func(b *testing.B) {
+		stats := &QueryStats{}
+		ctx := WithQueryStats(ctx, stats)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			lookups := gen.NewLookupsWith(lookupCount, cfg)
+			b.StartTimer()
+
+			_, err := a.LookupEntitiesConcurrent(ctx, lookups, 10)
+			require.NoError(b, err)
+		}
+		b.StopTimer()
+
+		if *profile {
+			b.ReportMetric(float64(stats.DbHits())/float64(b.N), "dbhits/op")
+		}
 	}
 }
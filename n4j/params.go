@@ -0,0 +1,95 @@
+package n4j
+
+import (
+	"neo4j-starter/resolve"
+)
+
+// The driver only accepts maps, slices and primitives as params, not structs,
+// so the resolve types are converted to maps with named keys which the queries
+// refer to, e.g. e.names or lookup.date. Optional values such as until are nil
+// rather than left out, so that they are null in Cypher.
+
+// entityParams returns:
+//
+//	{
+//		id: string,
+//		names: [{value, from, until}],
+//		countries: [{value, from, until}],
+//		identifiers: [{identifiers: [{type, value}], from, until}],
+//		securities: [{name, primary, identifiers: [{type, value}], from, until}],
+//	}
+//
+// Securities are flattened, with each security carrying the duration it was
+// held for.
+func entityParams(entity *resolve.Entity) map[string]any {
+	names := make([]map[string]any, 0, len(entity.Name))
+	for _, nd := range entity.Name {
+		names = append(names, withDuration(map[string]any{
+			"value": nd.Detail.Value,
+		}, nd.Duration))
+	}
+
+	countries := make([]map[string]any, 0, len(entity.Country))
+	for _, cd := range entity.Country {
+		countries = append(countries, withDuration(map[string]any{
+			"value": cd.Detail.Value,
+		}, cd.Duration))
+	}
+
+	identifiers := make([]map[string]any, 0, len(entity.Identifiers))
+	for _, idnd := range entity.Identifiers {
+		identifiers = append(identifiers, withDuration(map[string]any{
+			"identifiers": identifierParams(idnd.Detail),
+		}, idnd.Duration))
+	}
+
+	var securities []map[string]any
+	for _, sd := range entity.Securities {
+		for _, sec := range sd.Detail {
+			securities = append(securities, withDuration(map[string]any{
+				"name":        sec.Name,
+				"primary":     sec.IsPrimary,
+				"identifiers": identifierParams(sec.Identifiers),
+			}, sd.Duration))
+		}
+	}
+	if securities == nil {
+		securities = []map[string]any{}
+	}
+
+	return map[string]any{
+		"id":          entity.ID.String(),
+		"names":       names,
+		"countries":   countries,
+		"identifiers": identifiers,
+		"securities":  securities,
+	}
+}
+
+// identifierParams returns [{type, value}].
+func identifierParams(identifiers []resolve.Identifier) []map[string]any {
+	params := make([]map[string]any, 0, len(identifiers))
+	for _, idn := range identifiers {
+		params = append(params, map[string]any{
+			"type":  string(idn.Type),
+			"value": idn.Value,
+		})
+	}
+	return params
+}
+
+// lookupParams returns {type, value, date}, where date can be null.
+func lookupParams(lookup resolve.Lookup) map[string]any {
+	return map[string]any{
+		"type":  string(lookup.Identifier.Type),
+		"value": lookup.Identifier.Value,
+		"date":  dateToOptionalString(lookup.Date),
+	}
+}
+
+// withDuration adds from and until to params.
+func withDuration(params map[string]any, d resolve.Duration) map[string]any {
+	params["from"] = dateToOptionalString(&d.StartDate)
+	params["until"] = dateToOptionalString(d.EndDate)
+	return params
+}
@@ -0,0 +1,94 @@
+package n4j
+
+import (
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntityParams(t *testing.T) {
+	from := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	fromStr, untilStr := "2015-01-01T00:00:00Z", "2020-06-01T00:00:00Z"
+
+	entity := &resolve.Entity{
+		ID: uuid.Must(uuid.FromString("1b4e28ba-2fa1-11d2-883f-0016d3cca427")),
+		Name: []resolve.DetailDuration[resolve.EntityName]{
+			{Detail: resolve.EntityName{Value: "Acme Ltd"}, Duration: resolve.Duration{StartDate: from, EndDate: &until}},
+			{Detail: resolve.EntityName{Value: "Acme Plc"}, Duration: resolve.Duration{StartDate: until}},
+		},
+		Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{
+			{
+				Detail:   []resolve.Identifier{{Type: "sray_entity_id", Value: "42"}},
+				Duration: resolve.Duration{StartDate: from},
+			},
+		},
+		Securities: []resolve.DetailDuration[[]resolve.Security]{
+			{
+				Detail: []resolve.Security{
+					{Name: "Acme Ord", Identifiers: []resolve.Identifier{{Type: "isin", Value: "GB0002634946"}}, IsPrimary: true},
+					{Name: "Acme Pref"},
+				},
+				Duration: resolve.Duration{StartDate: from, EndDate: &until},
+			},
+		},
+	}
+
+	require.Equal(t, map[string]any{
+		"id": "1b4e28ba-2fa1-11d2-883f-0016d3cca427",
+		"names": []map[string]any{
+			{"value": "Acme Ltd", "from": &fromStr, "until": &untilStr},
+			{"value": "Acme Plc", "from": &untilStr, "until": (*string)(nil)},
+		},
+		"countries": []map[string]any{},
+		"identifiers": []map[string]any{
+			{
+				"identifiers": []map[string]any{{"type": "sray_entity_id", "value": "42"}},
+				"from":        &fromStr,
+				"until":       (*string)(nil),
+			},
+		},
+		"securities": []map[string]any{
+			{
+				"name":        "Acme Ord",
+				"primary":     true,
+				"identifiers": []map[string]any{{"type": "isin", "value": "GB0002634946"}},
+				"from":        &fromStr,
+				"until":       &untilStr,
+			},
+			{
+				"name":        "Acme Pref",
+				"primary":     false,
+				"identifiers": []map[string]any{},
+				"from":        &fromStr,
+				"until":       &untilStr,
+			},
+		},
+	}, entityParams(entity))
+}
+
+func TestLookupParams(t *testing.T) {
+	date := time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC)
+	dateStr := "2021-02-09T00:00:00Z"
+
+	require.Equal(t, map[string]any{
+		"type":  "isin",
+		"value": "GB0002634946",
+		"date":  &dateStr,
+	}, lookupParams(resolve.Lookup{
+		Date:       &date,
+		Identifier: resolve.Identifier{Type: "isin", Value: "GB0002634946"},
+	}))
+
+	require.Equal(t, map[string]any{
+		"type":  "asset_id",
+		"value": "1",
+		"date":  (*string)(nil),
+	}, lookupParams(resolve.Lookup{
+		Identifier: resolve.Identifier{Type: "asset_id", Value: "1"},
+	}))
+}
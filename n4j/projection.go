@@ -0,0 +1,391 @@
+package n4j
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+const projectionBatchSize = 1000
+
+// ProjectionStats counts the entities and projections written by
+// BuildLookupProjection.
+type ProjectionStats struct {
+	Entities    int
+	Projections int
+	// Generation is the generation of the projection lookups now read.
+	Generation int
+	Elapsed    time.Duration
+}
+
+// BuildLookupProjection replaces the lookup projection read by the Projection
+// strategy. An entity's history is split into periods over which none of its
+// names, identifiers and securities change, and each identifier held in a
+// period gets a LookupProjection node with the entity's details in the period,
+// so a lookup is a single index seek.
+//
+// The projection is written from ExportEntities, tagged like other nodes, and
+// isn't updated by writes, so it must be rebuilt after entities change. It is
+// written as a new generation, which lookups only read once it is complete, so
+// the previous projection keeps serving lookups during a rebuild, and the
+// previous generation is then removed. Only one build of a tenant and dataset
+// can run at a time.
+func (a *Adapter) BuildLookupProjection(ctx context.Context) (_ ProjectionStats, err error) {
+	ctx, op := a.tel.start(ctx, opBuildProjection, -1)
+	defer op.end(&err)
+
+	start := time.Now()
+	var stats ProjectionStats
+
+	if err := a.resilient(ctx, a.createProjectionIndex); err != nil {
+		return stats, err
+	}
+	var current *int
+	err = a.resilient(ctx, func(ctx context.Context) error {
+		var err error
+		current, err = a.projectionGeneration(ctx)
+		return err
+	})
+	if err != nil {
+		return stats, err
+	}
+	// a build that didn't complete leaves part of a generation behind
+	if err := a.dropProjections(ctx, current); err != nil {
+		return stats, err
+	}
+	stats.Generation = 1
+	if current != nil {
+		stats.Generation = *current + 1
+	}
+
+	var batch []map[string]any
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		qb := createProjectionsQuery(batch, stats.Generation, a.tags())
+		err := a.resilient(ctx, func(ctx context.Context) error {
+			session := a.newSession(ctx, neo4j.AccessModeWrite)
+			defer session.Close(ctx)
+
+			_, err := session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
+				return nil, a.readRecords(ctx, tx, qb, func(*neo4j.Record) error { return nil })
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("write projections: %w", err)
+		}
+		stats.Projections += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	err = a.ExportEntities(ctx, 0, func(e *resolve.Entity) error {
+		stats.Entities++
+		for _, p := range projectEntity(e) {
+			batch = append(batch, p.params())
+		}
+		if len(batch) < projectionBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		err = a.resilient(ctx, func(ctx context.Context) error {
+			return a.switchProjection(ctx, stats.Generation)
+		})
+	}
+	if err == nil {
+		err = a.dropProjections(ctx, &stats.Generation)
+	}
+	stats.Elapsed = time.Since(start)
+	if err != nil {
+		return stats, fmt.Errorf("build lookup projection: %w", err)
+	}
+	return stats, nil
+}
+
+func (a *Adapter) createProjectionIndex(ctx context.Context) error {
+	session := a.newSession(ctx, neo4j.AccessModeWrite)
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
+		_, err := tx.Run(ctx, `
+			CREATE INDEX lookup_projection IF NOT EXISTS
+			FOR (p:LookupProjection) ON (p.type,p.value)
+			`, map[string]any{},
+		)
+		return nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("create index lookup_projection: %w", err)
+	}
+	return nil
+}
+
+// projectionScope sets the tenant and dataset params of the projection
+// queries, empty if the adapter has none.
+func (a *Adapter) projectionScope(qb *queryBuilder) {
+	qb.params["tenant"] = a.tenant
+	qb.params["dataset"] = a.dataset
+}
+
+// projectionGeneration returns the generation of the projection with the
+// adapter's tags read by lookups, nil if it hasn't been built.
+func (a *Adapter) projectionGeneration(ctx context.Context) (*int, error) {
+	session := a.newSession(ctx, neo4j.AccessModeRead)
+	defer session.Close(ctx)
+
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		OPTIONAL MATCH (v:LookupProjectionVersion {tenant: $tenant, dataset: $dataset})
+		RETURN v.generation AS generation
+	`)
+	a.projectionScope(qb)
+
+	var row struct {
+		Generation *int `neo4j:"generation"`
+	}
+	_, err := session.ExecuteRead(ctx, func(tx dbTx) (any, error) {
+		return nil, a.readRecords(ctx, tx, qb, func(record *neo4j.Record) error {
+			return decodeRecord(record, &row)
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read projection generation: %w", err)
+	}
+	return row.Generation, nil
+}
+
+// switchProjection makes lookups read the generation of the projection with
+// the adapter's tags.
+func (a *Adapter) switchProjection(ctx context.Context, generation int) error {
+	session := a.newSession(ctx, neo4j.AccessModeWrite)
+	defer session.Close(ctx)
+
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		MERGE (v:LookupProjectionVersion {tenant: $tenant, dataset: $dataset})
+		SET v += $tags
+		SET v.generation = $generation
+	`)
+	a.projectionScope(qb)
+	qb.params["tags"] = a.tags()
+	qb.params["generation"] = generation
+
+	_, err := session.ExecuteWrite(ctx, func(tx dbTx) (any, error) {
+		return nil, a.readRecords(ctx, tx, qb, func(*neo4j.Record) error { return nil })
+	})
+	if err != nil {
+		return fmt.Errorf("switch projection: %w", err)
+	}
+	return nil
+}
+
+// dropProjections removes the projection nodes with the adapter's tags apart
+// from the generation kept, all of them if it is nil.
+func (a *Adapter) dropProjections(ctx context.Context, keep *int) error {
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		MATCH (n:LookupProjection)
+			WHERE coalesce(n.tenant, '') = $tenant AND coalesce(n.dataset, '') = $dataset
+				AND NOT coalesce(n.generation = $generation, false)
+		WITH n LIMIT $batchSize
+		DELETE n
+		RETURN count(*) AS deleted, 0 AS orphans
+	`)
+	a.projectionScope(qb)
+	qb.params["generation"] = keep
+	qb.params["batchSize"] = projectionBatchSize
+
+	for {
+		var batch deletedBatch
+		err := a.resilient(ctx, func(ctx context.Context) error {
+			var err error
+			batch, err = a.deleteBatch(ctx, qb)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("drop projections: %w", err)
+		}
+		if batch.Deleted < projectionBatchSize {
+			return nil
+		}
+	}
+}
+
+func createProjectionsQuery(projections []map[string]any, generation int, tags map[string]any) *queryBuilder {
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		WITH $projectionList as projections
+		UNWIND projections AS p
+		CREATE (projection:LookupProjection)
+		SET projection = p
+		SET projection.generation = $generation
+		SET projection += $tags
+	`)
+	qb.params["projectionList"] = projections
+	qb.params["generation"] = generation
+	qb.params["tags"] = tags
+	return qb
+}
+
+// projectionNode is a LookupProjection, with the details of an entity that are
+// the result of looking up the identifier between from and until.
+type projectionNode struct {
+	Entity            uuid.UUID                `neo4j:"entity"`
+	Name              *string                  `neo4j:"name"`
+	IdentifierTypes   []resolve.IdentifierType `neo4j:"identifier_types"`
+	IdentifierValues  []string                 `neo4j:"identifier_values"`
+	SecurityNames     []string                 `neo4j:"security_names"`
+	SecurityPrimaries []bool                   `neo4j:"security_primaries"`
+}
+
+// result returns the lookup result of the projection, the same as the other
+// strategies return for the details.
+func (p *projectionNode) result() resolve.LookupResult {
+	d := entityDetails{Entity: &entityNode{ID: p.Entity}}
+	if p.Name != nil {
+		d.Name = &nameNode{Value: *p.Name}
+	}
+	for i, typ := range p.IdentifierTypes {
+		d.Identifiers = append(d.Identifiers, identifierNode{Type: typ, Value: p.IdentifierValues[i]})
+	}
+	for i, name := range p.SecurityNames {
+		primary := p.SecurityPrimaries[i]
+		d.Securities = append(d.Securities, securityNode{Name: name, Primary: &primary})
+	}
+	return d.result()
+}
+
+// projection is the result of looking up the identifier over the duration.
+type projection struct {
+	identifier resolve.Identifier
+	duration   resolve.Duration
+	node       projectionNode
+}
+
+// params returns {type, value, from, until, entity, name, identifier_types,
+// identifier_values, security_names, security_primaries}.
+func (p projection) params() map[string]any {
+	identifierTypes := make([]string, 0, len(p.node.IdentifierTypes))
+	for _, typ := range p.node.IdentifierTypes {
+		identifierTypes = append(identifierTypes, string(typ))
+	}
+	var name any
+	if p.node.Name != nil {
+		name = *p.node.Name
+	}
+	return withDuration(map[string]any{
+		"type":               string(p.identifier.Type),
+		"value":              p.identifier.Value,
+		"entity":             p.node.Entity.String(),
+		"name":               name,
+		"identifier_types":   identifierTypes,
+		"identifier_values":  p.node.IdentifierValues,
+		"security_names":     p.node.SecurityNames,
+		"security_primaries": p.node.SecurityPrimaries,
+	}, p.duration)
+}
+
+// projectEntity returns the projections of the entity: for each period between
+// the dates its names, identifiers or securities change, a projection per
+// identifier it or its securities hold, and per name if names overlap.
+// Securities without identifiers are left out, as lookups don't return them.
+func projectEntity(e *resolve.Entity) []projection {
+	var dates []time.Time
+	addDates := func(d resolve.Duration) {
+		dates = append(dates, d.StartDate)
+		if d.EndDate != nil {
+			dates = append(dates, *d.EndDate)
+		}
+	}
+	for _, nd := range e.Name {
+		addDates(nd.Duration)
+	}
+	for _, idnd := range e.Identifiers {
+		addDates(idnd.Duration)
+	}
+	for _, sd := range e.Securities {
+		addDates(sd.Duration)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	var projections []projection
+	for i, from := range dates {
+		if i > 0 && from.Equal(dates[i-1]) {
+			continue
+		}
+		duration := resolve.Duration{StartDate: from}
+		for _, next := range dates[i+1:] {
+			if next.After(from) {
+				until := next
+				duration.EndDate = &until
+				break
+			}
+		}
+
+		at := e.AsOf(from)
+		node := projectionNode{
+			Entity:            e.ID,
+			IdentifierTypes:   []resolve.IdentifierType{},
+			IdentifierValues:  []string{},
+			SecurityNames:     []string{},
+			SecurityPrimaries: []bool{},
+		}
+		var held []resolve.Identifier
+		seen := map[resolve.Identifier]bool{}
+		for _, idnd := range at.Identifiers {
+			for _, idn := range idnd.Detail {
+				if !seen[idn] {
+					seen[idn] = true
+					held = append(held, idn)
+					node.IdentifierTypes = append(node.IdentifierTypes, idn.Type)
+					node.IdentifierValues = append(node.IdentifierValues, idn.Value)
+				}
+			}
+		}
+		for _, sd := range at.Securities {
+			for _, sec := range sd.Detail {
+				if len(sec.Identifiers) == 0 {
+					continue
+				}
+				node.SecurityNames = append(node.SecurityNames, sec.Name)
+				node.SecurityPrimaries = append(node.SecurityPrimaries, sec.IsPrimary)
+				for _, idn := range sec.Identifiers {
+					if !seen[idn] {
+						seen[idn] = true
+						held = append(held, idn)
+					}
+				}
+			}
+		}
+
+		nodes := []projectionNode{node}
+		if len(at.Name) > 0 {
+			nodes = nodes[:0]
+			for _, nd := range at.Name {
+				named := node
+				name := nd.Detail.Value
+				named.Name = &name
+				nodes = append(nodes, named)
+			}
+		}
+		for _, idn := range held {
+			for _, n := range nodes {
+				projections = append(projections, projection{identifier: idn, duration: duration, node: n})
+			}
+		}
+	}
+	return projections
+}
@@ -0,0 +1,186 @@
+package n4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+var (
+	errConnRefused = &neo4j.ConnectivityError{Inner: errors.New("dial tcp 127.0.0.1:7687: connect: connection refused")}
+	errNotALeader  = &neo4j.Neo4jError{Code: "Neo.ClientError.Cluster.NotALeader", Msg: "no longer the leader"}
+	errSyntax      = &neo4j.Neo4jError{Code: "Neo.ClientError.Statement.SyntaxError", Msg: "invalid input"}
+)
+
+// recordedQuery is a query run on a recordingDB.
+type recordedQuery struct {
+	Mode   neo4j.AccessMode
+	Cypher string
+	Params map[string]any
+}
+
+type reply struct {
+	records []*neo4j.Record
+	err     error
+}
+
+// recordingDB is a fake database which records every query run, and replays
+// the scripted replies for successive queries. Queries after the replies fail
+// with the down error, or return the records from respond, or none.
+type recordingDB struct {
+	mu      sync.Mutex
+	queries []recordedQuery
+	replies []reply
+	// down fails every query after the replies, as if the database was down
+	down error
+	// respond returns the records of a query after the replies
+	respond func(cypher string, params map[string]any) []map[string]any
+
+	// sessions are the configs of the sessions opened
+	sessions []neo4j.SessionConfig
+	// readBookmarks are the bookmarks each read transaction waited for
+	readBookmarks []neo4j.Bookmarks
+}
+
+// reply scripts the records returned by the next query without a reply.
+func (db *recordingDB) reply(records ...map[string]any) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	r := reply{}
+	for _, values := range records {
+		r.records = append(r.records, newRecord(values))
+	}
+	db.replies = append(db.replies, r)
+}
+
+// fail scripts the error returned by the next query without a reply.
+func (db *recordingDB) fail(err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.replies = append(db.replies, reply{err: err})
+}
+
+// setDown sets the error of every query after the replies, nil brings the
+// database back up.
+func (db *recordingDB) setDown(err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.down = err
+}
+
+func (db *recordingDB) Queries() []recordedQuery {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]recordedQuery(nil), db.queries...)
+}
+
+func (db *recordingDB) run(mode neo4j.AccessMode, cypher string, params map[string]any) (dbResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.queries = append(db.queries, recordedQuery{Mode: mode, Cypher: cypher, Params: params})
+	if len(db.replies) == 0 {
+		if db.down != nil {
+			return nil, db.down
+		}
+		r := &fakeResult{}
+		if db.respond != nil {
+			for _, values := range db.respond(cypher, params) {
+				r.records = append(r.records, newRecord(values))
+			}
+		}
+		return r, nil
+	}
+	r := db.replies[0]
+	db.replies = db.replies[1:]
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &fakeResult{records: r.records}, nil
+}
+
+func (db *recordingDB) NewSession(_ context.Context, cfg neo4j.SessionConfig) dbSession {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.sessions = append(db.sessions, cfg)
+	return &recordingSession{db: db, cfg: cfg}
+}
+
+type recordingSession struct {
+	db  *recordingDB
+	cfg neo4j.SessionConfig
+}
+
+// bookmarks returns the bookmarks a transaction of the session waits for.
+func (s *recordingSession) bookmarks(ctx context.Context) neo4j.Bookmarks {
+	bookmarks := s.cfg.Bookmarks
+	if s.cfg.BookmarkManager != nil {
+		managed, _ := s.cfg.BookmarkManager.GetBookmarks(ctx)
+		bookmarks = neo4j.CombineBookmarks(bookmarks, managed)
+	}
+	return bookmarks
+}
+
+func (s *recordingSession) ExecuteRead(ctx context.Context, work txWork, _ ...func(*neo4j.TransactionConfig)) (any, error) {
+	s.db.mu.Lock()
+	s.db.readBookmarks = append(s.db.readBookmarks, s.bookmarks(ctx))
+	s.db.mu.Unlock()
+
+	return work(&recordingTx{db: s.db, mode: neo4j.AccessModeRead})
+}
+
+// ExecuteWrite commits a transaction with the bookmark "tx:<n>", where n is
+// the number of queries run so far.
+func (s *recordingSession) ExecuteWrite(ctx context.Context, work txWork, _ ...func(*neo4j.TransactionConfig)) (any, error) {
+	res, err := work(&recordingTx{db: s.db, mode: neo4j.AccessModeWrite})
+	if err == nil && s.cfg.BookmarkManager != nil {
+		tx := neo4j.Bookmarks{fmt.Sprintf("tx:%d", len(s.db.Queries()))}
+		err = s.cfg.BookmarkManager.UpdateBookmarks(ctx, s.bookmarks(ctx), tx)
+	}
+	return res, err
+}
+
+func (s *recordingSession) BeginTransaction(context.Context, ...func(*neo4j.TransactionConfig)) (dbExplicitTx, error) {
+	return &recordingTx{db: s.db, mode: s.cfg.AccessMode}, nil
+}
+
+func (s *recordingSession) Close(context.Context) error {
+	return nil
+}
+
+type recordingTx struct {
+	db   *recordingDB
+	mode neo4j.AccessMode
+}
+
+func (tx *recordingTx) Run(_ context.Context, cypher string, params map[string]any) (dbResult, error) {
+	return tx.db.run(tx.mode, cypher, params)
+}
+
+func (tx *recordingTx) Commit(context.Context) error { return nil }
+func (tx *recordingTx) Close(context.Context) error  { return nil }
+
+type fakeResult struct {
+	records []*neo4j.Record
+	next    int
+}
+
+func (r *fakeResult) Next(context.Context) bool {
+	r.next++
+	return r.next <= len(r.records)
+}
+
+func (r *fakeResult) Record() *neo4j.Record {
+	return r.records[r.next-1]
+}
+
+func (r *fakeResult) Err() error { return nil }
+
+func (r *fakeResult) Consume(context.Context) (neo4j.ResultSummary, error) {
+	return &fakeSummary{}, nil
+}
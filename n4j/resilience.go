@@ -0,0 +1,315 @@
+package n4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// ErrCircuitOpen is returned without calling the database while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// RetryPolicy configures retries of adapter operations failing with a
+// transient error, on top of the retries done by the driver within a
+// transaction.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts per operation, defaults to 3.
+	MaxAttempts int
+	// InitialBackoff is doubled on each retry up to MaxBackoff, with jitter.
+	// Defaults to 100ms and 5s.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Budget limits retries across all operations, so that a database that is
+	// down isn't hit with MaxAttempts times the usual load. Each successful
+	// operation adds BudgetRatio to the budget, up to BudgetMax, and each retry
+	// takes 1. Defaults to 0.1 and 10, so once the budget is used up about one
+	// in ten operations can retry.
+	BudgetRatio float64
+	BudgetMax   float64
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 5 * time.Second
+	}
+	if p.BudgetRatio <= 0 {
+		p.BudgetRatio = 0.1
+	}
+	if p.BudgetMax <= 0 {
+		p.BudgetMax = 10
+	}
+}
+
+// BreakerConfig configures the circuit breaker.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive operations failing with a
+	// transient error that opens the breaker, defaults to 5.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before letting a single
+	// operation through to probe the database, defaults to 10s.
+	OpenTimeout time.Duration
+}
+
+func (c *BreakerConfig) setDefaults() {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 10 * time.Second
+	}
+}
+
+// WithRetryPolicy retries adapter operations failing with a transient error,
+// such as a lost connection or a leader switch.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(a *Adapter) {
+		policy.setDefaults()
+		a.retry = &policy
+		a.budget = &retryBudget{tokens: policy.BudgetMax, max: policy.BudgetMax, ratio: policy.BudgetRatio}
+	}
+}
+
+// WithCircuitBreaker fails operations fast with ErrCircuitOpen once the
+// database looks to be down, rather than waiting for every operation to time
+// out.
+func WithCircuitBreaker(cfg BreakerConfig) Option {
+	return func(a *Adapter) {
+		cfg.setDefaults()
+		a.breaker = &breaker{cfg: cfg, now: time.Now}
+	}
+}
+
+// resilient runs fn with the retry policy and circuit breaker, if configured.
+func (a *Adapter) resilient(ctx context.Context, fn func(context.Context) error) error {
+	if a.retry == nil {
+		return a.guard(ctx, fn)
+	}
+
+	var lastErr error
+	_, err := retry(ctx, *a.retry, a.budget, func() error {
+		err := a.guard(ctx, fn)
+		if errors.Is(err, ErrCircuitOpen) && lastErr != nil {
+			// keep the error that opened the breaker
+			return fmt.Errorf("%w: %w", err, lastErr)
+		}
+		lastErr = err
+		return err
+	})
+	return err
+}
+
+// guard runs fn through the circuit breaker, if configured.
+func (a *Adapter) guard(ctx context.Context, fn func(context.Context) error) error {
+	if a.breaker == nil {
+		return fn(ctx)
+	}
+
+	if !a.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	err := fn(ctx)
+	a.breaker.record(err)
+	return err
+}
+
+// retry calls fn until it succeeds, fails with a permanent error, or the
+// attempts or budget are used up. It returns the number of attempts made. A
+// nil budget doesn't limit retries.
+func retry(ctx context.Context, policy RetryPolicy, budget *retryBudget, fn func() error) (int, error) {
+	backoff := policy.InitialBackoff
+	var attempts int
+	for {
+		attempts++
+		err := fn()
+		if err == nil {
+			budget.deposit()
+			return attempts, nil
+		}
+		if !isTransient(err) || attempts >= policy.MaxAttempts || !budget.withdraw() {
+			return attempts, err
+		}
+
+		// full jitter, so that operations failing together don't retry in
+		// lockstep
+		sleep := time.Duration(rand.Int63n(int64(backoff)) + 1)
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return attempts, errors.Join(err, ctx.Err())
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// retryBudget is a token bucket shared by all operations of an adapter.
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+func (b *retryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	// a single probe is let through, which closes or reopens the breaker
+	breakerHalfOpen
+)
+
+type breaker struct {
+	mu  sync.Mutex
+	cfg BreakerConfig
+	now func() time.Time
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// allow reports whether an operation may run.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cfg.OpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// the probe is still running
+		return false
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of an operation. Only transient
+// errors count as failures, as other errors mean the database is up.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isTransient(err) {
+		if err != nil && isCanceled(err) && b.state == breakerHalfOpen {
+			// the probe didn't find out either way, let another one through
+			b.state = breakerOpen
+			b.openedAt = time.Time{}
+			return
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isTransient reports whether the error is worth retrying: connectivity
+// errors, transient server errors such as deadlocks, and cluster errors such
+// as a leader switch. Errors caused by the context being done are not, nor are
+// client errors such as syntax errors or constraint violations.
+func isTransient(err error) bool {
+	if err == nil || isCanceled(err) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	// the driver gave up retrying within its own time limit
+	var limitErr *neo4j.TransactionExecutionLimit
+	if errors.As(err, &limitErr) {
+		return len(limitErr.Errors) > 0 && isTransient(limitErr.Errors[len(limitErr.Errors)-1])
+	}
+	// neo4j.IsRetryable doesn't unwrap connectivity errors, so check those
+	// separately
+	var neo4jErr *neo4j.Neo4jError
+	if errors.As(err, &neo4jErr) {
+		return neo4j.IsRetryable(neo4jErr)
+	}
+	var connErr *neo4j.ConnectivityError
+	if errors.As(err, &connErr) {
+		return neo4j.IsRetryable(connErr)
+	}
+	return neo4j.IsRetryable(err)
+}
+
+// WaitForConnectivity waits for the database to be reachable, retrying with
+// backoff until timeout. This is for startup, when the server may still be
+// starting up.
+func WaitForConnectivity(ctx context.Context, driver neo4j.DriverWithContext, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := 100 * time.Millisecond
+	for {
+		err := driver.VerifyConnectivity(ctx)
+		if err == nil {
+			return nil
+		}
+		if !isTransient(err) {
+			return fmt.Errorf("verify connectivity: %w", err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("wait for connectivity: %w", errors.Join(err, ctx.Err()))
+		}
+		backoff *= 2
+		if backoff > 2*time.Second {
+			backoff = 2 * time.Second
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package n4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/require"
+)
+
+var testLookups = []resolve.Lookup{{Identifier: resolve.Identifier{Type: "isin", Value: "X1"}}}
+
+func TestIsTransient_Classification(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{errConnRefused, true},
+		{errNotALeader, true},
+		{fmt.Errorf("lookup entities: %w", errConnRefused), true},
+		{&neo4j.TransactionExecutionLimit{Cause: "timeout", Errors: []error{errSyntax, errConnRefused}}, true},
+		{&neo4j.TransactionExecutionLimit{Cause: "timeout", Errors: []error{errConnRefused, errSyntax}}, false},
+		{&neo4j.Neo4jError{Code: "Neo.TransientError.General.DatabaseUnavailable"}, true},
+		{errSyntax, false},
+		{&neo4j.Neo4jError{Code: "Neo.ClientError.Schema.ConstraintValidationFailed"}, false},
+		{errors.Join(errConnRefused, context.Canceled), false},
+		{context.DeadlineExceeded, false},
+		{ErrCircuitOpen, false},
+		{errors.New("other"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.transient, isTransient(tt.err), "%v", tt.err)
+	}
+}
+
+var fastRetries = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     time.Millisecond,
+}
+
+func TestAdapter_Retry(t *testing.T) {
+	ctx := context.Background()
+
+	// a leader switch and a lost connection are retried
+	db := &recordingDB{}
+	db.fail(errNotALeader)
+	db.fail(errConnRefused)
+	a := newAdapter(db, WithRetryPolicy(fastRetries))
+	_, err := a.LookupEntities(ctx, testLookups)
+	require.NoError(t, err)
+	require.Len(t, db.Queries(), 3)
+
+	// but not more than MaxAttempts times
+	db = &recordingDB{}
+	for i := 0; i < 3; i++ {
+		db.fail(errConnRefused)
+	}
+	a = newAdapter(db, WithRetryPolicy(fastRetries))
+	err = a.CreateEntities(ctx, nil)
+	require.ErrorIs(t, err, errConnRefused)
+	require.Len(t, db.Queries(), 3)
+
+	// permanent errors fail straight away
+	db = &recordingDB{}
+	db.fail(errSyntax)
+	a = newAdapter(db, WithRetryPolicy(fastRetries))
+	err = a.Cleanup(ctx)
+	require.ErrorIs(t, err, errSyntax)
+	require.Len(t, db.Queries(), 1)
+
+	// without a policy nothing is retried
+	db = &recordingDB{}
+	db.fail(errConnRefused)
+	a = newAdapter(db)
+	_, err = a.LookupEntities(ctx, testLookups)
+	require.ErrorIs(t, err, errConnRefused)
+	require.Len(t, db.Queries(), 1)
+}
+
+func TestAdapter_LookupEntitiesConcurrent_Errors(t *testing.T) {
+	ctx := context.Background()
+	lookups := []resolve.Lookup{testLookups[0], {Identifier: resolve.Identifier{Type: "isin", Value: "X2"}}}
+
+	// a failed chunk fails the lookups, rather than leaving out its results
+	db := &recordingDB{}
+	db.fail(errSyntax)
+	a := newAdapter(db)
+	res, err := a.LookupEntitiesConcurrent(ctx, lookups, 2)
+	require.ErrorIs(t, err, errSyntax)
+	require.Nil(t, res)
+
+	// chunks are retried on their own
+	db = &recordingDB{}
+	db.fail(errConnRefused)
+	db.respond = func(_ string, params map[string]any) []map[string]any {
+		var rows []map[string]any
+		for _, lookup := range params["lookupList"].([]map[string]any) {
+			// the lookups are undated
+			undated := map[string]any{"type": lookup["type"], "value": lookup["value"], "date": nil}
+			rows = append(rows, noDetails(map[string]any{"lookup": undated}))
+		}
+		return rows
+	}
+	a = newAdapter(db, WithRetryPolicy(fastRetries))
+	res, err = a.LookupEntitiesConcurrent(ctx, lookups, 2)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.Len(t, db.Queries(), 3)
+}
+
+func TestAdapter_RetryBudget(t *testing.T) {
+	ctx := context.Background()
+
+	policy := fastRetries
+	policy.BudgetMax = 2
+	policy.BudgetRatio = 0.5
+	db := &recordingDB{}
+	db.setDown(errConnRefused)
+	a := newAdapter(db, WithRetryPolicy(policy))
+
+	// the first operation uses up the budget, the second isn't retried
+	_, err := a.LookupEntities(ctx, testLookups)
+	require.Error(t, err)
+	require.Len(t, db.Queries(), 3)
+	_, err = a.LookupEntities(ctx, testLookups)
+	require.Error(t, err)
+	require.Len(t, db.Queries(), 4)
+
+	// successes refill the budget
+	db.setDown(nil)
+	for i := 0; i < 2; i++ {
+		_, err = a.LookupEntities(ctx, testLookups)
+		require.NoError(t, err)
+	}
+	db.fail(errConnRefused)
+	_, err = a.LookupEntities(ctx, testLookups)
+	require.NoError(t, err)
+	require.Len(t, db.Queries(), 8)
+}
+
+func TestAdapter_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	db := &recordingDB{}
+	db.fail(errSyntax)
+	db.setDown(errConnRefused)
+	a := newAdapter(db, WithCircuitBreaker(BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute}))
+	now := time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC)
+	a.breaker.now = func() time.Time { return now }
+
+	// permanent errors mean the database is up
+	_, err := a.LookupEntities(ctx, testLookups)
+	require.ErrorIs(t, err, errSyntax)
+
+	for i := 0; i < 2; i++ {
+		_, err := a.LookupEntities(ctx, testLookups)
+		require.ErrorIs(t, err, errConnRefused)
+	}
+	require.Len(t, db.Queries(), 3)
+
+	// the breaker is open, so the database isn't called
+	_, err = a.LookupEntities(ctx, testLookups)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	_, err = a.CreateEntitiesParallel(ctx, nil, BulkConfig{})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Len(t, db.Queries(), 3)
+
+	// a failed probe reopens the breaker
+	now = now.Add(time.Minute)
+	_, err = a.LookupEntities(ctx, testLookups)
+	require.ErrorIs(t, err, errConnRefused)
+	_, err = a.LookupEntities(ctx, testLookups)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Len(t, db.Queries(), 4)
+
+	// a successful probe closes it
+	now = now.Add(time.Minute)
+	db.setDown(nil)
+	for i := 0; i < 2; i++ {
+		_, err = a.LookupEntities(ctx, testLookups)
+		require.NoError(t, err)
+	}
+	require.Len(t, db.Queries(), 6)
+}
+
+func TestAdapter_RetryWithBreaker(t *testing.T) {
+	db := &recordingDB{}
+	db.setDown(errConnRefused)
+	a := newAdapter(db,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		WithCircuitBreaker(BreakerConfig{FailureThreshold: 3}),
+	)
+
+	// retries stop once the breaker opens
+	err := a.CreateIndexes(context.Background())
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.ErrorIs(t, err, errConnRefused)
+	require.Len(t, db.Queries(), 3)
+}
+
+// connectivityDriver is a driver whose VerifyConnectivity fails with the
+// faults, then with down. The driver's interface has unexported methods, so it
+// is embedded, and calling any other method panics.
+type connectivityDriver struct {
+	neo4j.DriverWithContext
+	faults []error
+	down   error
+	calls  int
+}
+
+func (d *connectivityDriver) VerifyConnectivity(context.Context) error {
+	d.calls++
+	if len(d.faults) > 0 {
+		err := d.faults[0]
+		d.faults = d.faults[1:]
+		return err
+	}
+	return d.down
+}
+
+func TestWaitForConnectivity(t *testing.T) {
+	ctx := context.Background()
+
+	driver := &connectivityDriver{faults: []error{errConnRefused, errConnRefused}}
+	require.NoError(t, WaitForConnectivity(ctx, driver, time.Minute))
+	require.Equal(t, 3, driver.calls)
+
+	driver = &connectivityDriver{faults: []error{&neo4j.Neo4jError{Code: "Neo.ClientError.Security.Unauthorized"}}}
+	err := WaitForConnectivity(ctx, driver, time.Minute)
+	require.ErrorContains(t, err, "Unauthorized")
+	require.Equal(t, 1, driver.calls)
+
+	driver = &connectivityDriver{down: errConnRefused}
+	err = WaitForConnectivity(ctx, driver, 50*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorIs(t, err, errConnRefused)
+}
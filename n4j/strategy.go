@@ -0,0 +1,311 @@
+package n4j
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// LookupStrategy is how lookups are queried in a read transaction. Strategies
+// are given distinct lookups and return a set of results for each: a
+// successful result per entity holding the identifier at the lookup's date, or
+// a single unsuccessful result when nothing matches. So they can be swapped,
+// and compared with CompareLookupStrategies.
+type LookupStrategy interface {
+	// Name selects the strategy in LookupStrategyByName.
+	Name() string
+	query(ctx context.Context, a *Adapter, tx dbTx, lookups []resolve.Lookup) ([]lookupRow, error)
+}
+
+var (
+	// UnwindLookups runs all the lookups in a single query, matching the
+	// details of each entity found with OPTIONAL MATCH. This is the default.
+	UnwindLookups LookupStrategy = unwindStrategy{}
+	// PerLookup runs a query per lookup in the same transaction.
+	PerLookup LookupStrategy = perLookupStrategy{}
+	// TwoPhase resolves the entities of all the lookups first, then reads the
+	// details of each entity once per lookup date.
+	TwoPhase LookupStrategy = twoPhaseStrategy{}
+	// Projection reads the results from the last projection completed by
+	// BuildLookupProjection, so it only sees the entities written before then.
+	Projection LookupStrategy = projectionStrategy{}
+)
+
+// LookupStrategies returns every lookup strategy.
+func LookupStrategies() []LookupStrategy {
+	return []LookupStrategy{UnwindLookups, PerLookup, TwoPhase, Projection}
+}
+
+// LookupStrategyByName returns the lookup strategy with the name, e.g. from
+// config.
+func LookupStrategyByName(name string) (LookupStrategy, error) {
+	var names []string
+	for _, s := range LookupStrategies() {
+		if s.Name() == name {
+			return s, nil
+		}
+		names = append(names, s.Name())
+	}
+	return nil, fmt.Errorf("unknown lookup strategy %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// WithLookupStrategy sets how lookups are queried, defaults to UnwindLookups.
+func WithLookupStrategy(s LookupStrategy) Option {
+	return func(a *Adapter) {
+		a.lookupStrategy = s
+	}
+}
+
+// Parts of the lookup queries. An entity matches if it, or one of its
+// securities, has the identifier at the date, or at the current time for an
+// undated lookup. Security identifiers aren't dated, so only the HAS_SECURITY
+// duration applies to them. The lookup is returned as given, so undated
+// results stay undated.
+const (
+	unwindLookupsQuery = `
+		WITH $lookupList as lookups
+		UNWIND lookups AS lookup
+		WITH lookup, coalesce(lookup.date, $now) AS date`
+	matchEntityQuery = `
+		OPTIONAL MATCH (idn:Identifier {type: lookup.type,value: lookup.value})
+		OPTIONAL MATCH p = (idn)<-[:HAS_IDENTIFIER]-(:Entity|Security)<-[:HAS_SECURITY*0..1]-(entity:Entity)
+			WHERE all(r IN relationships(p) WHERE r.from IS NULL OR (r.from <= date AND (r.until IS NULL OR date < r.until)))`
+	matchDetailsQuery = `
+		OPTIONAL MATCH (entity)-[hi:HAS_IDENTIFIER]->(i:Identifier)
+			WHERE (hi.from <= date and (hi.until IS NULL OR date < hi.until))
+		OPTIONAL MATCH (entity)-[hn:HAS_NAME]->(name:Name)
+			WHERE (hn.from <= date and (hn.until IS NULL OR date < hn.until))
+		OPTIONAL MATCH (entity)-[hs:HAS_SECURITY]->(security:Security)-->(si:Identifier)
+			WHERE (hs.from <= date and (hs.until IS NULL OR date < hs.until))`
+	returnDetailsQuery = `collect(distinct(i)) as identifiers, name, collect(distinct(security)) as securities, collect(distinct(si)) as security_identifiers`
+)
+
+// queryStrategy queries the lookups with the strategy, once each, so a
+// repeated lookup has a single set of results whatever the strategy.
+func (a *Adapter) queryStrategy(ctx context.Context, tx dbTx, s LookupStrategy, lookups []resolve.Lookup) ([]lookupRow, error) {
+	distinct := make([]resolve.Lookup, 0, len(lookups))
+	seen := make(map[lookupKey]bool, len(lookups))
+	for _, lookup := range lookups {
+		key := newLookupKey(lookup)
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, lookup)
+		}
+	}
+	return s.query(ctx, a, tx, distinct)
+}
+
+// lookupListParams returns the lookups as params, with the time undated
+// lookups resolve at.
+func (a *Adapter) lookupListParams(qb *queryBuilder, lookups []resolve.Lookup) {
+	lookupList := make([]map[string]any, 0, len(lookups))
+	for _, lookup := range lookups {
+		lookupList = append(lookupList, lookupParams(lookup))
+	}
+	qb.params["lookupList"] = lookupList
+	qb.params["now"] = a.now().UTC().Format(time.RFC3339)
+}
+
+// readLookupRows runs a query returning lookupRecords.
+func (a *Adapter) readLookupRows(ctx context.Context, tx dbTx, qb *queryBuilder) ([]lookupRow, error) {
+	var rows []lookupRow
+	err := a.readRecords(ctx, tx, qb, func(record *neo4j.Record) error {
+		row, err := mapLookupRecord(record)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+type unwindStrategy struct{}
+
+func (unwindStrategy) Name() string { return "unwind" }
+
+func (unwindStrategy) query(ctx context.Context, a *Adapter, tx dbTx, lookups []resolve.Lookup) ([]lookupRow, error) {
+	qb := newQueryBuilder()
+	qb.WriteString(unwindLookupsQuery + matchEntityQuery + matchDetailsQuery + `
+		RETURN lookup,entity,` + returnDetailsQuery + `
+	`)
+	a.lookupListParams(qb, lookups)
+
+	return a.readLookupRows(ctx, tx, qb)
+}
+
+type perLookupStrategy struct{}
+
+func (perLookupStrategy) Name() string { return "per_lookup" }
+
+func (perLookupStrategy) query(ctx context.Context, a *Adapter, tx dbTx, lookups []resolve.Lookup) ([]lookupRow, error) {
+	now := a.now().UTC().Format(time.RFC3339)
+
+	rows := make([]lookupRow, 0, len(lookups))
+	for _, lookup := range lookups {
+		qb := newQueryBuilder()
+		qb.WriteString(`
+		WITH $lookup AS lookup
+		WITH lookup, coalesce(lookup.date, $now) AS date` + matchEntityQuery + matchDetailsQuery + `
+		RETURN lookup,entity,` + returnDetailsQuery + `
+	`)
+		qb.params["lookup"] = lookupParams(lookup)
+		qb.params["now"] = now
+
+		lookupRows, err := a.readLookupRows(ctx, tx, qb)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, lookupRows...)
+	}
+	return rows, nil
+}
+
+type twoPhaseStrategy struct{}
+
+func (twoPhaseStrategy) Name() string { return "two_phase" }
+
+// matchedRecord is a row of the first phase of TwoPhase, with the entities
+// matched by a lookup at the date.
+type matchedRecord struct {
+	Lookup   *lookupNode  `neo4j:"lookup"`
+	Date     string       `neo4j:"date"`
+	Entities []entityNode `neo4j:"entities"`
+}
+
+// detailsRecord is a row of the second phase of TwoPhase, with the details of
+// an entity at the date.
+type detailsRecord struct {
+	Date string `neo4j:"date"`
+	entityDetails
+}
+
+type entityAt struct {
+	id   string
+	date string
+}
+
+func (twoPhaseStrategy) query(ctx context.Context, a *Adapter, tx dbTx, lookups []resolve.Lookup) ([]lookupRow, error) {
+	qb := newQueryBuilder()
+	qb.WriteString(unwindLookupsQuery + matchEntityQuery + `
+		RETURN lookup, date, collect(distinct(entity)) as entities
+	`)
+	a.lookupListParams(qb, lookups)
+
+	matched := make(map[lookupKey]matchedRecord, len(lookups))
+	var entityList []map[string]any
+	seen := map[entityAt]bool{}
+	err := a.readRecords(ctx, tx, qb, func(record *neo4j.Record) error {
+		var row matchedRecord
+		if err := decodeRecord(record, &row); err != nil {
+			return fmt.Errorf("decode lookup: %w", err)
+		}
+		matched[row.Lookup.key()] = row
+
+		for _, e := range row.Entities {
+			at := entityAt{id: e.ID.String(), date: row.Date}
+			if !seen[at] {
+				seen[at] = true
+				entityList = append(entityList, map[string]any{"id": at.id, "date": at.date})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	details := make(map[entityAt][]resolve.LookupResult, len(entityList))
+	if len(entityList) > 0 {
+		qb := newQueryBuilder()
+		qb.WriteString(`
+		WITH $entityList as entities
+		UNWIND entities AS e
+		MATCH (entity:Entity {id: e.id})
+		WITH entity, e.date AS date` + matchDetailsQuery + `
+		RETURN entity,date,` + returnDetailsQuery + `
+	`)
+		qb.params["entityList"] = entityList
+
+		err := a.readRecords(ctx, tx, qb, func(record *neo4j.Record) error {
+			var row detailsRecord
+			if err := decodeRecord(record, &row); err != nil {
+				return fmt.Errorf("decode entity: %w", err)
+			}
+			if row.Entity == nil {
+				return nil
+			}
+			at := entityAt{id: row.Entity.ID.String(), date: row.Date}
+			details[at] = append(details[at], row.result())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a row per result of each lookup, like UnwindLookups
+	rows := make([]lookupRow, 0, len(lookups))
+	for _, lookup := range lookups {
+		key := newLookupKey(lookup)
+		m := matched[key]
+
+		var found bool
+		for _, e := range m.Entities {
+			for _, result := range details[entityAt{id: e.ID.String(), date: m.Date}] {
+				rows = append(rows, lookupRow{key: key, result: result})
+				found = true
+			}
+		}
+		if !found {
+			rows = append(rows, lookupRow{key: key, result: resolve.LookupResult{Success: false}})
+		}
+	}
+	return rows, nil
+}
+
+type projectionStrategy struct{}
+
+func (projectionStrategy) Name() string { return "projection" }
+
+// projectionRecord is a row of Projection, with a result of a lookup, or a nil
+// projection if nothing was found.
+type projectionRecord struct {
+	Lookup     *lookupNode     `neo4j:"lookup"`
+	Projection *projectionNode `neo4j:"projection"`
+}
+
+func (projectionStrategy) query(ctx context.Context, a *Adapter, tx dbTx, lookups []resolve.Lookup) ([]lookupRow, error) {
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		WITH [(v:LookupProjectionVersion) | v {.tenant, .dataset, .generation}] AS current
+		WITH current, $lookupList as lookups
+		UNWIND lookups AS lookup
+		WITH current, lookup, coalesce(lookup.date, $now) AS date
+		OPTIONAL MATCH (projection:LookupProjection {type: lookup.type,value: lookup.value})
+			WHERE projection.from <= date AND (projection.until IS NULL OR date < projection.until)
+				AND {tenant: coalesce(projection.tenant, ''), dataset: coalesce(projection.dataset, ''), generation: projection.generation} IN current
+		RETURN lookup,projection
+	`)
+	a.lookupListParams(qb, lookups)
+
+	var rows []lookupRow
+	err := a.readRecords(ctx, tx, qb, func(record *neo4j.Record) error {
+		var row projectionRecord
+		if err := decodeRecord(record, &row); err != nil {
+			return fmt.Errorf("decode lookup: %w", err)
+		}
+		result := resolve.LookupResult{Success: false}
+		if row.Projection != nil {
+			result = row.Projection.result()
+		}
+		rows = append(rows, lookupRow{key: row.Lookup.key(), result: result})
+		return nil
+	})
+	return rows, err
+}
@@ -0,0 +1,287 @@
+package n4j
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+	"neo4j-starter/resolve/resolvetest"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	strategyDate    = time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC)
+	strategyLookups = []resolve.Lookup{
+		{Date: &strategyDate, Identifier: resolve.Identifier{Type: "isin", Value: "GB0002634946"}},
+		{Identifier: resolve.Identifier{Type: "sray_entity_id", Value: "missing"}},
+	}
+	acmeLookup    = map[string]any{"type": "isin", "value": "GB0002634946", "date": "2021-02-09T00:00:00Z"}
+	missingLookup = map[string]any{"type": "sray_entity_id", "value": "missing", "date": nil}
+	acmeEntity    = node(map[string]any{"id": "1b4e28ba-2fa1-11d2-883f-0016d3cca427"}, "Entity")
+)
+
+// acmeDetails are the details of acmeEntity returned by the lookup queries.
+func acmeDetails(values map[string]any) map[string]any {
+	values["entity"] = acmeEntity
+	values["name"] = node(map[string]any{"value": "Acme Plc"}, "Name")
+	values["identifiers"] = []any{node(map[string]any{"type": "sray_entity_id", "value": "42"}, "Identifier")}
+	values["securities"] = []any{node(map[string]any{"name": "Acme Ord", "primary": true}, "Security")}
+	values["security_identifiers"] = []any{node(map[string]any{"type": "isin", "value": "GB0002634946"}, "Identifier")}
+	return values
+}
+
+func noDetails(values map[string]any) map[string]any {
+	values["entity"] = nil
+	values["name"] = nil
+	values["identifiers"] = []any{}
+	values["securities"] = []any{}
+	values["security_identifiers"] = []any{}
+	return values
+}
+
+func requireAcmeResults(t *testing.T, res []resolve.LookupResult) {
+	t.Helper()
+	require.Len(t, res, 2)
+	require.True(t, res[0].Success)
+	require.Equal(t, "1b4e28ba-2fa1-11d2-883f-0016d3cca427", res[0].Entity.ID.String())
+	require.Equal(t, "Acme Plc", res[0].Entity.Name[0].Detail.Value)
+	require.Equal(t, []resolve.Identifier{{Type: "sray_entity_id", Value: "42"}}, res[0].Entity.Identifiers[0].Detail)
+	require.Equal(t, []resolve.Security{{Name: "Acme Ord", IsPrimary: true}}, res[0].Entity.Securities[0].Detail)
+	require.False(t, res[1].Success)
+}
+
+// strategyScripts script the replies to the queries of each strategy for
+// strategyLookups.
+func strategyScripts() []strategyScript {
+	return []strategyScript{
+		{
+			strategy: PerLookup,
+			script: func(db *recordingDB) {
+				db.reply(acmeDetails(map[string]any{"lookup": acmeLookup}))
+				db.reply(noDetails(map[string]any{"lookup": missingLookup}))
+			},
+		},
+		{
+			strategy: TwoPhase,
+			script: func(db *recordingDB) {
+				db.reply(
+					map[string]any{"lookup": acmeLookup, "date": "2021-02-09T00:00:00Z", "entities": []any{acmeEntity}},
+					map[string]any{"lookup": missingLookup, "date": "2022-02-09T00:00:00Z", "entities": []any{}},
+				)
+				db.reply(acmeDetails(map[string]any{"date": "2021-02-09T00:00:00Z"}))
+			},
+		},
+		{
+			strategy: Projection,
+			script: func(db *recordingDB) {
+				db.reply(
+					map[string]any{"lookup": acmeLookup, "projection": node(map[string]any{
+						"type":               "isin",
+						"value":              "GB0002634946",
+						"from":               "2020-06-01T00:00:00Z",
+						"until":              nil,
+						"entity":             "1b4e28ba-2fa1-11d2-883f-0016d3cca427",
+						"name":               "Acme Plc",
+						"identifier_types":   []any{"sray_entity_id"},
+						"identifier_values":  []any{"42"},
+						"security_names":     []any{"Acme Ord"},
+						"security_primaries": []any{true},
+					}, "LookupProjection")},
+					map[string]any{"lookup": missingLookup, "projection": nil},
+				)
+			},
+		},
+	}
+}
+
+type strategyScript struct {
+	strategy LookupStrategy
+	script   func(db *recordingDB)
+}
+
+func TestGolden_LookupStrategies(t *testing.T) {
+	for _, tt := range strategyScripts() {
+		tt := tt
+		t.Run(tt.strategy.Name(), func(t *testing.T) {
+			db := &recordingDB{}
+			tt.script(db)
+
+			a := newAdapter(db, WithLookupStrategy(tt.strategy))
+			a.now = func() time.Time { return strategyDate.AddDate(1, 0, 0) }
+			res, err := a.LookupEntities(context.Background(), strategyLookups)
+			require.NoError(t, err)
+			requireAcmeResults(t, res)
+
+			requireGolden(t, "lookup_entities_"+tt.strategy.Name(), db.Queries())
+		})
+	}
+}
+
+func TestLookupStrategies_RepeatedLookups(t *testing.T) {
+	lookups := []resolve.Lookup{strategyLookups[0], strategyLookups[0], strategyLookups[1], strategyLookups[0], strategyLookups[1]}
+
+	for _, tt := range strategyScripts() {
+		tt := tt
+		t.Run(tt.strategy.Name(), func(t *testing.T) {
+			db := &recordingDB{}
+			tt.script(db)
+
+			a := newAdapter(db, WithLookupStrategy(tt.strategy))
+			a.now = func() time.Time { return strategyDate.AddDate(1, 0, 0) }
+			res, err := a.LookupEntities(context.Background(), lookups)
+			require.NoError(t, err)
+			// a single set of results per distinct lookup, like UnwindLookups
+			requireAcmeResults(t, res)
+
+			// and the same queries as for the distinct lookups
+			distinct := &recordingDB{}
+			tt.script(distinct)
+			a = newAdapter(distinct, WithLookupStrategy(tt.strategy))
+			a.now = func() time.Time { return strategyDate.AddDate(1, 0, 0) }
+			_, err = a.LookupEntities(context.Background(), strategyLookups)
+			require.NoError(t, err)
+			require.Equal(t, distinct.Queries(), db.Queries())
+		})
+	}
+}
+
+func TestGolden_BuildLookupProjection(t *testing.T) {
+	db := &recordingDB{}
+	db.reply()                                                         // create index
+	db.reply(map[string]any{"generation": int64(2)})                   // current generation
+	db.reply(map[string]any{"deleted": int64(0), "orphans": int64(0)}) // leftovers
+	db.reply(map[string]any{
+		"id": "1b4e28ba-2fa1-11d2-883f-0016d3cca427",
+		"names": []any{
+			map[string]any{"value": "Acme Ltd", "from": "2015-01-01T00:00:00Z", "until": "2020-06-01T00:00:00Z"},
+			map[string]any{"value": "Acme Plc", "from": "2020-06-01T00:00:00Z", "until": nil},
+		},
+		"countries": []any{},
+		"identifiers": []any{
+			map[string]any{"type": "sray_entity_id", "value": "42", "from": "2015-01-01T00:00:00Z", "until": nil},
+		},
+		"securities": []any{
+			map[string]any{
+				"name": "Acme Ord", "primary": true, "from": "2015-01-01T00:00:00Z", "until": "2020-06-01T00:00:00Z",
+				"identifiers": []any{map[string]any{"type": "isin", "value": "GB0002634946"}},
+			},
+		},
+	})
+
+	a := newAdapter(db, WithDataset("golden"))
+	stats, err := a.BuildLookupProjection(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Entities)
+	// Acme Ltd with both identifiers, then Acme Plc with the entity's
+	require.Equal(t, 3, stats.Projections)
+	require.Equal(t, 3, stats.Generation)
+
+	requireGolden(t, "build_lookup_projection", db.Queries())
+}
+
+func TestLookupStrategyByName(t *testing.T) {
+	for _, s := range LookupStrategies() {
+		got, err := LookupStrategyByName(s.Name())
+		require.NoError(t, err)
+		require.Equal(t, s, got)
+	}
+
+	_, err := LookupStrategyByName("nested_loop")
+	require.ErrorContains(t, err, `unknown lookup strategy "nested_loop"`)
+	require.ErrorContains(t, err, "unwind, per_lookup, two_phase, projection")
+}
+
+// TestProjectEntity checks looking up the projections gives the results of the
+// oracle.
+func TestProjectEntity(t *testing.T) {
+	gen := resolvetest.NewDataGen(1)
+	entities := gen.NewEntities(200)
+	lookups := gen.NewLookupsWith(2000, resolvetest.LookupConfig{})
+
+	var projections []projection
+	for _, e := range entities {
+		projections = append(projections, projectEntity(e)...)
+	}
+
+	for _, lookup := range lookups {
+		date := gen.Now()
+		if lookup.Date != nil {
+			date = *lookup.Date
+		}
+		var got []resolve.LookupResult
+		for i := range projections {
+			p := &projections[i]
+			if p.identifier == lookup.Identifier && p.duration.Contains(date) {
+				got = append(got, p.node.result())
+			}
+		}
+		if len(got) == 0 {
+			got = append(got, resolve.LookupResult{Success: false})
+		}
+
+		want := resolvetest.ExpectedResults(entities, lookup, gen.Now())
+		require.Equal(t, resolvetest.CanonicalResults(want), resolvetest.CanonicalResults(got), resolvetest.DescribeLookup(lookup))
+	}
+}
+
+func TestCompareLookupStrategies(t *testing.T) {
+	db := &recordingDB{}
+	// unwind finds acme, per_lookup finds nothing for the first lookup
+	db.reply(
+		acmeDetails(map[string]any{"lookup": acmeLookup}),
+		noDetails(map[string]any{"lookup": missingLookup}),
+	)
+	db.reply(noDetails(map[string]any{"lookup": acmeLookup}))
+	db.reply(noDetails(map[string]any{"lookup": missingLookup}))
+
+	a := newAdapter(db)
+	c, err := a.CompareLookupStrategies(context.Background(), strategyLookups, UnwindLookups, PerLookup, CompareConfig{})
+	require.NoError(t, err)
+	require.Equal(t, 2, c.Lookups)
+	require.Equal(t, 1, c.Batches)
+	require.Equal(t, 1, c.DiffCount)
+	require.Len(t, c.Diffs, 1)
+	require.Equal(t, strategyLookups[0], c.Diffs[0].Lookup)
+	require.Equal(t, "unwind", c.Base.Strategy)
+	require.Equal(t, "per_lookup", c.Other.Strategy)
+	require.Contains(t, c.String(), "isin:GB0002634946@2021-02-09T00:00:00Z: unwind [1b4e28ba-2fa1-11d2-883f-0016d3cca427/Acme Plc], per_lookup [no match]")
+
+	// the strategies alternate going first, and agree on the same replies
+	db = &recordingDB{}
+	for i := 0; i < 2; i++ {
+		db.reply(acmeDetails(map[string]any{"lookup": acmeLookup}))
+		db.reply(acmeDetails(map[string]any{"lookup": acmeLookup}))
+	}
+	db.reply(noDetails(map[string]any{"lookup": missingLookup}))
+	db.reply(noDetails(map[string]any{"lookup": missingLookup}))
+
+	a = newAdapter(db)
+	c, err = a.CompareLookupStrategies(context.Background(), strategyLookups, UnwindLookups, PerLookup, CompareConfig{BatchSize: 1})
+	require.NoError(t, err)
+	require.Equal(t, 2, c.Batches)
+	require.Zero(t, c.DiffCount, c.String())
+	queries := db.Queries()
+	require.Len(t, queries, 4)
+	require.Contains(t, queries[0].Cypher, "$lookupList")
+	require.Contains(t, queries[2].Cypher, "$lookup ")
+	require.Contains(t, queries[3].Cypher, "$lookupList")
+
+	// a result returned twice differs from one returned once
+	db = &recordingDB{}
+	db.reply(
+		acmeDetails(map[string]any{"lookup": acmeLookup}),
+		acmeDetails(map[string]any{"lookup": acmeLookup}),
+		noDetails(map[string]any{"lookup": missingLookup}),
+	)
+	db.reply(acmeDetails(map[string]any{"lookup": acmeLookup}))
+	db.reply(noDetails(map[string]any{"lookup": missingLookup}))
+
+	a = newAdapter(db)
+	c, err = a.CompareLookupStrategies(context.Background(), strategyLookups, UnwindLookups, PerLookup, CompareConfig{})
+	require.NoError(t, err)
+	require.Equal(t, 1, c.DiffCount, c.String())
+	require.Len(t, c.Diffs[0].Base, 2)
+	require.Len(t, c.Diffs[0].Other, 1)
+}
@@ -0,0 +1,202 @@
+package n4j
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"neo4j-starter/resolve"
+)
+
+const (
+	defaultStreamBatchSize = 1000
+	defaultStreamWorkers   = 4
+	defaultStreamMaxDelay  = 50 * time.Millisecond
+)
+
+// StreamConfig configures StreamLookups.
+type StreamConfig struct {
+	// BatchSize is the number of lookups per query.
+	BatchSize int
+	// Workers is the number of batches queried at the same time, which also
+	// bounds the number of batches held in memory.
+	Workers int
+	// MaxDelay is how long a partial batch waits for more lookups before it is
+	// queried, so that slow inputs still get results.
+	MaxDelay time.Duration
+}
+
+func (c *StreamConfig) setDefaults() {
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultStreamBatchSize
+	}
+	if c.Workers <= 0 {
+		c.Workers = defaultStreamWorkers
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = defaultStreamMaxDelay
+	}
+}
+
+// StreamResult is the result of a lookup read by StreamLookups.
+type StreamResult struct {
+	// Index is the position of the lookup in the input, starting at 0.
+	Index  int64
+	Lookup resolve.Lookup
+	// Results has a result for each matching entity, which is a single
+	// unsuccessful result if nothing was found.
+	Results []resolve.LookupResult
+}
+
+// StreamLookups reads lookups from in until it is closed, and sends their
+// results to out in the same order. Lookups are queried in batches with at
+// most cfg.Workers batches in flight, so when out is not read from the input
+// stops being read as well, and memory use doesn't depend on the input size.
+//
+// out is closed when StreamLookups returns. The first error stops the stream,
+// after which in is no longer read from, so producers should also stop when
+// ctx is done.
+func (a *Adapter) StreamLookups(ctx context.Context, in <-chan resolve.Lookup, out chan<- StreamResult, cfg StreamConfig) error {
+	return a.streamLookups(ctx, in, out, cfg, a.queryLookups)
+}
+
+type streamBatch struct {
+	start   int64
+	lookups []resolve.Lookup
+
+	// done is closed once found or err is set
+	done  chan struct{}
+	found map[lookupKey][]resolve.LookupResult
+	err   error
+}
+
+func (a *Adapter) streamLookups(ctx context.Context, in <-chan resolve.Lookup, out chan<- StreamResult, cfg StreamConfig, query lookupQuery) (err error) {
+	defer close(out)
+	cfg.setDefaults()
+
+	ctx, op := a.tel.start(ctx, opLookupStream, -1)
+	defer op.end(&err)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// batches in input order, the batch being sent to out plus those queued
+	// make up the batches in flight
+	pending := make(chan *streamBatch, cfg.Workers-1)
+
+	go func() {
+		defer close(pending)
+
+		var index int64
+		for {
+			lookups, more := readBatch(ctx, in, cfg.BatchSize, cfg.MaxDelay)
+			if len(lookups) > 0 {
+				b := &streamBatch{
+					start:   index,
+					lookups: lookups,
+					done:    make(chan struct{}),
+				}
+				index += int64(len(lookups))
+
+				select {
+				case pending <- b:
+				case <-ctx.Done():
+					return
+				}
+
+				go func() {
+					defer close(b.done)
+					b.found, b.err = a.lookupBatch(ctx, b.lookups, query)
+				}()
+			}
+			if !more {
+				return
+			}
+		}
+	}()
+
+	for b := range pending {
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if b.err != nil {
+			return fmt.Errorf("lookup batch at %d: %w", b.start, b.err)
+		}
+
+		for i, lookup := range b.lookups {
+			res := StreamResult{
+				Index:   b.start + int64(i),
+				Lookup:  lookup,
+				Results: b.found[newLookupKey(lookup)],
+			}
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	// pending is also closed when ctx is done
+	return ctx.Err()
+}
+
+func (a *Adapter) lookupBatch(ctx context.Context, lookups []resolve.Lookup, query lookupQuery) (_ map[lookupKey][]resolve.LookupResult, err error) {
+	ctx, op := a.tel.start(ctx, opLookupChunk, len(lookups))
+	defer op.end(&err)
+
+	found, err := a.lookupByKey(ctx, op, lookups, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits int
+	for _, res := range found {
+		if countFound(res) > 0 {
+			hits++
+		}
+	}
+	op.recordLookups(hits, len(found))
+
+	return found, nil
+}
+
+// readBatch reads up to size lookups, returning early once maxDelay has passed
+// since the first lookup was read. more is false once in is closed or ctx is
+// done.
+func readBatch(ctx context.Context, in <-chan resolve.Lookup, size int, maxDelay time.Duration) (_ []resolve.Lookup, more bool) {
+	var batch []resolve.Lookup
+
+	// wait as long as needed for the first lookup
+	select {
+	case lookup, ok := <-in:
+		if !ok {
+			return nil, false
+		}
+		batch = make([]resolve.Lookup, 0, size)
+		batch = append(batch, lookup)
+	case <-ctx.Done():
+		return nil, false
+	}
+
+	timer := time.NewTimer(maxDelay)
+	defer timer.Stop()
+
+	for len(batch) < size {
+		select {
+		case lookup, ok := <-in:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, lookup)
+		case <-timer.C:
+			return batch, true
+		case <-ctx.Done():
+			return batch, false
+		}
+	}
+
+	return batch, true
+}
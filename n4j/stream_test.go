@@ -0,0 +1,139 @@
+package n4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/stretchr/testify/require"
+)
+
+func streamLookup(i int) resolve.Lookup {
+	return resolve.Lookup{Identifier: resolve.Identifier{Type: "isin", Value: fmt.Sprint(i)}}
+}
+
+// fakeLookupQuery finds lookups with an even value, taking longer for earlier
+// batches so that they finish out of order.
+func fakeLookupQuery(inFlight, maxInFlight *int32) lookupQuery {
+	return func(ctx context.Context, lookups []resolve.Lookup) ([]lookupRow, error) {
+		n := atomic.AddInt32(inFlight, 1)
+		defer atomic.AddInt32(inFlight, -1)
+		for {
+			max := atomic.LoadInt32(maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(maxInFlight, max, n) {
+				break
+			}
+		}
+
+		var first int
+		fmt.Sscan(lookups[0].Identifier.Value, &first)
+		time.Sleep(time.Duration(100-first%100) * 20 * time.Microsecond)
+
+		rows := make([]lookupRow, 0, len(lookups))
+		for _, l := range lookups {
+			var v int
+			fmt.Sscan(l.Identifier.Value, &v)
+			rows = append(rows, lookupRow{key: newLookupKey(l), result: resolve.LookupResult{Success: v%2 == 0}})
+		}
+		return rows, nil
+	}
+}
+
+func TestAdapter_StreamLookups(t *testing.T) {
+	a := NewAdapter(nil)
+	const count = 5000
+
+	in := make(chan resolve.Lookup)
+	out := make(chan StreamResult)
+	go func() {
+		defer close(in)
+		for i := 0; i < count; i++ {
+			in <- streamLookup(i)
+		}
+	}()
+
+	var inFlight, maxInFlight int32
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.streamLookups(context.Background(), in, out, StreamConfig{BatchSize: 7, Workers: 3}, fakeLookupQuery(&inFlight, &maxInFlight))
+	}()
+
+	var i int64
+	for res := range out {
+		require.Equal(t, i, res.Index)
+		require.Equal(t, streamLookup(int(i)), res.Lookup)
+		require.Equal(t, []resolve.LookupResult{{Success: i%2 == 0}}, res.Results)
+		i++
+	}
+	require.NoError(t, <-errc)
+	require.Equal(t, int64(count), i)
+	require.LessOrEqual(t, maxInFlight, int32(3))
+}
+
+func TestAdapter_StreamLookups_PartialBatch(t *testing.T) {
+	a := NewAdapter(nil)
+
+	in := make(chan resolve.Lookup)
+	out := make(chan StreamResult)
+	var inFlight, maxInFlight int32
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.streamLookups(context.Background(), in, out, StreamConfig{BatchSize: 100, MaxDelay: time.Millisecond}, fakeLookupQuery(&inFlight, &maxInFlight))
+	}()
+
+	// results are returned before the batch is full or the input is closed
+	for i := 0; i < 3; i++ {
+		in <- streamLookup(i)
+		res := <-out
+		require.Equal(t, int64(i), res.Index)
+	}
+	close(in)
+
+	_, ok := <-out
+	require.False(t, ok)
+	require.NoError(t, <-errc)
+}
+
+func TestAdapter_StreamLookups_Error(t *testing.T) {
+	a := NewAdapter(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan resolve.Lookup)
+	out := make(chan StreamResult)
+	go func() {
+		defer close(in)
+		for i := 0; ; i++ {
+			select {
+			case in <- streamLookup(i):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	errBoom := errors.New("boom")
+	query := func(_ context.Context, lookups []resolve.Lookup) ([]lookupRow, error) {
+		if lookups[0].Identifier.Value == "20" {
+			return nil, errBoom
+		}
+		return nil, nil
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.streamLookups(ctx, in, out, StreamConfig{BatchSize: 10, Workers: 2, MaxDelay: time.Hour}, query)
+	}()
+
+	var received int
+	for range out {
+		received++
+	}
+	require.ErrorIs(t, <-errc, errBoom)
+	require.Equal(t, 20, received)
+}
@@ -0,0 +1,183 @@
+package n4j
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// QuerySummary describes a query run by an adapter operation, taken from the
+// driver's result summary.
+type QuerySummary struct {
+	// Operation is the adapter operation that ran the query, e.g.
+	// lookup_entities.
+	Operation string
+	Query     string
+	Params    map[string]any
+	Counters  neo4j.Counters
+	// AvailableAfter and ConsumedAfter are the server timings, and are negative
+	// if the server didn't send them.
+	AvailableAfter time.Duration
+	ConsumedAfter  time.Duration
+	// Elapsed is the wall time from running the query until the result was
+	// consumed.
+	Elapsed time.Duration
+	// DbHits is the total db hits of the profiled plan, and is only set when
+	// profiling is enabled with WithProfile.
+	DbHits int64
+}
+
+// WithQuerySummaries calls fn with the summary of every query. fn is called
+// from the goroutine running the query, so must be safe for concurrent use.
+func WithQuerySummaries(fn func(QuerySummary)) Option {
+	return func(a *Adapter) {
+		a.onQuery = fn
+	}
+}
+
+// WithSlowQueryLog logs queries taking at least threshold, with the params
+// rendered as literals so the query can be pasted into the browser. Lists of
+// more than a few items, such as a batch of lookups, are cut to their first
+// items followed by a comment with their length. A nil logger uses the
+// standard logger.
+func WithSlowQueryLog(threshold time.Duration, logger *log.Logger) Option {
+	return func(a *Adapter) {
+		if logger == nil {
+			logger = log.Default()
+		}
+		a.slowQuery = threshold
+		a.slowLog = logger
+	}
+}
+
+// WithProfile runs queries with PROFILE, so that db hits are collected. This
+// makes queries slower, so is only meant for tuning and benchmarks.
+func WithProfile() Option {
+	return func(a *Adapter) {
+		a.profile = true
+	}
+}
+
+// QueryStats totals the summaries of the queries run with a context from
+// WithQueryStats. It is safe for concurrent use.
+type QueryStats struct {
+	mu      sync.Mutex
+	queries int
+	dbHits  int64
+	elapsed time.Duration
+}
+
+type queryStatsKey struct{}
+
+// WithQueryStats returns a context which adds the summary of every query run
+// with it to stats, e.g. to report the db hits of a single LookupEntities call.
+func WithQueryStats(ctx context.Context, stats *QueryStats) context.Context {
+	return context.WithValue(ctx, queryStatsKey{}, stats)
+}
+
+func (s *QueryStats) add(sum QuerySummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queries++
+	s.dbHits += sum.DbHits
+	s.elapsed += sum.Elapsed
+}
+
+// Queries returns the number of queries run, including retried transactions.
+func (s *QueryStats) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+// DbHits returns the total db hits, which is zero unless the adapter was
+// created with WithProfile.
+func (s *QueryStats) DbHits() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dbHits
+}
+
+// Elapsed returns the total wall time of the queries. Concurrent queries are
+// counted separately, so this can be more than the time of the operation.
+func (s *QueryStats) Elapsed() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.elapsed
+}
+
+// queryText returns the query to run for qb.
+func (a *Adapter) queryText(qb *queryBuilder) string {
+	if a.profile {
+		return "PROFILE " + qb.String()
+	}
+	return qb.String()
+}
+
+// recordSummary passes the summary of a query built by qb to the query stats
+// in ctx, the summary hook and the slow query log.
+func (a *Adapter) recordSummary(ctx context.Context, qb *queryBuilder, summary neo4j.ResultSummary, elapsed time.Duration) {
+	sum := QuerySummary{
+		Operation:      operationName(ctx),
+		Query:          qb.String(),
+		Params:         qb.params,
+		Counters:       summary.Counters(),
+		AvailableAfter: summary.ResultAvailableAfter(),
+		ConsumedAfter:  summary.ResultConsumedAfter(),
+		Elapsed:        elapsed,
+		DbHits:         totalDbHits(summary.Profile()),
+	}
+
+	if stats, ok := ctx.Value(queryStatsKey{}).(*QueryStats); ok {
+		stats.add(sum)
+	}
+	if a.onQuery != nil {
+		a.onQuery(sum)
+	}
+	if a.slowLog != nil && elapsed >= a.slowQuery {
+		a.slowLog.Printf("slow query: %s took %v (db hits: %d)\n%s", sum.Operation, elapsed, sum.DbHits, slowQueryText(qb))
+	}
+}
+
+// slowQueryListItems is the number of items of a list param logged with a
+// slow query.
+const slowQueryListItems = 3
+
+// slowQueryText returns the query with its params as literals like
+// ToQueryWithParams, with list params cut to slowQueryListItems, so a slow
+// batch doesn't log every item of the batch.
+func slowQueryText(qb *queryBuilder) string {
+	params := make(map[string]any, len(qb.params))
+	for name, v := range qb.params {
+		params[name] = v
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 || rv.Len() <= slowQueryListItems {
+			continue
+		}
+		items := make([]string, 0, slowQueryListItems)
+		for i := 0; i < slowQueryListItems; i++ {
+			items = append(items, cypherLiteral(rv.Index(i).Interface()))
+		}
+		params[name] = literal(fmt.Sprintf("[%s /* %d items */]", strings.Join(items, ", "), rv.Len()))
+	}
+	return cleanQuery(replaceParams(qb.String(), params))
+}
+
+// totalDbHits sums the db hits of every operator in the plan.
+func totalDbHits(plan neo4j.ProfiledPlan) int64 {
+	if plan == nil {
+		return 0
+	}
+	hits := plan.DbHits()
+	for _, child := range plan.Children() {
+		hits += totalDbHits(child)
+	}
+	return hits
+}
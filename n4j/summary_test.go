@@ -0,0 +1,112 @@
+package n4j
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePlan struct {
+	neo4j.ProfiledPlan
+	dbHits   int64
+	children []neo4j.ProfiledPlan
+}
+
+func (p *fakePlan) DbHits() int64                  { return p.dbHits }
+func (p *fakePlan) Children() []neo4j.ProfiledPlan { return p.children }
+
+type fakeSummary struct {
+	neo4j.ResultSummary
+	plan neo4j.ProfiledPlan
+}
+
+func (s *fakeSummary) Counters() neo4j.Counters            { return nil }
+func (s *fakeSummary) ResultAvailableAfter() time.Duration { return time.Millisecond }
+func (s *fakeSummary) ResultConsumedAfter() time.Duration  { return 2 * time.Millisecond }
+func (s *fakeSummary) Profile() neo4j.ProfiledPlan {
+	if s.plan == nil {
+		// the driver returns a nil interface when there is no profile
+		return nil
+	}
+	return s.plan
+}
+
+func TestTotalDbHits(t *testing.T) {
+	plan := &fakePlan{dbHits: 1, children: []neo4j.ProfiledPlan{
+		&fakePlan{dbHits: 10},
+		&fakePlan{dbHits: 100, children: []neo4j.ProfiledPlan{&fakePlan{dbHits: 1000}}},
+	}}
+	require.Equal(t, int64(1111), totalDbHits(plan))
+	require.Equal(t, int64(0), totalDbHits(nil))
+}
+
+func TestAdapter_RecordSummary(t *testing.T) {
+	var logged bytes.Buffer
+	var summaries []QuerySummary
+	a := NewAdapter(nil,
+		WithProfile(),
+		WithSlowQueryLog(50*time.Millisecond, log.New(&logged, "", 0)),
+		WithQuerySummaries(func(s QuerySummary) {
+			summaries = append(summaries, s)
+		}),
+	)
+
+	qb := newQueryBuilder()
+	qb.WriteString("MATCH (i:Identifier {value: $value}) RETURN i")
+	qb.params["value"] = "US0000000001"
+	require.Equal(t, "PROFILE MATCH (i:Identifier {value: $value}) RETURN i", a.queryText(qb))
+
+	stats := &QueryStats{}
+	ctx := WithQueryStats(context.Background(), stats)
+	ctx, op := a.tel.start(ctx, opLookup, 1)
+	defer op.end(nil)
+
+	summary := &fakeSummary{plan: &fakePlan{dbHits: 3, children: []neo4j.ProfiledPlan{&fakePlan{dbHits: 4}}}}
+	a.recordSummary(ctx, qb, summary, 10*time.Millisecond)
+	require.Empty(t, logged.String())
+
+	a.recordSummary(ctx, qb, &fakeSummary{}, 60*time.Millisecond)
+	require.Contains(t, logged.String(), "slow query: lookup_entities took 60ms")
+	require.Contains(t, logged.String(), "MATCH (i:Identifier {value: 'US0000000001'}) RETURN i")
+
+	require.Len(t, summaries, 2)
+	require.Equal(t, opLookup, summaries[0].Operation)
+	require.Equal(t, int64(7), summaries[0].DbHits)
+	require.Equal(t, time.Millisecond, summaries[0].AvailableAfter)
+	require.Equal(t, qb.params, summaries[0].Params)
+
+	require.Equal(t, 2, stats.Queries())
+	require.Equal(t, int64(7), stats.DbHits())
+	require.Equal(t, 70*time.Millisecond, stats.Elapsed())
+}
+
+// A slow batch logs the first items of its lists and their length, not every
+// item.
+func TestSlowQueryText(t *testing.T) {
+	qb := newQueryBuilder()
+	qb.WriteString(`
+		UNWIND $lookupList AS lookup
+		WITH lookup WHERE lookup.value IN $values AND $now IS NOT NULL
+		RETURN lookup
+	`)
+	var lookupList []map[string]any
+	for i := 0; i < 1000; i++ {
+		lookupList = append(lookupList, map[string]any{"type": "isin", "value": fmt.Sprint(i)})
+	}
+	qb.params["lookupList"] = lookupList
+	qb.params["values"] = []string{"0", "1"}
+	qb.params["now"] = "2024-01-01T00:00:00Z"
+
+	require.Equal(t, `
+UNWIND [{type: 'isin', value: '0'}, {type: 'isin', value: '1'}, {type: 'isin', value: '2'} /* 1000 items */] AS lookup
+WITH lookup WHERE lookup.value IN ['0', '1'] AND '2024-01-01T00:00:00Z' IS NOT NULL
+RETURN lookup
+`, slowQueryText(qb))
+	require.Len(t, qb.params["lookupList"], 1000)
+}
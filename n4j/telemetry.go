@@ -0,0 +1,214 @@
+package n4j
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "neo4j-starter/n4j"
+
+// Operation names used for span names and the operation metric attribute.
+const (
+	opLookup           = "lookup_entities"
+	opLookupConcurrent = "lookup_entities_concurrent"
+	opLookupChunk      = "lookup_chunk"
+	opLookupStream     = "lookup_stream"
+	opCreate           = "create_entities"
+	opCreateParallel   = "create_entities_parallel"
+	opCreateBatch      = "create_batch"
+	opCleanup          = "cleanup"
+	opCleanupScoped    = "cleanup_scoped"
+	opSchema           = "create_indexes"
+	opExport           = "export_entities"
+	opEndEntity        = "end_entity"
+	opEraseEntity      = "erase_entity"
+	opBuildProjection  = "build_lookup_projection"
+	opCompareStrategy  = "compare_lookup_strategies"
+)
+
+var (
+	attrOperation = attribute.Key("n4j.operation")
+	attrBatchSize = attribute.Key("n4j.batch_size")
+	attrFound     = attribute.Key("n4j.found")
+	attrCacheHit  = attribute.Key("n4j.cache_hit")
+)
+
+type Option func(*Adapter)
+
+// WithTracerProvider sets the provider used for spans, defaults to the global
+// provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(a *Adapter) {
+		a.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider sets the provider used for metrics, defaults to the global
+// provider.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(a *Adapter) {
+		a.meterProvider = mp
+	}
+}
+
+// telemetry holds the instruments shared by all adapter operations. Exporters
+// are configured on the providers, so any OpenTelemetry exporter can be used.
+type telemetry struct {
+	tracer trace.Tracer
+
+	// duration of each operation in seconds
+	duration metric.Float64Histogram
+	// number of entities or lookups per operation
+	batchSize metric.Int64Histogram
+	// lookups by whether an entity was found, for the hit rate
+	lookups metric.Int64Counter
+	// failed operations
+	errors metric.Int64Counter
+	// lookups by whether they were found in the lookup cache
+	cache metric.Int64Counter
+}
+
+// newTelemetry creates the instruments. Creating them only fails on invalid
+// instrument names, in which case the error is passed to the otel error handler
+// and metrics are disabled rather than failing the adapter.
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *telemetry {
+	t, err := createInstruments(tp, mp)
+	if err != nil {
+		otel.Handle(err)
+		t, _ = createInstruments(tp, noop.NewMeterProvider())
+	}
+	return t
+}
+
+func createInstruments(tp trace.TracerProvider, mp metric.MeterProvider) (*telemetry, error) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	t := &telemetry{
+		tracer: tp.Tracer(instrumentationName),
+	}
+
+	var err error
+	t.duration, err = meter.Float64Histogram("n4j.operation.duration",
+		metric.WithDescription("Duration of adapter operations."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.batchSize, err = meter.Int64Histogram("n4j.operation.batch_size",
+		metric.WithDescription("Number of entities or lookups per adapter operation."),
+		metric.WithUnit("{item}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.lookups, err = meter.Int64Counter("n4j.lookups",
+		metric.WithDescription("Lookups by whether an entity was found."),
+		metric.WithUnit("{lookup}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.errors, err = meter.Int64Counter("n4j.operation.errors",
+		metric.WithDescription("Failed adapter operations."),
+		metric.WithUnit("{error}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	t.cache, err = meter.Int64Counter("n4j.lookup_cache.requests",
+		metric.WithDescription("Lookups by whether they were found in the lookup cache."),
+		metric.WithUnit("{lookup}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+type operationKey struct{}
+
+// operationName returns the name of the innermost operation started with ctx.
+func operationName(ctx context.Context) string {
+	name, _ := ctx.Value(operationKey{}).(string)
+	return name
+}
+
+type operation struct {
+	t     *telemetry
+	ctx   context.Context
+	name  string
+	span  trace.Span
+	start time.Time
+}
+
+// start starts a span for the operation. batchSize is recorded if it is not
+// negative. The returned context carries the span, so that nested operations
+// become child spans.
+func (t *telemetry) start(ctx context.Context, name string, batchSize int) (context.Context, *operation) {
+	ctx, span := t.tracer.Start(ctx, "n4j."+name, trace.WithAttributes(attrOperation.String(name)))
+	ctx = context.WithValue(ctx, operationKey{}, name)
+
+	if batchSize >= 0 {
+		span.SetAttributes(attrBatchSize.Int(batchSize))
+		t.batchSize.Record(ctx, int64(batchSize), metric.WithAttributes(attrOperation.String(name)))
+	}
+
+	return ctx, &operation{
+		t:     t,
+		ctx:   ctx,
+		name:  name,
+		span:  span,
+		start: time.Now(),
+	}
+}
+
+// end records the duration and error of the operation and ends its span. It
+// takes a pointer so that it can be deferred with a named error return.
+func (o *operation) end(errp *error) {
+	opt := metric.WithAttributes(attrOperation.String(o.name))
+
+	o.t.duration.Record(o.ctx, time.Since(o.start).Seconds(), opt)
+
+	if errp != nil && *errp != nil {
+		o.t.errors.Add(o.ctx, 1, opt)
+		o.span.RecordError(*errp)
+		o.span.SetStatus(codes.Error, (*errp).Error())
+	}
+	o.span.End()
+}
+
+// recordLookups records the hit rate of lookup results.
+func (o *operation) recordLookups(found, total int) {
+	o.span.SetAttributes(
+		attribute.Int("n4j.lookups.found", found),
+		attribute.Int("n4j.lookups.total", total),
+	)
+	o.t.lookups.Add(o.ctx, int64(found), metric.WithAttributes(attrOperation.String(o.name), attrFound.Bool(true)))
+	o.t.lookups.Add(o.ctx, int64(total-found), metric.WithAttributes(attrOperation.String(o.name), attrFound.Bool(false)))
+}
+
+// recordCache records the lookup cache hit rate.
+func (o *operation) recordCache(hits, misses int) {
+	o.span.SetAttributes(
+		attribute.Int("n4j.lookup_cache.hits", hits),
+		attribute.Int("n4j.lookup_cache.misses", misses),
+	)
+	o.t.cache.Add(o.ctx, int64(hits), metric.WithAttributes(attrOperation.String(o.name), attrCacheHit.Bool(true)))
+	o.t.cache.Add(o.ctx, int64(misses), metric.WithAttributes(attrOperation.String(o.name), attrCacheHit.Bool(false)))
+}
@@ -0,0 +1,114 @@
+package n4j
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"neo4j-starter/resolve"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j/config"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestTelemetry() (*telemetry, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	spans := tracetest.NewSpanRecorder()
+	metrics := sdkmetric.NewManualReader()
+	tel := newTelemetry(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(metrics)),
+	)
+	return tel, spans, metrics
+}
+
+// collectSums returns the int64 counter values of the named metric, keyed by
+// the attribute set.
+func collectSums(t *testing.T, reader *sdkmetric.ManualReader, name string) map[attribute.Distinct]int64 {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+
+	sums := map[attribute.Distinct]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				sums[dp.Attributes.Equivalent()] = dp.Value
+			}
+		}
+	}
+	return sums
+}
+
+func attrSet(kvs ...attribute.KeyValue) attribute.Distinct {
+	set := attribute.NewSet(kvs...)
+	return set.Equivalent()
+}
+
+func TestTelemetry_Operation(t *testing.T) {
+	tel, spans, metrics := newTestTelemetry()
+
+	ctx, op := tel.start(context.Background(), opLookup, 3)
+	_, child := tel.start(ctx, opLookupChunk, 3)
+	child.end(nil)
+	op.recordLookups(2, 3)
+	err := errors.New("boom")
+	op.end(&err)
+
+	ended := spans.Ended()
+	require.Len(t, ended, 2)
+
+	require.Equal(t, "n4j."+opLookupChunk, ended[0].Name())
+	require.Equal(t, ended[1].SpanContext().SpanID(), ended[0].Parent().SpanID())
+	require.Equal(t, codes.Unset, ended[0].Status().Code)
+
+	require.Equal(t, "n4j."+opLookup, ended[1].Name())
+	require.Equal(t, codes.Error, ended[1].Status().Code)
+	require.Equal(t, "boom", ended[1].Status().Description)
+	require.Contains(t, ended[1].Attributes(), attrBatchSize.Int(3))
+	require.Contains(t, ended[1].Attributes(), attribute.Int("n4j.lookups.found", 2))
+
+	lookups := collectSums(t, metrics, "n4j.lookups")
+	require.Equal(t, int64(2), lookups[attrSet(attrOperation.String(opLookup), attrFound.Bool(true))])
+	require.Equal(t, int64(1), lookups[attrSet(attrOperation.String(opLookup), attrFound.Bool(false))])
+
+	errs := collectSums(t, metrics, "n4j.operation.errors")
+	require.Equal(t, map[attribute.Distinct]int64{
+		attrSet(attrOperation.String(opLookup)): 1,
+	}, errs)
+}
+
+func TestAdapter_TelemetryOnError(t *testing.T) {
+	// nothing listens on this port, so every operation fails
+	driver, err := neo4j.NewDriverWithContext("bolt://127.0.0.1:1", neo4j.NoAuth(), func(c *config.Config) {
+		c.MaxTransactionRetryTime = 0
+	})
+	require.NoError(t, err)
+	defer driver.Close(context.Background())
+
+	spans := tracetest.NewSpanRecorder()
+	metrics := sdkmetric.NewManualReader()
+	adapter := NewAdapter(driver,
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(metrics))),
+	)
+
+	_, err = adapter.LookupEntities(context.Background(), []resolve.Lookup{{}})
+	require.Error(t, err)
+
+	ended := spans.Ended()
+	require.Len(t, ended, 1)
+	require.Equal(t, "n4j."+opLookup, ended[0].Name())
+	require.Equal(t, codes.Error, ended[0].Status().Code)
+
+	errs := collectSums(t, metrics, "n4j.operation.errors")
+	require.Equal(t, int64(1), errs[attrSet(attrOperation.String(opLookup))])
+}
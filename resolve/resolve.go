@@ -2,6 +2,7 @@ package resolve
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -19,11 +20,20 @@ type Entity struct {
 	Securities  []DetailDuration[[]Security]
 }
 
+// Duration is when a detail is valid, from its start date until its end date,
+// or indefinitely without an end date.
 type Duration struct {
 	StartDate time.Time
 	EndDate   *time.Time
 }
 
+// Contains reports whether the date is within the duration, which includes its
+// start date and excludes its end date, so that a detail ending on a date and
+// the detail replacing it are never both valid.
+func (d Duration) Contains(date time.Time) bool {
+	return !date.Before(d.StartDate) && (d.EndDate == nil || date.Before(*d.EndDate))
+}
+
 type DetailDuration[T any] struct {
 	Detail   T
 	Duration Duration
@@ -48,6 +58,8 @@ type Security struct {
 	IsPrimary   bool
 }
 
+// Lookup resolves the entities with the identifier at the date, or at the
+// current time if the date is nil.
 type Lookup struct {
 	Date       *time.Time
 	Identifier Identifier
@@ -58,6 +70,83 @@ type LookupResult struct {
 	Entity  *Entity
 }
 
+// Normalize sorts the entity details into a canonical order: durations by start
+// date, end date and then detail, identifiers by type and value, and securities
+// by name. Stores do not preserve insertion order, so this is used to compare
+// entities.
+func (e *Entity) Normalize() {
+	for _, idns := range e.Identifiers {
+		sortIdentifiers(idns.Detail)
+	}
+	for _, secs := range e.Securities {
+		for _, sec := range secs.Detail {
+			sortIdentifiers(sec.Identifiers)
+		}
+		sort.SliceStable(secs.Detail, func(i, j int) bool {
+			if secs.Detail[i].Name != secs.Detail[j].Name {
+				return secs.Detail[i].Name < secs.Detail[j].Name
+			}
+			return fmt.Sprint(secs.Detail[i]) < fmt.Sprint(secs.Detail[j])
+		})
+	}
+
+	// details are sorted first, as durations sharing dates are ordered by them
+	sortDurations(e.Name)
+	sortDurations(e.Country)
+	sortDurations(e.Identifiers)
+	sortDurations(e.Securities)
+}
+
+// AsOf returns the entity with only the details valid at the date, keeping
+// their durations.
+func (e *Entity) AsOf(date time.Time) *Entity {
+	return &Entity{
+		ID:          e.ID,
+		Name:        detailsAt(e.Name, date),
+		Country:     detailsAt(e.Country, date),
+		Identifiers: detailsAt(e.Identifiers, date),
+		Securities:  detailsAt(e.Securities, date),
+	}
+}
+
+func detailsAt[T any](details []DetailDuration[T], date time.Time) []DetailDuration[T] {
+	var valid []DetailDuration[T]
+	for _, d := range details {
+		if d.Duration.Contains(date) {
+			valid = append(valid, d)
+		}
+	}
+	return valid
+}
+
+// sortDurations sorts the details by start date, then end date with open ended
+// durations last, then by the printed detail.
+func sortDurations[T any](details []DetailDuration[T]) {
+	sort.SliceStable(details, func(i, j int) bool {
+		di, dj := details[i].Duration, details[j].Duration
+		if !di.StartDate.Equal(dj.StartDate) {
+			return di.StartDate.Before(dj.StartDate)
+		}
+		switch {
+		case di.EndDate == nil && dj.EndDate == nil:
+		case di.EndDate == nil || dj.EndDate == nil:
+			return dj.EndDate == nil
+		case !di.EndDate.Equal(*dj.EndDate):
+			return di.EndDate.Before(*dj.EndDate)
+		}
+		return fmt.Sprint(details[i].Detail) < fmt.Sprint(details[j].Detail)
+	})
+}
+
+func sortIdentifiers(identifiers []Identifier) {
+	sort.SliceStable(identifiers, func(i, j int) bool {
+		if identifiers[i].Type != identifiers[j].Type {
+			return identifiers[i].Type < identifiers[j].Type
+		}
+		return identifiers[i].Value < identifiers[j].Value
+	})
+}
+
 func ResolveEntities(request []Lookup) ([]LookupResult, error) {
 	// ...
 	return nil, fmt.Errorf("not yet implemented")
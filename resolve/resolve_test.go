@@ -0,0 +1,81 @@
+package resolve
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuration_Contains(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := from.AddDate(0, 1, 0)
+
+	d := Duration{StartDate: from, EndDate: &until}
+	require.False(t, d.Contains(from.Add(-time.Second)))
+	require.True(t, d.Contains(from), "includes the start date")
+	require.True(t, d.Contains(until.Add(-time.Second)))
+	require.False(t, d.Contains(until), "excludes the end date")
+
+	open := Duration{StartDate: from}
+	require.False(t, open.Contains(from.Add(-time.Second)))
+	require.True(t, open.Contains(from))
+	require.True(t, open.Contains(from.AddDate(100, 0, 0)))
+}
+
+func TestEntity_AsOf(t *testing.T) {
+	d := func(month time.Month) time.Time {
+		return time.Date(2020, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+	renamed := d(6)
+
+	e := &Entity{
+		Name: []DetailDuration[EntityName]{
+			{Detail: EntityName{Value: "Acme Ltd"}, Duration: Duration{StartDate: d(1), EndDate: &renamed}},
+			{Detail: EntityName{Value: "Acme Plc"}, Duration: Duration{StartDate: renamed}},
+		},
+		Identifiers: []DetailDuration[[]Identifier]{
+			{Detail: []Identifier{{Type: "sray_entity_id", Value: "1"}}, Duration: Duration{StartDate: d(3)}},
+		},
+	}
+
+	at := e.AsOf(d(2))
+	require.Equal(t, e.Name[:1], at.Name)
+	require.Empty(t, at.Identifiers)
+
+	// a name ending on a date is replaced by the next on that date
+	at = e.AsOf(renamed)
+	require.Equal(t, e.Name[1:], at.Name)
+	require.Equal(t, e.Identifiers, at.Identifiers)
+
+	require.Empty(t, e.AsOf(d(1).Add(-time.Second)).Name)
+}
+
+// Durations sharing a start date are ordered by end date and detail, so
+// entities read back in any order normalize the same.
+func TestEntity_Normalize(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := from.AddDate(1, 0, 0)
+
+	want := &Entity{
+		Name: []DetailDuration[EntityName]{
+			{Detail: EntityName{Value: "Acme Plc"}, Duration: Duration{StartDate: from, EndDate: &until}},
+			{Detail: EntityName{Value: "Acme Holdings"}, Duration: Duration{StartDate: from}},
+			{Detail: EntityName{Value: "Acme Ltd"}, Duration: Duration{StartDate: from}},
+		},
+		Identifiers: []DetailDuration[[]Identifier]{
+			{Detail: []Identifier{{Type: "isin", Value: "1"}, {Type: "sray_entity_id", Value: "2"}}, Duration: Duration{StartDate: from}},
+			{Detail: []Identifier{{Type: "isin", Value: "3"}}, Duration: Duration{StartDate: from}},
+		},
+	}
+
+	got := &Entity{
+		Name: []DetailDuration[EntityName]{want.Name[2], want.Name[1], want.Name[0]},
+		Identifiers: []DetailDuration[[]Identifier]{
+			want.Identifiers[1],
+			{Detail: []Identifier{{Type: "sray_entity_id", Value: "2"}, {Type: "isin", Value: "1"}}, Duration: Duration{StartDate: from}},
+		},
+	}
+	got.Normalize()
+	require.Equal(t, want, got)
+}
@@ -0,0 +1,225 @@
+package resolvetest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+)
+
+// Oracle computes the expected results of lookups on a set of entities, as
+// ExpectedResults, with undated lookups resolving at now.
+type Oracle struct {
+	byIdentifier map[resolve.Identifier][]*resolve.Entity
+	now          time.Time
+}
+
+func NewOracle(entities []*resolve.Entity, now time.Time) *Oracle {
+	o := &Oracle{byIdentifier: map[resolve.Identifier][]*resolve.Entity{}, now: now}
+	for _, e := range entities {
+		o.add(e)
+	}
+	return o
+}
+
+func (o *Oracle) add(e *resolve.Entity) {
+	seen := map[resolve.Identifier]bool{}
+	index := func(identifiers []resolve.Identifier) {
+		for _, idn := range identifiers {
+			if !seen[idn] {
+				seen[idn] = true
+				o.byIdentifier[idn] = append(o.byIdentifier[idn], e)
+			}
+		}
+	}
+
+	for _, idnd := range e.Identifiers {
+		index(idnd.Detail)
+	}
+	for _, sd := range e.Securities {
+		for _, sec := range sd.Detail {
+			index(sec.Identifiers)
+		}
+	}
+}
+
+// Expect returns the results a store should return for the lookup.
+func (o *Oracle) Expect(lookup resolve.Lookup) []resolve.LookupResult {
+	return ExpectedResults(o.byIdentifier[lookup.Identifier], lookup, o.now)
+}
+
+// ExpectedLookup is a generated lookup with the results a store holding the
+// generated entities should return for it.
+type ExpectedLookup struct {
+	Lookup   resolve.Lookup
+	Expected []resolve.LookupResult
+}
+
+// LookupEach looks up each lookup on its own, returning the results of each
+// lookup, as needed by Compare.
+func LookupEach(ctx context.Context, store Store, lookups []resolve.Lookup) ([][]resolve.LookupResult, error) {
+	results := make([][]resolve.LookupResult, 0, len(lookups))
+	for _, lookup := range lookups {
+		res, err := store.LookupEntities(ctx, []resolve.Lookup{lookup})
+		if err != nil {
+			return nil, fmt.Errorf("lookup %s: %w", DescribeLookup(lookup), err)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// TypeStats counts the matching entities of the lookups of an identifier type.
+// A returned match is correct if the entity was expected with the same name,
+// identifiers and securities.
+type TypeStats struct {
+	Lookups  int
+	Expected int
+	Returned int
+	Correct  int
+	// WrongDetails is the number of expected entities returned with different
+	// details.
+	WrongDetails int
+}
+
+// Precision is the fraction of returned matches that are correct, 1 if there
+// were none.
+func (s TypeStats) Precision() float64 {
+	if s.Returned == 0 {
+		return 1
+	}
+	return float64(s.Correct) / float64(s.Returned)
+}
+
+// Recall is the fraction of expected matches that were returned correctly, 1
+// if there were none.
+func (s TypeStats) Recall() float64 {
+	if s.Expected == 0 {
+		return 1
+	}
+	return float64(s.Correct) / float64(s.Expected)
+}
+
+// LookupDiff is a lookup whose results differ from the expected results.
+type LookupDiff struct {
+	Lookup   resolve.Lookup
+	Expected []resolve.LookupResult
+	Got      []resolve.LookupResult
+}
+
+func (d LookupDiff) String() string {
+	return fmt.Sprintf("%s: expected %s, got %s", DescribeLookup(d.Lookup), DescribeResults(d.Expected), DescribeResults(d.Got))
+}
+
+type Comparison struct {
+	ByType map[resolve.IdentifierType]*TypeStats
+	Diffs  []LookupDiff
+}
+
+// Total sums the stats of every identifier type.
+func (c Comparison) Total() TypeStats {
+	var total TypeStats
+	for _, s := range c.ByType {
+		total.Lookups += s.Lookups
+		total.Expected += s.Expected
+		total.Returned += s.Returned
+		total.Correct += s.Correct
+		total.WrongDetails += s.WrongDetails
+	}
+	return total
+}
+
+// String formats the stats as a table, one row per identifier type.
+func (c Comparison) String() string {
+	types := make([]string, 0, len(c.ByType))
+	for typ := range c.ByType {
+		types = append(types, string(typ))
+	}
+	sort.Strings(types)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-16s %8s %8s %8s %8s %9s %7s\n", "type", "lookups", "expected", "returned", "correct", "precision", "recall")
+	row := func(name string, s TypeStats) {
+		fmt.Fprintf(&sb, "%-16s %8d %8d %8d %8d %9.3f %7.3f\n", name, s.Lookups, s.Expected, s.Returned, s.Correct, s.Precision(), s.Recall())
+	}
+	for _, typ := range types {
+		row(typ, *c.ByType[resolve.IdentifierType(typ)])
+	}
+	row("total", c.Total())
+	return sb.String()
+}
+
+// Compare diffs the results of each lookup with its expected results.
+func Compare(expected []ExpectedLookup, results [][]resolve.LookupResult) Comparison {
+	c := Comparison{ByType: map[resolve.IdentifierType]*TypeStats{}}
+
+	for i, el := range expected {
+		var got []resolve.LookupResult
+		if i < len(results) {
+			got = results[i]
+		}
+
+		s := c.ByType[el.Lookup.Identifier.Type]
+		if s == nil {
+			s = &TypeStats{}
+			c.ByType[el.Lookup.Identifier.Type] = s
+		}
+		s.Lookups++
+
+		want := matches(el.Expected)
+		returned := matches(got)
+		s.Expected += len(want)
+		s.Returned += len(returned)
+
+		diff := len(want) != len(returned)
+		for id, res := range returned {
+			exp, ok := want[id]
+			switch {
+			case !ok:
+				diff = true
+			case reflect.DeepEqual(exp, res):
+				s.Correct++
+			default:
+				s.WrongDetails++
+				diff = true
+			}
+		}
+		if diff {
+			c.Diffs = append(c.Diffs, LookupDiff{Lookup: el.Lookup, Expected: el.Expected, Got: got})
+		}
+	}
+
+	return c
+}
+
+// matches returns the canonical successful results by entity id, with the
+// results of an entity with overlapping names together.
+func matches(results []resolve.LookupResult) map[uuid.UUID][]resolve.LookupResult {
+	byID := map[uuid.UUID][]resolve.LookupResult{}
+	for _, r := range CanonicalResults(results) {
+		if r.Success && r.Entity != nil {
+			byID[r.Entity.ID] = append(byID[r.Entity.ID], r)
+		}
+	}
+	return byID
+}
+
+// DescribeResults describes the results in canonical order by entity and name,
+// e.g. in test failures.
+func DescribeResults(results []resolve.LookupResult) string {
+	var parts []string
+	for _, r := range CanonicalResults(results) {
+		if r.Entity == nil {
+			parts = append(parts, "no match")
+			continue
+		}
+		parts = append(parts, resultKey(r))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
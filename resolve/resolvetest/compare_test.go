@@ -0,0 +1,78 @@
+package resolvetest
+
+import (
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	entity := func(id uuid.UUID, name string) *resolve.Entity {
+		return &resolve.Entity{
+			ID:          id,
+			Name:        []resolve.DetailDuration[resolve.EntityName]{{Detail: resolve.EntityName{Value: name}}},
+			Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{{}},
+			Securities:  []resolve.DetailDuration[[]resolve.Security]{{}},
+		}
+	}
+	a, b := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	date := time.Date(2021, 2, 9, 0, 0, 0, 0, time.UTC)
+	lookup := func(typ, value string) resolve.Lookup {
+		return resolve.Lookup{Date: &date, Identifier: resolve.Identifier{Type: resolve.IdentifierType(typ), Value: value}}
+	}
+	miss := []resolve.LookupResult{{Success: false}}
+
+	expected := []ExpectedLookup{
+		{Lookup: lookup("isin", "1"), Expected: []resolve.LookupResult{{Success: true, Entity: entity(a, "Acme")}}},
+		{Lookup: lookup("isin", "2"), Expected: []resolve.LookupResult{{Success: true, Entity: entity(b, "Globex")}}},
+		{Lookup: lookup("asset_id", "3"), Expected: miss},
+		{Lookup: lookup("asset_id", "4"), Expected: []resolve.LookupResult{{Success: true, Entity: entity(a, "Acme")}}},
+	}
+	results := [][]resolve.LookupResult{
+		{{Success: true, Entity: entity(a, "Acme")}},
+		// wrong name
+		{{Success: true, Entity: entity(b, "Initech")}},
+		// unexpected match
+		{{Success: true, Entity: entity(b, "Globex")}},
+		// missed
+		miss,
+	}
+
+	c := Compare(expected, results)
+	require.Equal(t, TypeStats{Lookups: 2, Expected: 2, Returned: 2, Correct: 1, WrongDetails: 1}, *c.ByType["isin"])
+	require.Equal(t, TypeStats{Lookups: 2, Expected: 1, Returned: 1}, *c.ByType["asset_id"])
+	require.Equal(t, 0.5, c.ByType["isin"].Precision())
+	require.Equal(t, 0.5, c.ByType["isin"].Recall())
+	require.Equal(t, 0.0, c.ByType["asset_id"].Recall())
+	require.Len(t, c.Diffs, 3)
+	require.Contains(t, c.Diffs[0].String(), "isin:2@2021-02-09T00:00:00Z")
+	require.Contains(t, c.String(), "total")
+}
+
+func TestDataGen_NewExpectedLookups(t *testing.T) {
+	gen := NewDataGen(1)
+	entities := gen.NewEntities(200)
+	expected := gen.NewExpectedLookups(100, 200, gen.now.AddDate(-3, 0, 0))
+
+	// each lookup has its own date
+	require.True(t, expected[1].Lookup.Date.After(*expected[0].Lookup.Date))
+
+	// some lookups are expected to match and some not
+	c := Compare(expected, nil)
+	require.Positive(t, c.Total().Expected)
+	require.Less(t, c.Total().Expected, len(expected)*2)
+
+	// the oracle agrees with ExpectedResults on every generated entity
+	results := make([][]resolve.LookupResult, 0, len(expected))
+	for _, el := range expected {
+		results = append(results, ExpectedResults(entities, el.Lookup, gen.Now()))
+	}
+	c = Compare(expected, results)
+	require.Empty(t, c.Diffs)
+	require.Equal(t, 1.0, c.Total().Precision())
+	require.Equal(t, 1.0, c.Total().Recall())
+}
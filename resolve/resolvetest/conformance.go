@@ -0,0 +1,304 @@
+package resolvetest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// Store is a resolve store, implemented by n4j.Adapter and memstore.Store.
+type Store interface {
+	CreateEntities(ctx context.Context, entities []*resolve.Entity) error
+	LookupEntities(ctx context.Context, lookups []resolve.Lookup) ([]resolve.LookupResult, error)
+}
+
+type scenario struct {
+	name     string
+	entities []*resolve.Entity
+	lookups  []resolve.Lookup
+}
+
+// RunConformance checks that a store resolves lookups as ExpectedResults, for
+// scenarios covering how identifiers, names and securities change over time.
+// newStore must return an empty store resolving undated lookups at now, which
+// is DefaultNow, so the results expected of undated lookups don't depend on
+// when the tests run.
+//
+// Each lookup is checked on its own, then all the lookups of a scenario in a
+// single call. Stores don't return results in any particular order, so
+// results are compared in canonical order. The fixtures in fixtures/ are then
+// run with RunFixture.
+func RunConformance(t *testing.T, newStore func(t *testing.T, now time.Time) Store) {
+	for _, sc := range scenarios() {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t, DefaultNow)
+			require.NoError(t, store.CreateEntities(ctx, sc.entities))
+
+			var want []resolve.LookupResult
+			seen := map[string]bool{}
+			for _, lookup := range sc.lookups {
+				expected := ExpectedResults(sc.entities, lookup, DefaultNow)
+
+				got, err := store.LookupEntities(ctx, []resolve.Lookup{lookup})
+				require.NoError(t, err)
+				require.Equal(t, CanonicalResults(expected), CanonicalResults(got), "lookup %s", DescribeLookup(lookup))
+
+				// repeated lookups have a single set of results
+				if key := DescribeLookup(lookup); !seen[key] {
+					seen[key] = true
+					want = append(want, expected...)
+				}
+			}
+
+			got, err := store.LookupEntities(ctx, sc.lookups)
+			require.NoError(t, err)
+			require.Equal(t, CanonicalResults(want), CanonicalResults(got), "all lookups")
+		})
+	}
+
+	fixtures, err := Fixtures()
+	require.NoError(t, err)
+	for _, f := range fixtures {
+		f := f
+		t.Run("fixture "+f.Name, func(t *testing.T) {
+			RunFixture(t, newStore(t, DefaultNow), f)
+		})
+	}
+}
+
+func scenarios() []scenario {
+	d := func(year int, month time.Month) time.Time {
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+	at := func(year int, month time.Month) *time.Time {
+		date := d(year, month)
+		return &date
+	}
+	id := func(n int) uuid.UUID {
+		return uuid.Must(uuid.FromString(fmt.Sprintf("00000000-0000-4000-8000-%012d", n)))
+	}
+	idn := func(typ, value string) resolve.Identifier {
+		return resolve.Identifier{Type: resolve.IdentifierType(typ), Value: value}
+	}
+	name := func(value string, from time.Time, until *time.Time) resolve.DetailDuration[resolve.EntityName] {
+		return resolve.DetailDuration[resolve.EntityName]{
+			Detail:   resolve.EntityName{Value: value},
+			Duration: resolve.Duration{StartDate: from, EndDate: until},
+		}
+	}
+	identifiers := func(from time.Time, until *time.Time, idns ...resolve.Identifier) resolve.DetailDuration[[]resolve.Identifier] {
+		return resolve.DetailDuration[[]resolve.Identifier]{
+			Detail:   idns,
+			Duration: resolve.Duration{StartDate: from, EndDate: until},
+		}
+	}
+	securities := func(from time.Time, until *time.Time, secs ...resolve.Security) resolve.DetailDuration[[]resolve.Security] {
+		return resolve.DetailDuration[[]resolve.Security]{
+			Detail:   secs,
+			Duration: resolve.Duration{StartDate: from, EndDate: until},
+		}
+	}
+	lookup := func(date *time.Time, identifier resolve.Identifier) resolve.Lookup {
+		return resolve.Lookup{Date: date, Identifier: identifier}
+	}
+
+	return []scenario{
+		{
+			// the identifier moves from one entity to another, the lookup
+			// finds whichever has it at the date
+			name: "identifier reassignment",
+			entities: []*resolve.Entity{
+				{
+					ID:          id(1),
+					Name:        []resolve.DetailDuration[resolve.EntityName]{name("Acme Ltd", d(2010, 1), nil)},
+					Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{identifiers(d(2010, 1), at(2018, 1), idn("sray_entity_id", "1"))},
+				},
+				{
+					ID:          id(2),
+					Name:        []resolve.DetailDuration[resolve.EntityName]{name("Globex Plc", d(2012, 1), nil)},
+					Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{identifiers(d(2018, 1), nil, idn("sray_entity_id", "1"))},
+				},
+			},
+			lookups: []resolve.Lookup{
+				lookup(at(2015, 6), idn("sray_entity_id", "1")),
+				lookup(at(2018, 1), idn("sray_entity_id", "1")),
+				lookup(at(2020, 6), idn("sray_entity_id", "1")),
+				lookup(at(2005, 6), idn("sray_entity_id", "1")),
+			},
+		},
+		{
+			name: "name changes",
+			entities: []*resolve.Entity{
+				{
+					ID: id(3),
+					Name: []resolve.DetailDuration[resolve.EntityName]{
+						name("Initech Ltd", d(2010, 1), at(2014, 1)),
+						name("Initech Plc", d(2014, 1), at(2019, 1)),
+					},
+					Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{
+						identifiers(d(2010, 1), at(2016, 1), idn("resolve_id", "r3"), idn("sray_entity_id", "3")),
+						identifiers(d(2016, 1), nil, idn("resolve_id", "r3")),
+					},
+				},
+			},
+			lookups: []resolve.Lookup{
+				lookup(at(2012, 6), idn("resolve_id", "r3")),
+				lookup(at(2014, 1), idn("resolve_id", "r3")),
+				lookup(at(2017, 6), idn("resolve_id", "r3")),
+				// no name after 2019
+				lookup(at(2020, 6), idn("resolve_id", "r3")),
+				lookup(at(2017, 6), idn("sray_entity_id", "3")),
+			},
+		},
+		{
+			name: "security only matches",
+			entities: []*resolve.Entity{
+				{
+					ID:          id(4),
+					Name:        []resolve.DetailDuration[resolve.EntityName]{name("Umbrella Corp", d(2010, 1), nil)},
+					Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{identifiers(d(2010, 1), nil, idn("sray_entity_id", "4"))},
+					Securities: []resolve.DetailDuration[[]resolve.Security]{
+						securities(d(2011, 1), at(2019, 1),
+							resolve.Security{Name: "Umbrella Ord", IsPrimary: true, Identifiers: []resolve.Identifier{idn("isin", "US0000000004"), idn("asset_id", "40")}},
+							resolve.Security{Name: "Umbrella Pref"},
+						),
+						securities(d(2019, 1), nil,
+							resolve.Security{Name: "Umbrella New", IsPrimary: true, Identifiers: []resolve.Identifier{idn("asset_id", "41")}},
+						),
+					},
+				},
+			},
+			lookups: []resolve.Lookup{
+				lookup(at(2015, 6), idn("isin", "US0000000004")),
+				lookup(at(2015, 6), idn("asset_id", "40")),
+				lookup(at(2020, 6), idn("asset_id", "40")),
+				lookup(at(2020, 6), idn("asset_id", "41")),
+				lookup(at(2010, 6), idn("asset_id", "40")),
+			},
+		},
+		{
+			// undated lookups resolve at the current time
+			name: "undated lookups",
+			entities: []*resolve.Entity{
+				{
+					ID:          id(5),
+					Name:        []resolve.DetailDuration[resolve.EntityName]{name("Hooli Inc", d(2010, 1), nil)},
+					Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{identifiers(d(2010, 1), at(2015, 1), idn("sray_entity_id", "5"))},
+					Securities: []resolve.DetailDuration[[]resolve.Security]{
+						securities(d(2010, 1), nil, resolve.Security{Name: "Hooli Ord", Identifiers: []resolve.Identifier{idn("isin", "US0000000005")}}),
+					},
+				},
+			},
+			lookups: []resolve.Lookup{
+				lookup(nil, idn("sray_entity_id", "5")),
+				lookup(nil, idn("isin", "US0000000005")),
+				lookup(nil, idn("isin", "US0000000099")),
+			},
+		},
+		{
+			// several entities share an identifier at the same time
+			name: "ambiguous matches",
+			entities: []*resolve.Entity{
+				{
+					ID:          id(6),
+					Name:        []resolve.DetailDuration[resolve.EntityName]{name("Vandelay Industries", d(2010, 1), nil)},
+					Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{identifiers(d(2010, 1), nil, idn("sray_entity_id", "6"))},
+					Securities: []resolve.DetailDuration[[]resolve.Security]{
+						securities(d(2010, 1), nil, resolve.Security{Name: "Vandelay Ord", Identifiers: []resolve.Identifier{idn("cusip", "000000006")}}),
+					},
+				},
+				{
+					ID:          id(7),
+					Name:        []resolve.DetailDuration[resolve.EntityName]{name("Vandelay Holdings", d(2012, 1), nil)},
+					Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{identifiers(d(2012, 1), nil, idn("cusip", "000000006"))},
+				},
+			},
+			lookups: []resolve.Lookup{
+				lookup(at(2015, 6), idn("cusip", "000000006")),
+				lookup(at(2011, 6), idn("cusip", "000000006")),
+			},
+		},
+		{
+			name: "duplicate lookups",
+			entities: []*resolve.Entity{
+				{
+					ID:          id(8),
+					Name:        []resolve.DetailDuration[resolve.EntityName]{name("Stark Industries", d(2010, 1), nil)},
+					Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{identifiers(d(2010, 1), nil, idn("sray_entity_id", "8"))},
+				},
+			},
+			lookups: []resolve.Lookup{
+				lookup(at(2015, 6), idn("sray_entity_id", "8")),
+				lookup(at(2015, 6), idn("sray_entity_id", "8")),
+				lookup(at(2016, 6), idn("sray_entity_id", "8")),
+				lookup(at(2015, 6), idn("sray_entity_id", "8")),
+				lookup(at(2015, 6), idn("sray_entity_id", "missing")),
+				lookup(at(2015, 6), idn("sray_entity_id", "missing")),
+			},
+		},
+	}
+}
+
+// DescribeLookup describes the lookup as type:value@date, e.g. in test
+// failures.
+func DescribeLookup(lookup resolve.Lookup) string {
+	date := "undated"
+	if lookup.Date != nil {
+		date = lookup.Date.UTC().Format(time.RFC3339)
+	}
+	return string(lookup.Identifier.Type) + ":" + lookup.Identifier.Value + "@" + date
+}
+
+// CanonicalResults returns copies of the results in canonical order, with
+// empty details as nil, so results are compared whatever order a store
+// returns them in. Repeated results are kept.
+func CanonicalResults(results []resolve.LookupResult) []resolve.LookupResult {
+	canonical := make([]resolve.LookupResult, 0, len(results))
+	for _, r := range results {
+		if r.Entity != nil {
+			e := *r.Entity
+			e.Name = append([]resolve.DetailDuration[resolve.EntityName](nil), e.Name...)
+			e.Identifiers = nil
+			for _, idnd := range r.Entity.Identifiers {
+				idnd.Detail = append([]resolve.Identifier(nil), idnd.Detail...)
+				e.Identifiers = append(e.Identifiers, idnd)
+			}
+			e.Securities = nil
+			for _, sd := range r.Entity.Securities {
+				sd.Detail = append([]resolve.Security(nil), sd.Detail...)
+				e.Securities = append(e.Securities, sd)
+			}
+			e.Normalize()
+			if len(e.Name) == 0 {
+				e.Name = nil
+			}
+			r.Entity = &e
+		}
+		canonical = append(canonical, r)
+	}
+
+	sort.SliceStable(canonical, func(i, j int) bool {
+		return resultKey(canonical[i]) < resultKey(canonical[j])
+	})
+	return canonical
+}
+
+func resultKey(r resolve.LookupResult) string {
+	if r.Entity == nil {
+		return ""
+	}
+	key := r.Entity.ID.String()
+	if len(r.Entity.Name) > 0 {
+		key += "/" + r.Entity.Name[0].Detail.Value
+	}
+	return key
+}
@@ -0,0 +1,462 @@
+package resolvetest
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed fixtures/*.json
+var fixtureFiles embed.FS
+
+// Fixture is a scenario of entity histories, with lookups and the results
+// expected of them, read from a JSON fixture such as:
+//
+//	{
+//		"name": "isin moves",
+//		"entities": [
+//			{
+//				"id": "a",
+//				"names": [{"value": "Acme Ltd", "from": "2010-01-01"}],
+//				"identifiers": [{"ids": ["sray_entity_id:1"], "from": "2010-01-01"}],
+//				"securities": [{
+//					"securities": [{"name": "Acme Ord", "primary": true, "ids": ["isin:US0000000001"]}],
+//					"from": "2010-01-01", "until": "2020-06-01"
+//				}]
+//			}
+//		],
+//		"lookups": [
+//			{"lookup": "isin:US0000000001@2020-05-31", "expect": [{"entity": "a", "name": "Acme Ltd"}]},
+//			{"lookup": "isin:US0000000001@2020-06-01", "expect": []}
+//		]
+//	}
+//
+// Entity ids are names within the fixture, or UUIDs. Identifiers are written
+// as type:value, and lookups as type:value@date, or type:value to look up at
+// the current time.
+// Dates are either days or RFC3339 times, and a duration without until hasn't
+// ended.
+//
+// An expected result names the entity, and optionally its name, which is ""
+// for no name, and its identifiers, securities and primary security at the
+// date. Details that are left out aren't checked.
+type Fixture struct {
+	Name     string
+	Entities []*resolve.Entity
+	Lookups  []FixtureLookup
+
+	// fixture ids of the entities
+	ids map[uuid.UUID]string
+}
+
+type FixtureLookup struct {
+	Lookup resolve.Lookup
+	Expect []FixtureResult
+}
+
+// FixtureResult is an expected result. Nil fields aren't checked.
+type FixtureResult struct {
+	Entity      string
+	Name        *string
+	Identifiers []resolve.Identifier
+	Securities  []string
+	Primary     *string
+}
+
+type fixtureFile struct {
+	Name     string          `json:"name"`
+	Entities []fixtureEntity `json:"entities"`
+	Lookups  []fixtureLookup `json:"lookups"`
+}
+
+type fixtureEntity struct {
+	ID          string               `json:"id"`
+	Names       []fixtureValue       `json:"names,omitempty"`
+	Countries   []fixtureValue       `json:"countries,omitempty"`
+	Identifiers []fixtureIdentifiers `json:"identifiers,omitempty"`
+	Securities  []fixtureSecurities  `json:"securities,omitempty"`
+}
+
+type fixtureDuration struct {
+	From  string `json:"from"`
+	Until string `json:"until,omitempty"`
+}
+
+type fixtureValue struct {
+	fixtureDuration
+	Value string `json:"value"`
+}
+
+type fixtureIdentifiers struct {
+	fixtureDuration
+	IDs []string `json:"ids"`
+}
+
+type fixtureSecurities struct {
+	fixtureDuration
+	Securities []fixtureSecurity `json:"securities"`
+}
+
+type fixtureSecurity struct {
+	Name    string   `json:"name"`
+	Primary bool     `json:"primary"`
+	IDs     []string `json:"ids"`
+}
+
+type fixtureLookup struct {
+	Lookup string          `json:"lookup"`
+	Expect []fixtureResult `json:"expect"`
+}
+
+type fixtureResult struct {
+	Entity      string   `json:"entity"`
+	Name        *string  `json:"name,omitempty"`
+	Identifiers []string `json:"identifiers,omitempty"`
+	Securities  []string `json:"securities,omitempty"`
+	Primary     *string  `json:"primary,omitempty"`
+}
+
+// LoadFixture reads a fixture file.
+func LoadFixture(name string) (*Fixture, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("load fixture: %w", err)
+	}
+	return ParseFixture(data)
+}
+
+// ParseFixture parses a JSON fixture.
+func ParseFixture(data []byte) (*Fixture, error) {
+	var ff fixtureFile
+	if err := json.Unmarshal(data, &ff); err != nil {
+		return nil, fmt.Errorf("parse fixture: %w", err)
+	}
+
+	f, err := ff.build()
+	if err != nil {
+		return nil, fmt.Errorf("parse fixture %q: %w", ff.Name, err)
+	}
+	return f, nil
+}
+
+// Fixtures returns the fixtures run by RunConformance.
+func Fixtures() ([]*Fixture, error) {
+	names, err := fixtureFiles.ReadDir("fixtures")
+	if err != nil {
+		return nil, fmt.Errorf("read fixtures: %w", err)
+	}
+
+	fixtures := make([]*Fixture, 0, len(names))
+	for _, n := range names {
+		data, err := fixtureFiles.ReadFile(path.Join("fixtures", n.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read fixture: %w", err)
+		}
+		f, err := ParseFixture(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n.Name(), err)
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+func (ff fixtureFile) build() (*Fixture, error) {
+	f := &Fixture{
+		Name: ff.Name,
+		ids:  make(map[uuid.UUID]string, len(ff.Entities)),
+	}
+	byName := make(map[string]uuid.UUID, len(ff.Entities))
+
+	for _, fe := range ff.Entities {
+		e, err := fe.build(ff.Name)
+		if err != nil {
+			return nil, fmt.Errorf("entity %q: %w", fe.ID, err)
+		}
+		if _, ok := byName[fe.ID]; ok {
+			return nil, fmt.Errorf("entity %q: duplicate id", fe.ID)
+		}
+		byName[fe.ID] = e.ID
+		f.ids[e.ID] = fe.ID
+		f.Entities = append(f.Entities, e)
+	}
+
+	for _, fl := range ff.Lookups {
+		lookup, err := parseLookup(fl.Lookup)
+		if err != nil {
+			return nil, err
+		}
+
+		expect := make([]FixtureResult, 0, len(fl.Expect))
+		for _, fr := range fl.Expect {
+			if _, ok := byName[fr.Entity]; !ok {
+				return nil, fmt.Errorf("lookup %q: unknown entity %q", fl.Lookup, fr.Entity)
+			}
+			identifiers, err := parseIdentifiers(fr.Identifiers)
+			if err != nil {
+				return nil, fmt.Errorf("lookup %q: %w", fl.Lookup, err)
+			}
+			expect = append(expect, FixtureResult{
+				Entity:      fr.Entity,
+				Name:        fr.Name,
+				Identifiers: identifiers,
+				Securities:  fr.Securities,
+				Primary:     fr.Primary,
+			})
+		}
+		f.Lookups = append(f.Lookups, FixtureLookup{Lookup: lookup, Expect: expect})
+	}
+
+	return f, nil
+}
+
+func (fe fixtureEntity) build(fixture string) (*resolve.Entity, error) {
+	id, err := uuid.FromString(fe.ID)
+	if err != nil {
+		// stable across runs, and distinct between fixtures
+		id = uuid.NewV5(uuid.NamespaceOID, "neo4j-starter/fixtures/"+fixture+"/"+fe.ID)
+	}
+	e := &resolve.Entity{ID: id}
+
+	for _, fv := range fe.Names {
+		d, err := fv.parse()
+		if err != nil {
+			return nil, fmt.Errorf("name %q: %w", fv.Value, err)
+		}
+		e.Name = append(e.Name, resolve.DetailDuration[resolve.EntityName]{Detail: resolve.EntityName{Value: fv.Value}, Duration: d})
+	}
+	for _, fv := range fe.Countries {
+		d, err := fv.parse()
+		if err != nil {
+			return nil, fmt.Errorf("country %q: %w", fv.Value, err)
+		}
+		e.Country = append(e.Country, resolve.DetailDuration[resolve.EntityCountry]{Detail: resolve.EntityCountry{Value: fv.Value}, Duration: d})
+	}
+	for _, fi := range fe.Identifiers {
+		d, err := fi.parse()
+		if err != nil {
+			return nil, fmt.Errorf("identifiers: %w", err)
+		}
+		identifiers, err := parseIdentifiers(fi.IDs)
+		if err != nil {
+			return nil, err
+		}
+		e.Identifiers = append(e.Identifiers, resolve.DetailDuration[[]resolve.Identifier]{Detail: identifiers, Duration: d})
+	}
+	for _, fs := range fe.Securities {
+		d, err := fs.parse()
+		if err != nil {
+			return nil, fmt.Errorf("securities: %w", err)
+		}
+		securities := make([]resolve.Security, 0, len(fs.Securities))
+		for _, sec := range fs.Securities {
+			identifiers, err := parseIdentifiers(sec.IDs)
+			if err != nil {
+				return nil, fmt.Errorf("security %q: %w", sec.Name, err)
+			}
+			securities = append(securities, resolve.Security{Name: sec.Name, IsPrimary: sec.Primary, Identifiers: identifiers})
+		}
+		e.Securities = append(e.Securities, resolve.DetailDuration[[]resolve.Security]{Detail: securities, Duration: d})
+	}
+
+	return e, nil
+}
+
+func (fd fixtureDuration) parse() (resolve.Duration, error) {
+	from, err := parseFixtureDate(fd.From)
+	if err != nil {
+		return resolve.Duration{}, fmt.Errorf("from: %w", err)
+	}
+	d := resolve.Duration{StartDate: from}
+	if fd.Until == "" {
+		return d, nil
+	}
+
+	until, err := parseFixtureDate(fd.Until)
+	if err != nil {
+		return resolve.Duration{}, fmt.Errorf("until: %w", err)
+	}
+	if !until.After(from) {
+		return resolve.Duration{}, fmt.Errorf("until %s is not after from %s", fd.Until, fd.From)
+	}
+	d.EndDate = &until
+	return d, nil
+}
+
+func parseFixtureDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date %q is neither a day nor RFC3339", s)
+	}
+	return t.UTC(), nil
+}
+
+func parseIdentifiers(ids []string) ([]resolve.Identifier, error) {
+	if ids == nil {
+		return nil, nil
+	}
+	identifiers := make([]resolve.Identifier, 0, len(ids))
+	for _, id := range ids {
+		typ, value, ok := strings.Cut(id, ":")
+		if !ok || typ == "" || value == "" {
+			return nil, fmt.Errorf("identifier %q is not type:value", id)
+		}
+		identifiers = append(identifiers, resolve.Identifier{Type: resolve.IdentifierType(typ), Value: value})
+	}
+	return identifiers, nil
+}
+
+func parseLookup(s string) (resolve.Lookup, error) {
+	id, date, dated := s, "", false
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		id, date, dated = s[:i], s[i+1:], true
+	}
+
+	identifiers, err := parseIdentifiers([]string{id})
+	if err != nil {
+		return resolve.Lookup{}, fmt.Errorf("lookup %q: %w", s, err)
+	}
+	lookup := resolve.Lookup{Identifier: identifiers[0]}
+	if dated {
+		d, err := parseFixtureDate(date)
+		if err != nil {
+			return resolve.Lookup{}, fmt.Errorf("lookup %q: %w", s, err)
+		}
+		lookup.Date = &d
+	}
+	return lookup, nil
+}
+
+// RunFixture writes the fixture's entities to the store, then checks the
+// results of each lookup.
+func RunFixture(t *testing.T, store Store, f *Fixture) {
+	ctx := context.Background()
+	require.NoError(t, store.CreateEntities(ctx, f.Entities))
+
+	for _, fl := range f.Lookups {
+		got, err := store.LookupEntities(ctx, []resolve.Lookup{fl.Lookup})
+		require.NoError(t, err)
+		f.check(t, fl, got)
+	}
+}
+
+// check compares the results with the expected results, by entity and then by
+// the details that are expected.
+func (f *Fixture) check(t *testing.T, fl FixtureLookup, got []resolve.LookupResult) {
+	t.Helper()
+	msg := "lookup " + DescribeLookup(fl.Lookup)
+
+	byEntity := map[string][]*resolve.Entity{}
+	for _, r := range got {
+		if !r.Success || r.Entity == nil {
+			continue
+		}
+		id, ok := f.ids[r.Entity.ID]
+		if !ok {
+			id = r.Entity.ID.String()
+		}
+		byEntity[id] = append(byEntity[id], r.Entity)
+	}
+
+	var want, found []string
+	for _, fr := range fl.Expect {
+		want = append(want, fr.Entity)
+	}
+	for id := range byEntity {
+		found = append(found, id)
+	}
+	require.ElementsMatch(t, dedupe(want), found, msg)
+
+	// when every result of an entity names its name, the names must match
+	names := map[string][]string{}
+	for _, fr := range fl.Expect {
+		if fr.Name != nil {
+			names[fr.Entity] = append(names[fr.Entity], *fr.Name)
+		}
+	}
+	for id, entities := range byEntity {
+		expected := 0
+		for _, fr := range fl.Expect {
+			if fr.Entity == id {
+				expected++
+			}
+		}
+		if len(names[id]) == expected {
+			var gotNames []string
+			for _, e := range entities {
+				gotNames = append(gotNames, entityName(e))
+			}
+			require.ElementsMatch(t, names[id], gotNames, "%s: names of %s", msg, id)
+		}
+	}
+
+	for _, fr := range fl.Expect {
+		for _, e := range byEntity[fr.Entity] {
+			if fr.Name != nil && entityName(e) != *fr.Name {
+				continue
+			}
+			if fr.Identifiers != nil {
+				var identifiers []resolve.Identifier
+				for _, idnd := range e.Identifiers {
+					identifiers = append(identifiers, idnd.Detail...)
+				}
+				require.ElementsMatch(t, fr.Identifiers, identifiers, "%s: identifiers of %s", msg, fr.Entity)
+			}
+			if fr.Securities != nil {
+				securities := []string{}
+				for _, sd := range e.Securities {
+					for _, sec := range sd.Detail {
+						securities = append(securities, sec.Name)
+					}
+				}
+				require.ElementsMatch(t, fr.Securities, securities, "%s: securities of %s", msg, fr.Entity)
+			}
+			if fr.Primary != nil {
+				primary := ""
+				for _, sd := range e.Securities {
+					for _, sec := range sd.Detail {
+						if sec.IsPrimary {
+							primary = sec.Name
+						}
+					}
+				}
+				require.Equal(t, *fr.Primary, primary, "%s: primary security of %s", msg, fr.Entity)
+			}
+		}
+	}
+}
+
+func entityName(e *resolve.Entity) string {
+	if len(e.Name) == 0 {
+		return ""
+	}
+	return e.Name[0].Detail.Value
+}
+
+func dedupe(values []string) []string {
+	seen := map[string]bool{}
+	var deduped []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			deduped = append(deduped, v)
+		}
+	}
+	sort.Strings(deduped)
+	return deduped
+}
@@ -0,0 +1,97 @@
+package resolvetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/stretchr/testify/require"
+)
+
+// oracleStore resolves lookups with ExpectedResults, undated lookups at now.
+type oracleStore struct {
+	entities []*resolve.Entity
+	now      time.Time
+}
+
+func (s *oracleStore) CreateEntities(_ context.Context, entities []*resolve.Entity) error {
+	s.entities = append(s.entities, entities...)
+	return nil
+}
+
+func (s *oracleStore) LookupEntities(_ context.Context, lookups []resolve.Lookup) ([]resolve.LookupResult, error) {
+	var results []resolve.LookupResult
+	for _, lookup := range lookups {
+		results = append(results, ExpectedResults(s.entities, lookup, s.now)...)
+	}
+	return results, nil
+}
+
+// The fixtures agree with the results expected of stores.
+func TestFixtures(t *testing.T) {
+	fixtures, err := Fixtures()
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	for _, f := range fixtures {
+		f := f
+		t.Run(f.Name, func(t *testing.T) {
+			RunFixture(t, &oracleStore{now: DefaultNow}, f)
+		})
+	}
+}
+
+func TestParseFixture(t *testing.T) {
+	f, err := ParseFixture([]byte(`{
+		"name": "parse",
+		"entities": [
+			{
+				"id": "a",
+				"names": [{"value": "Acme Ltd", "from": "2010-01-01", "until": "2020-06-01T12:00:00Z"}],
+				"identifiers": [{"ids": ["sray_entity_id:1", "isin:US:1"], "from": "2010-01-01"}]
+			},
+			{"id": "00000000-0000-4000-8000-000000000001"}
+		],
+		"lookups": [
+			{"lookup": "sray_entity_id:1@2015-01-01", "expect": [{"entity": "a", "name": "Acme Ltd"}]},
+			{"lookup": "sray_entity_id:1", "expect": []}
+		]
+	}`))
+	require.NoError(t, err)
+
+	require.Len(t, f.Entities, 2)
+	a := f.Entities[0]
+	require.Equal(t, "a", f.ids[a.ID])
+	require.Equal(t, time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC), a.Name[0].Duration.StartDate)
+	require.Equal(t, time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC), *a.Name[0].Duration.EndDate)
+	require.Equal(t, []resolve.Identifier{{Type: "sray_entity_id", Value: "1"}, {Type: "isin", Value: "US:1"}}, a.Identifiers[0].Detail)
+	require.Equal(t, "00000000-0000-4000-8000-000000000001", f.Entities[1].ID.String())
+
+	// ids are stable
+	again, err := ParseFixture([]byte(`{"name": "parse", "entities": [{"id": "a"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, a.ID, again.Entities[0].ID)
+
+	require.Len(t, f.Lookups, 2)
+	require.Equal(t, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), *f.Lookups[0].Lookup.Date)
+	require.Equal(t, "Acme Ltd", *f.Lookups[0].Expect[0].Name)
+	require.Nil(t, f.Lookups[1].Lookup.Date)
+	require.Empty(t, f.Lookups[1].Expect)
+}
+
+func TestParseFixture_Errors(t *testing.T) {
+	for fixture, msg := range map[string]string{
+		`{"entities": [{"id": "a", "names": [{"value": "A", "from": "2010"}]}]}`:                                 `date "2010"`,
+		`{"entities": [{"id": "a", "names": [{"value": "A", "from": "2010-01-01", "until": "2010-01-01"}]}]}`:    "is not after",
+		`{"entities": [{"id": "a", "identifiers": [{"ids": ["isin"], "from": "2010-01-01"}]}]}`:                  "is not type:value",
+		`{"entities": [{"id": "a"}, {"id": "a"}]}`:                                                               "duplicate id",
+		`{"entities": [{"id": "a"}], "lookups": [{"lookup": "isin:1@2020-01-01", "expect": [{"entity": "b"}]}]}`: `unknown entity "b"`,
+		`{"lookups": [{"lookup": "isin:1@yesterday"}]}`:                                                          `date "yesterday"`,
+		`{"name": 1}`: "parse fixture",
+	} {
+		_, err := ParseFixture([]byte(fixture))
+		require.ErrorContains(t, err, msg, fixture)
+	}
+}
@@ -0,0 +1,294 @@
+package resolvetest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"neo4j-starter/resolve"
+)
+
+// identifierTypes are the types of identifier DataGen generates.
+var identifierTypes = []resolve.IdentifierType{
+	"resolve_id",
+	"sray_entity_id",
+	"fs_entity_id",
+	"asset_id",
+	"isin",
+	"cusip",
+}
+
+// LookupConfig configures NewLookupsWith.
+type LookupConfig struct {
+	// Types weights the identifier types of lookups. Defaults to every type
+	// DataGen generates, weighted equally.
+	Types map[resolve.IdentifierType]float64
+	// Unknown is the share of lookups of identifiers that were never
+	// generated, between 0 and 1.
+	Unknown float64
+	// Dates weights when lookups are dated relative to when the identifier is
+	// held. Defaults to all lookups dated inside.
+	Dates DateMix
+	// Duplicates is the share of lookups repeating an earlier lookup, between
+	// 0 and 1.
+	Duplicates float64
+}
+
+// DateMix weights lookup dates relative to a validity window of the
+// identifier. An identifier held by several entities, or dropped and added
+// again, has several windows, and a date before or after one of them may be
+// inside another.
+type DateMix struct {
+	// Inside dates are within the window.
+	Inside float64
+	// Before dates are up to a year before the window starts.
+	Before float64
+	// After dates are up to a year after the window ends, or inside the
+	// window if it hasn't ended.
+	After float64
+	// Undated lookups have no date, so resolve at the current time.
+	Undated float64
+}
+
+// kinds of lookup date, in the order of their DateMix weights
+const (
+	dateInside = iota
+	dateBefore
+	dateAfter
+	dateUndated
+)
+
+func (c *LookupConfig) setDefaults() {
+	var types float64
+	for _, w := range c.Types {
+		if w > 0 {
+			types += w
+		}
+	}
+	if types == 0 {
+		c.Types = make(map[resolve.IdentifierType]float64, len(identifierTypes))
+		for _, typ := range identifierTypes {
+			c.Types[typ] = 1
+		}
+	}
+	if c.Dates.Inside <= 0 && c.Dates.Before <= 0 && c.Dates.After <= 0 && c.Dates.Undated <= 0 {
+		c.Dates = DateMix{Inside: 1}
+	}
+}
+
+// heldIdentifier is a generated identifier with the durations entities or
+// their securities held it.
+type heldIdentifier struct {
+	identifier resolve.Identifier
+	windows    []resolve.Duration
+}
+
+// indexIdentifiers adds the identifiers of entities generated since the last
+// call to the index used to generate lookups.
+func (g *DataGen) indexIdentifiers() {
+	if g.held == nil {
+		g.held = map[resolve.Identifier]*heldIdentifier{}
+		g.heldByType = map[resolve.IdentifierType][]*heldIdentifier{}
+	}
+
+	add := func(idn resolve.Identifier, d resolve.Duration) {
+		// identifiers dropped the day they were added are never held
+		if d.EndDate != nil && !d.EndDate.After(d.StartDate) {
+			return
+		}
+		h := g.held[idn]
+		if h == nil {
+			h = &heldIdentifier{identifier: idn}
+			g.held[idn] = h
+			g.heldByType[idn.Type] = append(g.heldByType[idn.Type], h)
+		}
+		h.windows = append(h.windows, d)
+	}
+
+	for _, e := range g.entities[g.indexed:] {
+		for _, idnd := range e.Identifiers {
+			for _, idn := range idnd.Detail {
+				add(idn, idnd.Duration)
+			}
+		}
+		for _, sd := range e.Securities {
+			for _, sec := range sd.Detail {
+				for _, idn := range sec.Identifiers {
+					add(idn, sd.Duration)
+				}
+			}
+		}
+	}
+	g.indexed = len(g.entities)
+}
+
+// NewLookupsWith generates lookups of the identifiers of the entities generated
+// so far, in the mix of identifier types, dates, unknown identifiers and
+// duplicates set by cfg. Without generated entities, every lookup is of an
+// unknown identifier.
+func (g *DataGen) NewLookupsWith(n int, cfg LookupConfig) []resolve.Lookup {
+	cfg.setDefaults()
+	g.indexIdentifiers()
+
+	// sort the types so the lookups only depend on the seed
+	types := make([]resolve.IdentifierType, 0, len(cfg.Types))
+	for typ := range cfg.Types {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	allWeights := make([]float64, len(types))
+	knownWeights := make([]float64, len(types))
+	for i, typ := range types {
+		if w := cfg.Types[typ]; w > 0 {
+			allWeights[i] = w
+			if len(g.heldByType[typ]) > 0 {
+				knownWeights[i] = w
+			}
+		}
+	}
+	dateWeights := []float64{cfg.Dates.Inside, cfg.Dates.Before, cfg.Dates.After, cfg.Dates.Undated}
+
+	lookups := make([]resolve.Lookup, 0, n)
+	for len(lookups) < n {
+		if len(lookups) > 0 && g.Float64Range(0, 1) < cfg.Duplicates {
+			lookups = append(lookups, copyLookup(lookups[g.IntRange(0, len(lookups)-1)]))
+			continue
+		}
+
+		dating := g.pick(dateWeights)
+
+		known := g.pick(knownWeights)
+		if known < 0 || g.Float64Range(0, 1) < cfg.Unknown {
+			typ := types[g.pick(allWeights)]
+			lookups = append(lookups, resolve.Lookup{
+				Date:       g.unknownLookupDate(dating),
+				Identifier: g.unknownIdentifier(typ),
+			})
+			continue
+		}
+
+		held := g.heldByType[types[known]]
+		h := held[g.IntRange(0, len(held)-1)]
+		lookups = append(lookups, resolve.Lookup{
+			Date:       g.lookupDate(h.windows[g.IntRange(0, len(h.windows)-1)], dating),
+			Identifier: h.identifier,
+		})
+	}
+	return lookups
+}
+
+// NewExpectedLookupsWith generates lookups as NewLookupsWith, each with the
+// results expected from a store holding the entities generated so far by g.
+func (g *DataGen) NewExpectedLookupsWith(n int, cfg LookupConfig) []ExpectedLookup {
+	return g.expectLookups(g.NewLookupsWith(n, cfg))
+}
+
+// expectLookups returns the lookups with their expected results. Undated
+// lookups resolve at g.Now, when histories end, so the results are those of a
+// store resolving them at any time since.
+func (g *DataGen) expectLookups(lookups []resolve.Lookup) []ExpectedLookup {
+	oracle := NewOracle(g.entities, g.now)
+	expected := make([]ExpectedLookup, 0, len(lookups))
+	for _, lookup := range lookups {
+		expected = append(expected, ExpectedLookup{
+			Lookup:   lookup,
+			Expected: oracle.Expect(lookup),
+		})
+	}
+	return expected
+}
+
+// pick returns the index of a weighted choice, or -1 if no weight is positive.
+func (g *DataGen) pick(weights []float64) int {
+	var total float64
+	for _, w := range weights {
+		if w > 0 {
+			total += w
+		}
+	}
+	if total == 0 {
+		return -1
+	}
+
+	f := g.Float64Range(0, total)
+	last := -1
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		if f < w {
+			return i
+		}
+		f -= w
+		last = i
+	}
+	// rounding left f past the last weight
+	return last
+}
+
+// lookupDate returns a date relative to the window as picked from DateMix.
+func (g *DataGen) lookupDate(window resolve.Duration, dating int) *time.Time {
+	var date time.Time
+	switch {
+	case dating == dateUndated:
+		return nil
+	case dating == dateBefore:
+		date = window.StartDate.AddDate(0, 0, -g.IntRange(1, 365))
+	case dating == dateAfter && window.EndDate != nil:
+		// the end isn't part of the window
+		date = window.EndDate.AddDate(0, 0, g.IntRange(0, 365))
+	default:
+		end := g.now
+		if window.EndDate != nil {
+			end = *window.EndDate
+		}
+		date = window.StartDate
+		if end.After(date) {
+			date = g.DateRange(window.StartDate, end).Truncate(24 * time.Hour)
+		}
+	}
+	return &date
+}
+
+// unknownLookupDate returns a date within the span of generated entities,
+// unless the lookup is undated.
+func (g *DataGen) unknownLookupDate(dating int) *time.Time {
+	if dating == dateUndated {
+		return nil
+	}
+	date := g.DateRange(g.now.AddDate(-g.historyYears, 0, 0), g.now).Truncate(24 * time.Hour)
+	return &date
+}
+
+// unknownIdentifier returns an identifier of the type that wasn't generated,
+// in the format of the type.
+func (g *DataGen) unknownIdentifier(typ resolve.IdentifierType) resolve.Identifier {
+	for {
+		idn := resolve.Identifier{Type: typ}
+		switch typ {
+		case "resolve_id":
+			idn.Value = g.UUID().String()
+		case "fs_entity_id":
+			idn.Value = fsEntityID(fmt.Sprint(g.IntRange(1_000_000, 9_999_999)))
+		case "isin":
+			idn.Value = g.isin()
+		case "cusip":
+			idn.Value = g.Cusip()
+		default:
+			// beyond the counters of sray_entity_id and asset_id
+			idn.Value = fmt.Sprint(g.IntRange(1_000_000_000, 1_999_999_999))
+		}
+		if _, ok := g.held[idn]; !ok {
+			return idn
+		}
+	}
+}
+
+func copyLookup(lookup resolve.Lookup) resolve.Lookup {
+	if lookup.Date != nil {
+		date := *lookup.Date
+		lookup.Date = &date
+	}
+	return lookup
+}
@@ -0,0 +1,94 @@
+package resolvetest
+
+import (
+	"testing"
+
+	"neo4j-starter/resolve"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataGen_NewLookupsWith(t *testing.T) {
+	gen := NewDataGen(1)
+	gen.NewEntities(200)
+
+	lookups := gen.NewLookupsWith(1000, LookupConfig{})
+	require.Len(t, lookups, 1000)
+
+	// every generated type is looked up, and every identifier was generated
+	// and is held at the date
+	types := map[resolve.IdentifierType]int{}
+	for _, lookup := range lookups {
+		types[lookup.Identifier.Type]++
+		require.NotNil(t, lookup.Date)
+		require.Contains(t, gen.held, lookup.Identifier)
+
+		var inside bool
+		for _, w := range gen.held[lookup.Identifier].windows {
+			inside = inside || w.Contains(*lookup.Date)
+		}
+		require.True(t, inside, "%s is not held at the date", DescribeLookup(lookup))
+	}
+	require.Len(t, types, len(identifierTypes))
+}
+
+func TestDataGen_NewLookupsWith_Mix(t *testing.T) {
+	gen := NewDataGen(1)
+	gen.NewEntities(200)
+
+	lookups := gen.NewLookupsWith(2000, LookupConfig{
+		Types:      map[resolve.IdentifierType]float64{"isin": 3, "cusip": 1},
+		Unknown:    0.2,
+		Dates:      DateMix{Inside: 1, Undated: 1},
+		Duplicates: 0.1,
+	})
+
+	types := map[resolve.IdentifierType]int{}
+	var unknown, undated, duplicates int
+	seen := map[string]bool{}
+	for _, lookup := range lookups {
+		types[lookup.Identifier.Type]++
+		if _, ok := gen.held[lookup.Identifier]; !ok {
+			unknown++
+		}
+		if lookup.Date == nil {
+			undated++
+		}
+		if key := DescribeLookup(lookup); seen[key] {
+			duplicates++
+		} else {
+			seen[key] = true
+		}
+	}
+
+	require.Len(t, types, 2)
+	require.InDelta(t, 1500, types["isin"], 150)
+	require.InDelta(t, 400, unknown, 80)
+	require.InDelta(t, 1000, undated, 150)
+	// undated lookups of the same identifier are duplicates as well
+	require.GreaterOrEqual(t, duplicates, 150)
+}
+
+func TestDataGen_NewLookupsWith_Before(t *testing.T) {
+	gen := NewDataGen(1)
+	gen.NewEntities(200)
+
+	// sray entity ids are held by a single entity for a single window, so
+	// aren't held before it
+	expected := gen.NewExpectedLookupsWith(200, LookupConfig{
+		Types: map[resolve.IdentifierType]float64{"sray_entity_id": 1},
+		Dates: DateMix{Before: 1},
+	})
+	for _, el := range expected {
+		require.Equal(t, []resolve.LookupResult{{Success: false}}, el.Expected, DescribeLookup(el.Lookup))
+	}
+}
+
+func TestDataGen_NewLookupsWith_NoEntities(t *testing.T) {
+	gen := NewDataGen(1)
+
+	expected := gen.NewExpectedLookupsWith(50, LookupConfig{})
+	for _, el := range expected {
+		require.Equal(t, []resolve.LookupResult{{Success: false}}, el.Expected)
+	}
+}
@@ -0,0 +1,101 @@
+package resolvetest
+
+import (
+	"time"
+
+	"neo4j-starter/resolve"
+)
+
+// ExpectedResults returns the results a store should return for the lookup,
+// computed from the entities in the store as of the lookup date, or now for an
+// undated lookup, which must be the time the store resolves undated lookups at:
+//
+//   - an entity matches if it, or one of its securities, has the identifier
+//   - each match is a result with the entity's name, identifiers and securities
+//     at the date, there is a result per name if names overlap
+//   - securities without identifiers are not returned
+//   - a lookup without matches has a single unsuccessful result
+func ExpectedResults(entities []*resolve.Entity, lookup resolve.Lookup, now time.Time) []resolve.LookupResult {
+	date := now.UTC()
+	if lookup.Date != nil {
+		date = *lookup.Date
+	}
+
+	var results []resolve.LookupResult
+	for _, e := range entities {
+		at := e.AsOf(date)
+		if !hasIdentifier(at, lookup.Identifier) {
+			continue
+		}
+
+		var identifiers []resolve.Identifier
+		seen := map[resolve.Identifier]bool{}
+		for _, idnd := range at.Identifiers {
+			for _, idn := range idnd.Detail {
+				if !seen[idn] {
+					seen[idn] = true
+					identifiers = append(identifiers, idn)
+				}
+			}
+		}
+
+		var securities []resolve.Security
+		for _, sd := range at.Securities {
+			for _, sec := range sd.Detail {
+				if len(sec.Identifiers) > 0 {
+					securities = append(securities, resolve.Security{Name: sec.Name, IsPrimary: sec.IsPrimary})
+				}
+			}
+		}
+
+		result := func(name *resolve.EntityName) resolve.LookupResult {
+			entity := &resolve.Entity{
+				ID:          e.ID,
+				Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{{Detail: identifiers}},
+				Securities:  []resolve.DetailDuration[[]resolve.Security]{{Detail: securities}},
+			}
+			if name != nil {
+				entity.Name = []resolve.DetailDuration[resolve.EntityName]{{Detail: *name}}
+			}
+			return resolve.LookupResult{Success: true, Entity: entity}
+		}
+		if len(at.Name) == 0 {
+			results = append(results, result(nil))
+		}
+		for i := range at.Name {
+			results = append(results, result(&at.Name[i].Detail))
+		}
+	}
+
+	if len(results) == 0 {
+		return []resolve.LookupResult{{Success: false}}
+	}
+	return results
+}
+
+// hasIdentifier reports whether the entity or one of its securities has the
+// identifier.
+func hasIdentifier(e *resolve.Entity, idn resolve.Identifier) bool {
+	for _, idnd := range e.Identifiers {
+		if contains(idnd.Detail, idn) {
+			return true
+		}
+	}
+	for _, sd := range e.Securities {
+		for _, sec := range sd.Detail {
+			if contains(sec.Identifiers, idn) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(identifiers []resolve.Identifier, idn resolve.Identifier) bool {
+	for _, i := range identifiers {
+		if i == idn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,444 @@
+package resolvetest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// propertyEpoch is the first day of generated histories, which all end well
+// before DefaultNow, so undated lookups see the open durations.
+var propertyEpoch = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// PropertyConfig configures CheckProperties.
+type PropertyConfig struct {
+	// Cases is the number of random cases checked, 100 by default.
+	Cases int
+	// Seed seeds the first case, and each case after it the next seed, 1 by
+	// default. A counterexample is reproduced by its seed with a single case.
+	Seed int64
+	// MaxEntities is the most entities in a case, 3 by default.
+	MaxEntities int
+	// MaxLookups is the most lookups in a case, 20 by default.
+	MaxLookups int
+	// Now is the time stores resolve undated lookups at, DefaultNow by default.
+	Now time.Time
+}
+
+func (c *PropertyConfig) setDefaults() {
+	if c.Cases <= 0 {
+		c.Cases = 100
+	}
+	if c.Seed == 0 {
+		c.Seed = 1
+	}
+	if c.MaxEntities <= 0 {
+		c.MaxEntities = 3
+	}
+	if c.MaxLookups <= 0 {
+		c.MaxLookups = 20
+	}
+	if c.Now.IsZero() {
+		c.Now = DefaultNow
+	}
+}
+
+// Counterexample is a case a store resolved wrongly, shrunk to the fewest
+// entities and details which still fail the lookup.
+type Counterexample struct {
+	// Seed is the seed of the case before it was shrunk.
+	Seed      int64
+	Entities  []*resolve.Entity
+	Lookup    resolve.Lookup
+	Violation string
+	// Now is the time the store resolved undated lookups at.
+	Now time.Time
+}
+
+// Fixture returns the counterexample as a JSON fixture, with the results
+// expected of the lookup, which can be added to fixtures/ as a regression
+// test. Fixtures are run at DefaultNow, so an undated lookup resolved at
+// another time is written dated at it.
+func (c *Counterexample) Fixture() ([]byte, error) {
+	ff := fixtureFile{Name: fmt.Sprintf("property seed %d", c.Seed)}
+	for _, e := range c.Entities {
+		ff.Entities = append(ff.Entities, toFixtureEntity(e))
+	}
+
+	lookup := c.Lookup
+	if lookup.Date == nil && !c.Now.Equal(DefaultNow) {
+		now := c.Now
+		lookup.Date = &now
+	}
+	fl := fixtureLookup{Lookup: formatLookup(lookup), Expect: []fixtureResult{}}
+	for _, r := range ExpectedResults(c.Entities, lookup, c.Now) {
+		if r.Entity == nil {
+			continue
+		}
+		name := entityName(r.Entity)
+		fl.Expect = append(fl.Expect, fixtureResult{Entity: r.Entity.ID.String(), Name: &name})
+	}
+	ff.Lookups = []fixtureLookup{fl}
+
+	data, err := json.MarshalIndent(ff, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("marshal fixture: %w", err)
+	}
+	return data, nil
+}
+
+// String describes the violation, followed by the fixture.
+func (c *Counterexample) String() string {
+	data, err := c.Fixture()
+	if err != nil {
+		return fmt.Sprintf("seed %d: lookup %s: %s: %v", c.Seed, DescribeLookup(c.Lookup), c.Violation, err)
+	}
+	return fmt.Sprintf("seed %d: lookup %s: %s\n%s", c.Seed, DescribeLookup(c.Lookup), c.Violation, data)
+}
+
+// CheckProperties checks that a store resolves lookups of random entity
+// histories by the temporal semantics of resolve.Lookup:
+//
+//   - there is at most one result per entity, with at most one name, as names
+//     don't overlap
+//   - the results are those of the entities as of the lookup date, with
+//     durations including their start and excluding their end, which
+//     ExpectedResults computes with resolve.Entity.AsOf
+//   - an undated lookup has the results of a lookup dated at cfg.Now
+//
+// Lookups are dated on and either side of the days details start and end.
+// newStore must return an empty store resolving undated lookups at now, and is
+// called for each case and each step of shrinking a failing case. The first
+// failing case is returned as a counterexample, or nil if every case passes.
+// The error is for a store failing to create entities or look them up.
+func CheckProperties(ctx context.Context, newStore func(now time.Time) (Store, error), cfg PropertyConfig) (*Counterexample, error) {
+	cfg.setDefaults()
+
+	for i := 0; i < cfg.Cases; i++ {
+		seed := cfg.Seed + int64(i)
+		entities, lookups := newPropertyCase(seed, cfg)
+
+		lookup, violation, err := checkCase(ctx, newStore, cfg.Now, entities, lookups)
+		if err != nil {
+			return nil, fmt.Errorf("seed %d: %w", seed, err)
+		}
+		if violation == "" {
+			continue
+		}
+
+		entities, violation, err = shrink(ctx, newStore, cfg.Now, entities, lookup, violation)
+		if err != nil {
+			return nil, fmt.Errorf("seed %d: shrink: %w", seed, err)
+		}
+		return &Counterexample{Seed: seed, Entities: entities, Lookup: lookup, Violation: violation, Now: cfg.Now}, nil
+	}
+	return nil, nil
+}
+
+// RunProperties runs CheckProperties, failing the test with the counterexample.
+func RunProperties(t *testing.T, newStore func(t *testing.T, now time.Time) Store, cfg PropertyConfig) {
+	c, err := CheckProperties(context.Background(), func(now time.Time) (Store, error) {
+		return newStore(t, now), nil
+	}, cfg)
+	require.NoError(t, err)
+	if c != nil {
+		t.Fatalf("counterexample: %s", c)
+	}
+}
+
+// checkCase looks up each lookup in a new store with the entities, returning
+// the first lookup that violates a property and the violation.
+func checkCase(ctx context.Context, newStore func(now time.Time) (Store, error), now time.Time, entities []*resolve.Entity, lookups []resolve.Lookup) (resolve.Lookup, string, error) {
+	store, err := newStore(now)
+	if err != nil {
+		return resolve.Lookup{}, "", fmt.Errorf("new store: %w", err)
+	}
+	if err := store.CreateEntities(ctx, entities); err != nil {
+		return resolve.Lookup{}, "", fmt.Errorf("create entities: %w", err)
+	}
+
+	for _, lookup := range lookups {
+		violation, err := checkLookup(ctx, store, now, entities, lookup)
+		if err != nil || violation != "" {
+			return lookup, violation, err
+		}
+	}
+	return resolve.Lookup{}, "", nil
+}
+
+// checkLookup returns the property the store's results for the lookup violate,
+// or "" if there is none. The store resolves undated lookups at now.
+func checkLookup(ctx context.Context, store Store, now time.Time, entities []*resolve.Entity, lookup resolve.Lookup) (string, error) {
+	got, err := store.LookupEntities(ctx, []resolve.Lookup{lookup})
+	if err != nil {
+		return "", fmt.Errorf("lookup %s: %w", DescribeLookup(lookup), err)
+	}
+
+	seen := map[uuid.UUID]bool{}
+	for _, r := range got {
+		if r.Entity == nil {
+			continue
+		}
+		if len(r.Entity.Name) > 1 {
+			return fmt.Sprintf("entity %s has %d names", r.Entity.ID, len(r.Entity.Name)), nil
+		}
+		if seen[r.Entity.ID] {
+			return fmt.Sprintf("entity %s has more than one result", r.Entity.ID), nil
+		}
+		seen[r.Entity.ID] = true
+	}
+
+	want := ExpectedResults(entities, lookup, now)
+	if !reflect.DeepEqual(CanonicalResults(want), CanonicalResults(got)) {
+		return fmt.Sprintf("got %s, want %s", DescribeResults(got), DescribeResults(want)), nil
+	}
+
+	if lookup.Date == nil {
+		now := now.UTC()
+		dated, err := store.LookupEntities(ctx, []resolve.Lookup{{Date: &now, Identifier: lookup.Identifier}})
+		if err != nil {
+			return "", fmt.Errorf("lookup %s: %w", DescribeLookup(lookup), err)
+		}
+		if !reflect.DeepEqual(CanonicalResults(dated), CanonicalResults(got)) {
+			return fmt.Sprintf("got %s undated, but %s at %s", DescribeResults(got), DescribeResults(dated), now.Format(time.RFC3339)), nil
+		}
+	}
+	return "", nil
+}
+
+// shrink removes entities and details from a failing case for as long as the
+// lookup still fails, returning the smallest case found and its violation.
+func shrink(ctx context.Context, newStore func(now time.Time) (Store, error), now time.Time, entities []*resolve.Entity, lookup resolve.Lookup, violation string) ([]*resolve.Entity, string, error) {
+	for shrunk := true; shrunk; {
+		shrunk = false
+		for _, candidate := range shrinkCandidates(entities) {
+			_, v, err := checkCase(ctx, newStore, now, candidate, []resolve.Lookup{lookup})
+			if err != nil {
+				return nil, "", err
+			}
+			if v != "" {
+				entities, violation, shrunk = candidate, v, true
+				break
+			}
+		}
+	}
+	return entities, violation, nil
+}
+
+// shrinkCandidates returns the cases with one entity, duration, identifier or
+// security fewer, largest removals first. Removing details keeps histories
+// valid, as durations only get further apart.
+func shrinkCandidates(entities []*resolve.Entity) [][]*resolve.Entity {
+	var candidates [][]*resolve.Entity
+	with := func(i int, e resolve.Entity) {
+		candidate := append([]*resolve.Entity(nil), entities...)
+		candidate[i] = &e
+		candidates = append(candidates, candidate)
+	}
+
+	for i := range entities {
+		candidates = append(candidates, without(entities, i))
+	}
+	for i, e := range entities {
+		for j := range e.Name {
+			c := *e
+			c.Name = without(e.Name, j)
+			with(i, c)
+		}
+		for j := range e.Country {
+			c := *e
+			c.Country = without(e.Country, j)
+			with(i, c)
+		}
+		for j, idnd := range e.Identifiers {
+			c := *e
+			c.Identifiers = without(e.Identifiers, j)
+			with(i, c)
+
+			for k := range idnd.Detail {
+				c := *e
+				c.Identifiers = append([]resolve.DetailDuration[[]resolve.Identifier](nil), e.Identifiers...)
+				c.Identifiers[j].Detail = without(idnd.Detail, k)
+				with(i, c)
+			}
+		}
+		for j, sd := range e.Securities {
+			c := *e
+			c.Securities = without(e.Securities, j)
+			with(i, c)
+
+			for k, sec := range sd.Detail {
+				c := *e
+				c.Securities = append([]resolve.DetailDuration[[]resolve.Security](nil), e.Securities...)
+				c.Securities[j].Detail = without(sd.Detail, k)
+				with(i, c)
+
+				for l := range sec.Identifiers {
+					c := *e
+					c.Securities = append([]resolve.DetailDuration[[]resolve.Security](nil), e.Securities...)
+					c.Securities[j].Detail = append([]resolve.Security(nil), sd.Detail...)
+					c.Securities[j].Detail[k].Identifiers = without(sec.Identifiers, l)
+					with(i, c)
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+// without returns a copy of the slice without the element at i.
+func without[T any](s []T, i int) []T {
+	w := make([]T, 0, len(s)-1)
+	w = append(w, s[:i]...)
+	return append(w, s[i+1:]...)
+}
+
+// newPropertyCase generates a few entities with short histories on a small
+// grid of days, sharing a small pool of identifiers, and lookups of those
+// identifiers, or an unknown one, around the days the details change.
+func newPropertyCase(seed int64, cfg PropertyConfig) ([]*resolve.Entity, []resolve.Lookup) {
+	rng := rand.New(rand.NewSource(seed))
+	var used []resolve.Identifier
+	pool := func(typ resolve.IdentifierType, n int) resolve.Identifier {
+		idn := resolve.Identifier{Type: typ, Value: fmt.Sprint(rng.Intn(n) + 1)}
+		used = append(used, idn)
+		return idn
+	}
+
+	var boundaries []time.Time
+	durations := func() []resolve.Duration {
+		var ds []resolve.Duration
+		day := rng.Intn(5)
+		for n := rng.Intn(4); n > 0; n-- {
+			d := resolve.Duration{StartDate: propertyEpoch.AddDate(0, 0, day)}
+			boundaries = append(boundaries, d.StartDate)
+			day += 1 + rng.Intn(5)
+			if n > 1 || rng.Intn(2) == 0 {
+				until := propertyEpoch.AddDate(0, 0, day)
+				d.EndDate = &until
+				boundaries = append(boundaries, until)
+				// the next duration starts on the same day, or after a gap
+				day += rng.Intn(2) * rng.Intn(3)
+			}
+			ds = append(ds, d)
+			if d.EndDate == nil {
+				break
+			}
+		}
+		return ds
+	}
+
+	entities := make([]*resolve.Entity, 1+rng.Intn(cfg.MaxEntities))
+	for i := range entities {
+		e := &resolve.Entity{ID: uuid.NewV5(uuid.NamespaceOID, fmt.Sprintf("neo4j-starter/properties/%d/%d", seed, i))}
+		for j, d := range durations() {
+			e.Name = append(e.Name, resolve.DetailDuration[resolve.EntityName]{
+				Detail:   resolve.EntityName{Value: fmt.Sprintf("Entity %d.%d", i, j)},
+				Duration: d,
+			})
+		}
+		for _, d := range durations() {
+			identifiers := []resolve.Identifier{pool("sray_entity_id", 3)}
+			if rng.Intn(2) == 0 {
+				identifiers = append(identifiers, pool("asset_id", 3))
+			}
+			e.Identifiers = append(e.Identifiers, resolve.DetailDuration[[]resolve.Identifier]{Detail: identifiers, Duration: d})
+		}
+		for j, d := range durations() {
+			securities := make([]resolve.Security, 1+rng.Intn(2))
+			for k := range securities {
+				securities[k] = resolve.Security{
+					Name:      fmt.Sprintf("Security %d.%d.%d", i, j, k),
+					IsPrimary: k == 0,
+				}
+				// securities without identifiers aren't returned
+				if rng.Intn(4) > 0 {
+					securities[k].Identifiers = []resolve.Identifier{pool("isin", 3)}
+				}
+			}
+			e.Securities = append(e.Securities, resolve.DetailDuration[[]resolve.Security]{Detail: securities, Duration: d})
+		}
+		entities[i] = e
+	}
+	if len(boundaries) == 0 {
+		boundaries = append(boundaries, propertyEpoch)
+	}
+
+	lookups := make([]resolve.Lookup, 1+rng.Intn(cfg.MaxLookups))
+	for i := range lookups {
+		idn := resolve.Identifier{Type: "sray_entity_id", Value: "missing"}
+		if len(used) > 0 && rng.Intn(5) > 0 {
+			idn = used[rng.Intn(len(used))]
+		}
+		lookups[i].Identifier = idn
+
+		if rng.Intn(5) == 0 {
+			continue
+		}
+		date := boundaries[rng.Intn(len(boundaries))].Add(time.Duration(rng.Intn(3)-1) * time.Second)
+		lookups[i].Date = &date
+	}
+	return entities, lookups
+}
+
+func toFixtureEntity(e *resolve.Entity) fixtureEntity {
+	fe := fixtureEntity{ID: e.ID.String()}
+	for _, nd := range e.Name {
+		fe.Names = append(fe.Names, fixtureValue{fixtureDuration: toFixtureDuration(nd.Duration), Value: nd.Detail.Value})
+	}
+	for _, cd := range e.Country {
+		fe.Countries = append(fe.Countries, fixtureValue{fixtureDuration: toFixtureDuration(cd.Duration), Value: cd.Detail.Value})
+	}
+	for _, idnd := range e.Identifiers {
+		fe.Identifiers = append(fe.Identifiers, fixtureIdentifiers{fixtureDuration: toFixtureDuration(idnd.Duration), IDs: formatIdentifiers(idnd.Detail)})
+	}
+	for _, sd := range e.Securities {
+		fs := fixtureSecurities{fixtureDuration: toFixtureDuration(sd.Duration)}
+		for _, sec := range sd.Detail {
+			fs.Securities = append(fs.Securities, fixtureSecurity{Name: sec.Name, Primary: sec.IsPrimary, IDs: formatIdentifiers(sec.Identifiers)})
+		}
+		fe.Securities = append(fe.Securities, fs)
+	}
+	return fe
+}
+
+func toFixtureDuration(d resolve.Duration) fixtureDuration {
+	fd := fixtureDuration{From: formatFixtureDate(d.StartDate)}
+	if d.EndDate != nil {
+		fd.Until = formatFixtureDate(*d.EndDate)
+	}
+	return fd
+}
+
+func formatFixtureDate(t time.Time) string {
+	t = t.UTC()
+	if t.Equal(t.Truncate(24 * time.Hour)) {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatIdentifiers(identifiers []resolve.Identifier) []string {
+	ids := make([]string, 0, len(identifiers))
+	for _, idn := range identifiers {
+		ids = append(ids, string(idn.Type)+":"+idn.Value)
+	}
+	return ids
+}
+
+func formatLookup(lookup resolve.Lookup) string {
+	s := formatIdentifiers([]resolve.Identifier{lookup.Identifier})[0]
+	if lookup.Date != nil {
+		s += "@" + formatFixtureDate(*lookup.Date)
+	}
+	return s
+}
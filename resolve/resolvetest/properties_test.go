@@ -0,0 +1,107 @@
+package resolvetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// endInclusiveStore resolves lookups a second early, so that durations exclude
+// their start and include their end.
+type endInclusiveStore struct {
+	oracleStore
+}
+
+func (s *endInclusiveStore) LookupEntities(_ context.Context, lookups []resolve.Lookup) ([]resolve.LookupResult, error) {
+	var results []resolve.LookupResult
+	for _, lookup := range lookups {
+		if lookup.Date != nil {
+			date := lookup.Date.Add(-time.Second)
+			lookup.Date = &date
+		}
+		results = append(results, ExpectedResults(s.entities, lookup, s.now)...)
+	}
+	return results, nil
+}
+
+func TestProperties(t *testing.T) {
+	newStore := func(t *testing.T, now time.Time) Store {
+		return &oracleStore{now: now}
+	}
+	RunProperties(t, newStore, PropertyConfig{})
+	// undated lookups resolve at the store's time, here amid the histories
+	RunProperties(t, newStore, PropertyConfig{Now: propertyEpoch.AddDate(0, 0, 5)})
+}
+
+func TestCheckProperties_Counterexample(t *testing.T) {
+	newStore := func(now time.Time) (Store, error) {
+		return &endInclusiveStore{oracleStore{now: now}}, nil
+	}
+	c, err := CheckProperties(context.Background(), newStore, PropertyConfig{})
+	require.NoError(t, err)
+	require.NotNil(t, c)
+
+	// shrunk until removing anything more passes
+	require.Len(t, c.Entities, 1, "%s", c)
+	for _, candidate := range shrinkCandidates(c.Entities) {
+		_, violation, err := checkCase(context.Background(), newStore, c.Now, candidate, []resolve.Lookup{c.Lookup})
+		require.NoError(t, err)
+		require.Empty(t, violation, "%s", c)
+	}
+
+	// which is a fixture
+	data, err := c.Fixture()
+	require.NoError(t, err)
+	f, err := ParseFixture(data)
+	require.NoError(t, err, "%s", data)
+	require.Len(t, f.Lookups, 1)
+	require.Equal(t, c.Lookup, f.Lookups[0].Lookup)
+	require.Equal(t, c.Entities[0].ID, f.Entities[0].ID)
+
+	// and reproduced by its seed
+	again, err := CheckProperties(context.Background(), newStore, PropertyConfig{Seed: c.Seed, Cases: 1})
+	require.NoError(t, err)
+	require.Equal(t, c, again)
+}
+
+// An undated counterexample found at another time than DefaultNow is written
+// dated at that time, so the fixture reproduces it.
+func TestCounterexample_FixtureUndated(t *testing.T) {
+	now := propertyEpoch.AddDate(0, 0, 5)
+	until := propertyEpoch.AddDate(0, 0, 10)
+	entity := &resolve.Entity{
+		ID: uuid.Must(uuid.NewV4()),
+		Identifiers: []resolve.DetailDuration[[]resolve.Identifier]{{
+			Detail:   []resolve.Identifier{{Type: "isin", Value: "1"}},
+			Duration: resolve.Duration{StartDate: propertyEpoch, EndDate: &until},
+		}},
+	}
+	c := &Counterexample{
+		Seed:     1,
+		Entities: []*resolve.Entity{entity},
+		Lookup:   resolve.Lookup{Identifier: resolve.Identifier{Type: "isin", Value: "1"}},
+		Now:      now,
+	}
+
+	data, err := c.Fixture()
+	require.NoError(t, err)
+	f, err := ParseFixture(data)
+	require.NoError(t, err, "%s", data)
+	require.Equal(t, &now, f.Lookups[0].Lookup.Date)
+	require.Len(t, f.Lookups[0].Expect, 1, "%s", data)
+	RunFixture(t, &oracleStore{now: DefaultNow}, f)
+
+	// and left undated at DefaultNow
+	c.Now = DefaultNow
+	data, err = c.Fixture()
+	require.NoError(t, err)
+	f, err = ParseFixture(data)
+	require.NoError(t, err, "%s", data)
+	require.Nil(t, f.Lookups[0].Lookup.Date)
+	require.Empty(t, f.Lookups[0].Expect, "%s", data)
+}
@@ -10,27 +10,146 @@ import (
 	"github.com/gofrs/uuid"
 )
 
+// DefaultNow is the reference time of a DataGen without WithNow, so that a
+// seed always generates the same data.
+var DefaultNow = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// DataGen generates entities and lookups of them. The same seed and options
+// generate the same data.
 type DataGen struct {
 	*gofakeit.Faker
 	now      time.Time
 	entityID int // incremental
 	assetID  int // incremental
 
-	// keep track of what identifiers can be used for lookups
-	resolveIDs  []string
-	fsEntityIDs []string
-	isins       []string
-	cusips      []string
+	historyYears            int
+	maxNameChanges          int
+	maxCountryChanges       int
+	maxSecurities           int
+	maxSecurityChanges      int
+	identifierDropRate      float64
+	securityIdentifierRates map[resolve.IdentifierType]float64
+
+	// entities generated so far, which lookups are expected to find, unless
+	// dropEntities
+	entities     []*resolve.Entity
+	dropEntities bool
+
+	// securities sold by entities, for later entities to acquire
+	transfers []securityTransfer
+
+	// identifiers of the first indexed entities, to generate lookups of
+	held       map[resolve.Identifier]*heldIdentifier
+	heldByType map[resolve.IdentifierType][]*heldIdentifier
+	indexed    int
+}
+
+// Option configures a DataGen.
+type Option func(*DataGen)
+
+// WithNow sets the reference time, which is when histories end, instead of
+// DefaultNow.
+func WithNow(now time.Time) Option {
+	return func(g *DataGen) {
+		g.now = now.UTC()
+	}
+}
+
+// WithHistoryYears sets how many years before the reference time entities
+// may start, 10 by default.
+func WithHistoryYears(years int) Option {
+	return func(g *DataGen) {
+		g.historyYears = years
+	}
+}
+
+// WithoutLookups stops g keeping the entities it generates, which lookups are
+// generated of and expected results computed from, so that generating more
+// entities than fit in memory, e.g. for neo4j-admin import, doesn't hold them
+// all. NewLookupsWith then only generates lookups of unknown identifiers, and
+// expected lookups don't expect any matches.
+func WithoutLookups() Option {
+	return func(g *DataGen) {
+		g.dropEntities = true
+	}
+}
+
+// WithMaxNameChanges sets the maximum number of times an entity's name
+// changes, 2 by default.
+func WithMaxNameChanges(n int) Option {
+	return func(g *DataGen) {
+		g.maxNameChanges = n
+	}
 }
 
-func NewDataGen(seed int64) *DataGen {
+// WithMaxCountryChanges sets the maximum number of times an entity's country
+// changes, 1 by default.
+func WithMaxCountryChanges(n int) Option {
+	return func(g *DataGen) {
+		g.maxCountryChanges = n
+	}
+}
+
+// WithMaxSecurities sets the maximum number of securities of an entity, 4 by
+// default.
+func WithMaxSecurities(n int) Option {
+	return func(g *DataGen) {
+		g.maxSecurities = n
+	}
+}
+
+// WithMaxSecurityChanges sets the maximum number of changes to an entity's
+// securities, 3 by default.
+func WithMaxSecurityChanges(n int) Option {
+	return func(g *DataGen) {
+		g.maxSecurityChanges = n
+	}
+}
+
+// WithIdentifierDropRate sets the probability that an entity's sray_entity_id
+// and fs_entity_id are dropped during its history, 0.3 by default.
+func WithIdentifierDropRate(p float64) Option {
+	return func(g *DataGen) {
+		g.identifierDropRate = p
+	}
+}
+
+// WithSecurityIdentifierRate sets the probability that a security has an
+// identifier of the type, which is one of asset_id, isin and cusip. Each is
+// 0.8 by default.
+func WithSecurityIdentifierRate(typ resolve.IdentifierType, p float64) Option {
+	return func(g *DataGen) {
+		g.securityIdentifierRates[typ] = p
+	}
+}
+
+func NewDataGen(seed int64, opts ...Option) *DataGen {
 	g := DataGen{
-		Faker: gofakeit.New(seed),
-		now:   time.Now().UTC(),
+		Faker:              gofakeit.New(seed),
+		now:                DefaultNow,
+		historyYears:       10,
+		maxNameChanges:     2,
+		maxCountryChanges:  1,
+		maxSecurities:      4,
+		maxSecurityChanges: 3,
+		identifierDropRate: 0.3,
+		securityIdentifierRates: map[resolve.IdentifierType]float64{
+			"asset_id": 0.8,
+			"isin":     0.8,
+			"cusip":    0.8,
+		},
+	}
+	for _, opt := range opts {
+		opt(&g)
 	}
 	return &g
 }
 
+// Now returns the reference time, which is when histories end.
+func (g *DataGen) Now() time.Time {
+	return g.now
+}
+
 func (g *DataGen) UUID() uuid.UUID {
 	return uuid.FromStringOrNil(g.Faker.UUID())
 }
@@ -44,13 +163,16 @@ func (g *DataGen) NewEntities(n int) []*resolve.Entity {
 }
 
 func (g *DataGen) NewEntity() *resolve.Entity {
-	from := g.DateRange(g.now.AddDate(-10, 0, 0), g.now).Truncate(24 * time.Hour)
+	from := g.DateRange(g.now.AddDate(-g.historyYears, 0, 0), g.now).Truncate(24 * time.Hour)
 	e := resolve.Entity{
 		ID:          g.UUID(),
-		Name:        g.NewEntityNameDurations(g.IntRange(0, 2), from),
-		Country:     g.NewEntityCountryDurations(g.IntRange(0, 1), from),
+		Name:        g.NewEntityNameDurations(g.IntRange(0, g.maxNameChanges), from),
+		Country:     g.NewEntityCountryDurations(g.IntRange(0, g.maxCountryChanges), from),
 		Identifiers: g.NewIdentifiersDurations(from),
-		Securities:  g.NewSecuritiesDurations(4, from),
+		Securities:  g.NewSecuritiesDurations(g.maxSecurities, from),
+	}
+	if !g.dropEntities {
+		g.entities = append(g.entities, &e)
 	}
 	return &e
 }
@@ -103,7 +225,7 @@ func (g *DataGen) NewIdentifiersDurations(from time.Time) []resolve.DetailDurati
 	initialIdentifiers := []resolve.Identifier{
 		{
 			Type:  "resolve_id",
-			Value: g.newResolveID(),
+			Value: g.UUID().String(),
 		},
 		{
 			Type:  "sray_entity_id",
@@ -112,7 +234,7 @@ func (g *DataGen) NewIdentifiersDurations(from time.Time) []resolve.DetailDurati
 		{
 			Type: "fs_entity_id",
 			// zero pad sray entity id and add suffix, to retain 1-1 mapping
-			Value: g.newFSEntityID(srayEntityID),
+			Value: fsEntityID(srayEntityID),
 		},
 	}
 
@@ -122,11 +244,12 @@ func (g *DataGen) NewIdentifiersDurations(from time.Time) []resolve.DetailDurati
 	})
 
 	// random chance to drop the sray_entity_id + fs_entity_id
-	if f := g.Float32Range(0, 1); f < 0.3 {
+	if f := g.Float32Range(0, 1); f < float32(g.identifierDropRate) {
 		until := g.DateRange(from, g.now).Truncate(24 * time.Hour)
 		identifiersDurations[0].Duration.EndDate = &until
 
-		updatedIdentifiers := initialIdentifiers[:1]
+		// copy rather than reslice, so the durations don't share a backing array
+		updatedIdentifiers := []resolve.Identifier{initialIdentifiers[0]}
 		identifiersDurations = append(identifiersDurations, resolve.DetailDuration[[]resolve.Identifier]{
 			Detail:   updatedIdentifiers,
 			Duration: resolve.Duration{StartDate: until},
@@ -136,55 +259,26 @@ func (g *DataGen) NewIdentifiersDurations(from time.Time) []resolve.DetailDurati
 	return identifiersDurations
 }
 
-func (g *DataGen) NewSecuritiesDurations(maxSecurities int, from time.Time) []resolve.DetailDuration[[]resolve.Security] {
-	securitiesDurations := make([]resolve.DetailDuration[[]resolve.Security], 0, 1)
-
-	securitiesCount := g.IntRange(0, maxSecurities)
-	if securitiesCount == 0 {
-		return nil
-	}
-
-	initialSecurities := make([]resolve.Security, 0, securitiesCount)
-
-	primary := g.IntRange(0, securitiesCount)
-	for i := 0; i < securitiesCount; i++ {
-		initialSecurities = append(initialSecurities, resolve.Security{
-			Name:        fmt.Sprintf("%s %s", g.Adjective(), g.Noun()),
-			Identifiers: g.NewSecurityIdentifiers(),
-			IsPrimary:   primary == i,
-		})
-	}
-
-	securitiesDurations = append(securitiesDurations, resolve.DetailDuration[[]resolve.Security]{
-		Detail:   initialSecurities,
-		Duration: resolve.Duration{StartDate: from},
-	})
-
-	// TODO - add changes to securities
-
-	return securitiesDurations
-}
-
 func (g *DataGen) NewSecurityIdentifiers() []resolve.Identifier {
 	var identifiers []resolve.Identifier
 
 	// random chance to add identifiers
-	if f := g.Float32Range(0, 1); f < 0.8 {
+	if f := g.Float32Range(0, 1); f < float32(g.securityIdentifierRates["asset_id"]) {
 		identifiers = append(identifiers, resolve.Identifier{
 			Type:  "asset_id",
 			Value: g.newAssetID(),
 		})
 	}
-	if f := g.Float32Range(0, 1); f < 0.8 {
+	if f := g.Float32Range(0, 1); f < float32(g.securityIdentifierRates["isin"]) {
 		identifiers = append(identifiers, resolve.Identifier{
 			Type:  "isin",
-			Value: g.newIsin(),
+			Value: g.isin(),
 		})
 	}
-	if f := g.Float32Range(0, 1); f < 0.8 {
+	if f := g.Float32Range(0, 1); f < float32(g.securityIdentifierRates["cusip"]) {
 		identifiers = append(identifiers, resolve.Identifier{
 			Type:  "cusip",
-			Value: g.newCusip(),
+			Value: g.Cusip(),
 		})
 	}
 
@@ -230,8 +324,10 @@ func (g *DataGen) NewLookups(n, maxEntityCount int, date time.Time) []resolve.Lo
 		// }
 		lookupsMap[identifier] = struct{}{}
 
+		// copy the date, so each lookup has its own rather than the last one
+		lookupDate := date
 		lookup := resolve.Lookup{
-			Date:       &date,
+			Date:       &lookupDate,
 			Identifier: identifier,
 		}
 		lookups = append(lookups, lookup)
@@ -239,28 +335,48 @@ func (g *DataGen) NewLookups(n, maxEntityCount int, date time.Time) []resolve.Lo
 	return lookups
 }
 
-func (g *DataGen) newIsin() string {
-	isin := g.Isin()
-	g.isins = append(g.isins, isin)
-	return isin
+// NewExpectedLookups generates lookups as NewLookups, each with the results
+// expected from a store holding the entities generated so far by g.
+func (g *DataGen) NewExpectedLookups(n, maxEntityCount int, date time.Time) []ExpectedLookup {
+	return g.expectLookups(g.NewLookups(n, maxEntityCount, date))
 }
 
-func (g *DataGen) newCusip() string {
-	cusip := g.Cusip()
-	g.cusips = append(g.cusips, cusip)
-	return cusip
+// isin returns an ISIN with a CUSIP as its national number. Faker.Isin picks
+// the country with the global faker, so isn't repeatable.
+func (g *DataGen) isin() string {
+	isin := g.CountryAbr() + g.Cusip()
+	return isin + fmt.Sprint(isinCheckDigit(isin))
 }
 
-func (g *DataGen) newResolveID() string {
-	id := g.UUID().String()
-	g.resolveIDs = append(g.resolveIDs, id)
-	return id
+// isinCheckDigit returns the Luhn check digit of the ISIN with letters as their
+// two digit values, doubling every other digit from the right.
+func isinCheckDigit(isin string) int {
+	var digits []int
+	for _, c := range isin {
+		if c >= 'A' && c <= 'Z' {
+			v := int(c-'A') + 10
+			digits = append(digits, v/10, v%10)
+		} else {
+			digits = append(digits, int(c-'0'))
+		}
+	}
+
+	var sum int
+	for i := range digits {
+		d := digits[len(digits)-1-i]
+		if i%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
 }
 
-func (g *DataGen) newFSEntityID(srayEntityID string) string {
-	id := fmt.Sprintf("%06s-E", srayEntityID)
-	g.fsEntityIDs = append(g.fsEntityIDs, id)
-	return id
+func fsEntityID(srayEntityID string) string {
+	return fmt.Sprintf("%06s-E", srayEntityID)
 }
 
 func (g *DataGen) newSrayEntityID() string {
@@ -0,0 +1,74 @@
+package resolvetest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataGen_Deterministic(t *testing.T) {
+	generate := func(seed int64, opts ...Option) string {
+		gen := NewDataGen(seed, opts...)
+		entities := gen.NewEntities(100)
+		lookups := gen.NewLookupsWith(100, LookupConfig{Unknown: 0.1, Dates: DateMix{Inside: 1, Before: 1, After: 1, Undated: 1}, Duplicates: 0.1})
+		data, err := json.Marshal([]any{entities, lookups})
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	require.Equal(t, generate(1), generate(1))
+
+	now := WithNow(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, generate(1, now, WithMaxSecurities(2)), generate(1, now, WithMaxSecurities(2)))
+
+	require.NotEqual(t, generate(1), generate(2))
+	require.NotEqual(t, generate(1), generate(1, now))
+}
+
+func TestDataGen_WithoutLookups(t *testing.T) {
+	gen := NewDataGen(1, WithoutLookups())
+	gen.NewEntities(100)
+	require.Empty(t, gen.entities)
+
+	// the same entities are generated either way
+	require.Equal(t, NewDataGen(2).NewEntities(10), NewDataGen(2, WithoutLookups()).NewEntities(10))
+}
+
+func TestDataGen_Options(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	gen := NewDataGen(1,
+		WithNow(now),
+		WithHistoryYears(1),
+		WithMaxNameChanges(0),
+		WithMaxCountryChanges(3),
+		WithMaxSecurities(1),
+		WithIdentifierDropRate(1),
+		WithSecurityIdentifierRate("isin", 0),
+	)
+	require.Equal(t, now, gen.Now())
+
+	var countryChanges bool
+	for _, e := range gen.NewEntities(100) {
+		require.False(t, e.Name[0].Duration.StartDate.Before(now.AddDate(-1, 0, -1)))
+		require.Len(t, e.Name, 1)
+		countryChanges = countryChanges || len(e.Country) > 2
+		require.Len(t, e.Identifiers, 2)
+
+		for _, sd := range e.Securities {
+			require.LessOrEqual(t, len(sd.Detail), 1)
+			for _, sec := range sd.Detail {
+				for _, idn := range sec.Identifiers {
+					require.NotEqual(t, "isin", string(idn.Type))
+				}
+			}
+		}
+	}
+	require.True(t, countryChanges)
+}
+
+func TestIsinCheckDigit(t *testing.T) {
+	require.Equal(t, 5, isinCheckDigit("US037833100"))
+	require.Equal(t, 6, isinCheckDigit("GB000263494"))
+}
@@ -0,0 +1,186 @@
+package resolvetest
+
+import (
+	"fmt"
+	"time"
+
+	"neo4j-starter/resolve"
+)
+
+// maxTransfers bounds the securities waiting to be acquired, dropping the
+// oldest, which are then delisted rather than sold.
+const maxTransfers = 100
+
+// securityTransfer is a security sold by an entity, which an entity generated
+// later may acquire from the date of the sale, e.g. after M&A.
+type securityTransfer struct {
+	security resolve.Security
+	date     time.Time
+}
+
+// kinds of change to an entity's securities, in the order of their weights
+const (
+	securityListing = iota
+	securityDelisting
+	securityPrimarySwitch
+	securityIdentifierChange
+	securitySale
+	securityAcquisition
+	securityChangeKinds
+)
+
+// NewSecuritiesDurations generates the history of an entity's securities from
+// the date, with up to maxSecurities at a time. Securities are listed and
+// delisted, the primary security switches, securities get new identifiers,
+// and are sold to, or acquired from, other entities generated by g. Each
+// change ends a duration and starts the next on the same day, and there is no
+// duration while the entity has no securities.
+func (g *DataGen) NewSecuritiesDurations(maxSecurities int, from time.Time) []resolve.DetailDuration[[]resolve.Security] {
+	var securitiesDurations []resolve.DetailDuration[[]resolve.Security]
+	set := func(securities []resolve.Security, date time.Time) {
+		if n := len(securitiesDurations); n > 0 && securitiesDurations[n-1].Duration.EndDate == nil {
+			until := date
+			securitiesDurations[n-1].Duration.EndDate = &until
+		}
+		if len(securities) > 0 {
+			securitiesDurations = append(securitiesDurations, resolve.DetailDuration[[]resolve.Security]{
+				Detail:   securities,
+				Duration: resolve.Duration{StartDate: date},
+			})
+		}
+	}
+
+	securitiesCount := g.IntRange(0, maxSecurities)
+	securities := make([]resolve.Security, 0, securitiesCount)
+	primary := g.IntRange(0, securitiesCount)
+	for i := 0; i < securitiesCount; i++ {
+		securities = append(securities, g.newSecurity(primary == i))
+	}
+	set(securities, from)
+
+	date := from
+	for changes := g.IntRange(0, g.maxSecurityChanges); changes > 0; changes-- {
+		transfer := g.transferAfter(date)
+
+		weights := make([]float64, securityChangeKinds)
+		if len(securities) < maxSecurities {
+			weights[securityListing] = 2
+			if transfer >= 0 {
+				weights[securityAcquisition] = 1
+			}
+		}
+		if len(securities) > 0 {
+			weights[securityDelisting] = 1
+			weights[securityIdentifierChange] = 1
+			weights[securitySale] = 1
+		}
+		if len(securities) > 1 {
+			weights[securityPrimarySwitch] = 1
+		}
+		change := g.pick(weights)
+		if change < 0 {
+			break
+		}
+
+		// an acquisition is dated by the sale
+		next := date
+		if change == securityAcquisition {
+			next = g.transfers[transfer].date
+		} else if g.now.After(date) {
+			next = g.DateRange(date, g.now).Truncate(24 * time.Hour)
+		}
+		if !next.After(date) {
+			// no days left for changes
+			break
+		}
+
+		// copy rather than modify, so the durations don't share securities
+		updated := append([]resolve.Security(nil), securities...)
+		var i int
+		if len(updated) > 0 {
+			i = g.IntRange(0, len(updated)-1)
+		}
+		switch change {
+		case securityListing:
+			updated = append(updated, g.newSecurity(false))
+		case securityDelisting:
+			updated = append(updated[:i], updated[i+1:]...)
+		case securityPrimarySwitch:
+			// to a security that isn't already primary
+			if updated[i].IsPrimary {
+				i = (i + 1) % len(updated)
+			}
+			for j := range updated {
+				updated[j].IsPrimary = j == i
+			}
+		case securityIdentifierChange:
+			updated[i].Identifiers = g.changeSecurityIdentifiers(updated[i].Identifiers)
+		case securitySale:
+			sold := updated[i]
+			sold.IsPrimary = false
+			updated = append(updated[:i], updated[i+1:]...)
+			g.transfers = append(g.transfers, securityTransfer{security: sold, date: next})
+			if len(g.transfers) > maxTransfers {
+				g.transfers = g.transfers[1:]
+			}
+		case securityAcquisition:
+			updated = append(updated, g.transfers[transfer].security)
+			g.transfers = append(g.transfers[:transfer], g.transfers[transfer+1:]...)
+		}
+
+		set(updated, next)
+		securities = updated
+		date = next
+	}
+
+	return securitiesDurations
+}
+
+func (g *DataGen) newSecurity(primary bool) resolve.Security {
+	return resolve.Security{
+		Name:        fmt.Sprintf("%s %s", g.Adjective(), g.Noun()),
+		Identifiers: g.NewSecurityIdentifiers(),
+		IsPrimary:   primary,
+	}
+}
+
+// transferAfter returns the index of the first security sold after the date,
+// or -1 if there is none.
+func (g *DataGen) transferAfter(date time.Time) int {
+	for i, t := range g.transfers {
+		if t.date.After(date) && t.date.Before(g.now) {
+			return i
+		}
+	}
+	return -1
+}
+
+// changeSecurityIdentifiers returns the identifiers with a new isin and cusip,
+// e.g. after a corporate action, keeping the asset_id. A security without
+// either gets a new asset_id instead.
+func (g *DataGen) changeSecurityIdentifiers(identifiers []resolve.Identifier) []resolve.Identifier {
+	changed := make([]resolve.Identifier, 0, len(identifiers)+1)
+	var replaced bool
+	for _, idn := range identifiers {
+		switch idn.Type {
+		case "isin":
+			idn.Value = g.isin()
+			replaced = true
+		case "cusip":
+			idn.Value = g.Cusip()
+			replaced = true
+		}
+		changed = append(changed, idn)
+	}
+	if replaced {
+		return changed
+	}
+
+	changed = changed[:0]
+	for _, idn := range identifiers {
+		if idn.Type != "asset_id" {
+			changed = append(changed, idn)
+		}
+	}
+	return append(changed, resolve.Identifier{Type: "asset_id", Value: g.newAssetID()})
+}
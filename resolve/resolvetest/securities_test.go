@@ -0,0 +1,115 @@
+package resolvetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataGen_NewSecuritiesDurations_Dates(t *testing.T) {
+	gen := NewDataGen(1)
+
+	for _, e := range gen.NewEntities(1000) {
+		from := e.Name[0].Duration.StartDate
+		for i, sd := range e.Securities {
+			require.NotEmpty(t, sd.Detail)
+			require.False(t, sd.Duration.StartDate.Before(from), "starts before the previous duration ends")
+			if sd.Duration.EndDate != nil {
+				require.True(t, sd.Duration.EndDate.After(sd.Duration.StartDate), "empty duration")
+				require.True(t, sd.Duration.EndDate.Before(gen.Now()))
+				from = *sd.Duration.EndDate
+			} else {
+				require.Equal(t, len(e.Securities)-1, i, "only the last duration is open")
+			}
+
+			var primaries int
+			for _, sec := range sd.Detail {
+				if sec.IsPrimary {
+					primaries++
+				}
+			}
+			require.LessOrEqual(t, primaries, 1)
+		}
+	}
+}
+
+func TestDataGen_NewSecuritiesDurations_Changes(t *testing.T) {
+	gen := NewDataGen(1)
+	entities := gen.NewEntities(1000)
+
+	key := func(sec resolve.Security) string {
+		return fmt.Sprint(sec.Name, sec.Identifiers)
+	}
+	type ended struct {
+		security string
+		date     time.Time
+	}
+
+	changes := map[string]int{}
+	endedBy := map[ended]*resolve.Entity{}
+	for _, e := range entities {
+		for i, sd := range e.Securities {
+			next := map[string]resolve.Security{}
+			for _, sec := range sd.Detail {
+				next[sec.Name] = sec
+			}
+
+			var prev map[string]resolve.Security
+			if i > 0 && sd.Duration.StartDate.Equal(*e.Securities[i-1].Duration.EndDate) {
+				prev = map[string]resolve.Security{}
+				for _, sec := range e.Securities[i-1].Detail {
+					prev[sec.Name] = sec
+				}
+			}
+			for name, sec := range prev {
+				n, ok := next[name]
+				switch {
+				case !ok:
+					changes["removed"]++
+					endedBy[ended{key(sec), sd.Duration.StartDate}] = e
+				case fmt.Sprint(n.Identifiers) != fmt.Sprint(sec.Identifiers):
+					changes["identifiers"]++
+				case n.IsPrimary && !sec.IsPrimary:
+					changes["primary"]++
+				}
+			}
+			for name := range next {
+				if _, ok := prev[name]; !ok && i > 0 {
+					changes["added"]++
+				}
+			}
+			if i > 0 && sd.Duration.StartDate.After(*e.Securities[i-1].Duration.EndDate) {
+				changes["relisted"]++
+			}
+		}
+	}
+
+	// securities sold by one entity are acquired by another on the same day
+	for _, e := range entities {
+		for _, sd := range e.Securities {
+			for _, sec := range sd.Detail {
+				if seller, ok := endedBy[ended{key(sec), sd.Duration.StartDate}]; ok && seller != e {
+					changes["moved"]++
+				}
+			}
+		}
+	}
+
+	for _, change := range []string{"removed", "identifiers", "primary", "added", "relisted", "moved"} {
+		require.Positive(t, changes[change], change)
+	}
+}
+
+func TestDataGen_WithMaxSecurityChanges(t *testing.T) {
+	gen := NewDataGen(1, WithMaxSecurityChanges(0))
+	for _, e := range gen.NewEntities(100) {
+		require.LessOrEqual(t, len(e.Securities), 1)
+		for _, sd := range e.Securities {
+			require.Nil(t, sd.Duration.EndDate)
+		}
+	}
+}
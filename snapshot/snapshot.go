@@ -0,0 +1,351 @@
+// Package snapshot reads and writes a portable, versioned JSONL snapshot of
+// entities with their full history.
+//
+// The first line is a header with the format and version, followed by one line
+// per entity, and a final line with the entity count so that truncated files
+// are detected on restore.
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"neo4j-starter/ingest"
+	"neo4j-starter/resolve"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	Format  = "neo4j-starter/snapshot"
+	Version = 1
+)
+
+var ErrTruncated = errors.New("snapshot is truncated")
+
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Source is implemented by n4j.Adapter.
+type Source interface {
+	ExportEntities(ctx context.Context, pageSize int, fn func(*resolve.Entity) error) error
+}
+
+// Export writes every entity in src to w, returning the number of entities
+// written.
+func Export(ctx context.Context, src Source, w io.Writer) (int, error) {
+	sw, err := NewWriter(w, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	err = src.ExportEntities(ctx, 0, sw.Write)
+	if err != nil {
+		return sw.count, fmt.Errorf("export entities: %w", err)
+	}
+
+	if err := sw.Close(); err != nil {
+		return sw.count, err
+	}
+	return sw.count, nil
+}
+
+// Restore recreates the entities in the snapshot through writer, in batches of
+// batchSize.
+func Restore(ctx context.Context, r io.Reader, writer ingest.Writer, batchSize int) (ingest.Stats, error) {
+	sr, err := NewReader(r)
+	if err != nil {
+		return ingest.Stats{}, err
+	}
+
+	im := ingest.NewImporter(writer, ingest.Config{BatchSize: batchSize})
+	return im.Import(ctx, sr, ingest.Checkpoint{})
+}
+
+type Writer struct {
+	w     *bufio.Writer
+	enc   *json.Encoder
+	count int
+}
+
+// NewWriter writes the snapshot header to w. Close must be called to write the
+// footer.
+func NewWriter(w io.Writer, createdAt time.Time) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	sw := &Writer{
+		w:   bw,
+		enc: json.NewEncoder(bw),
+	}
+
+	err := sw.enc.Encode(Header{
+		Format:    Format,
+		Version:   Version,
+		CreatedAt: createdAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+	return sw, nil
+}
+
+func (sw *Writer) Write(e *resolve.Entity) error {
+	if err := sw.enc.Encode(line{entityRecord: toRecord(e)}); err != nil {
+		return fmt.Errorf("write entity %s: %w", e.ID, err)
+	}
+	sw.count++
+	return nil
+}
+
+func (sw *Writer) Close() error {
+	if err := sw.enc.Encode(footer{End: true, Entities: sw.count}); err != nil {
+		return fmt.Errorf("write footer: %w", err)
+	}
+	if err := sw.w.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
+
+// Reader implements ingest.EntityReader.
+type Reader struct {
+	r      *bufio.Reader
+	header Header
+	offset int64
+	count  int
+	done   bool
+}
+
+// NewReader reads and checks the snapshot header.
+func NewReader(r io.Reader) (*Reader, error) {
+	sr := &Reader{r: bufio.NewReaderSize(r, 1<<20)}
+
+	b, err := sr.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if err := json.Unmarshal(b, &sr.header); err != nil {
+		return nil, fmt.Errorf("unmarshal header: %w", err)
+	}
+	if sr.header.Format != Format {
+		return nil, fmt.Errorf("unknown snapshot format %q", sr.header.Format)
+	}
+	if sr.header.Version < 1 || sr.header.Version > Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", sr.header.Version)
+	}
+
+	return sr, nil
+}
+
+func (sr *Reader) Header() Header {
+	return sr.header
+}
+
+func (sr *Reader) readLine() ([]byte, error) {
+	b, err := sr.r.ReadBytes('\n')
+	sr.offset += int64(len(b))
+	if errors.Is(err, io.EOF) && len(b) > 0 {
+		err = nil
+	}
+	return b, err
+}
+
+// Next returns io.EOF after the footer, or ErrTruncated if the input ends
+// before it.
+func (sr *Reader) Next() (*resolve.Entity, int64, error) {
+	if sr.done {
+		return nil, sr.offset, io.EOF
+	}
+
+	b, err := sr.readLine()
+	if errors.Is(err, io.EOF) {
+		return nil, sr.offset, ErrTruncated
+	}
+	if err != nil {
+		return nil, sr.offset, fmt.Errorf("read line: %w", err)
+	}
+
+	var l line
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, sr.offset, fmt.Errorf("unmarshal line at offset %d: %w", sr.offset-int64(len(b)), err)
+	}
+
+	if l.End {
+		sr.done = true
+		if l.Entities != sr.count {
+			return nil, sr.offset, fmt.Errorf("footer has %d entities but read %d", l.Entities, sr.count)
+		}
+		return nil, sr.offset, io.EOF
+	}
+
+	entity, err := l.entityRecord.toEntity()
+	if err != nil {
+		return nil, sr.offset, err
+	}
+	sr.count++
+
+	return entity, sr.offset, nil
+}
+
+// line is either an entity or the footer.
+type line struct {
+	entityRecord
+	footer
+}
+
+type footer struct {
+	End      bool `json:"end,omitempty"`
+	Entities int  `json:"entities,omitempty"`
+}
+
+type entityRecord struct {
+	ID          string              `json:"id,omitempty"`
+	Names       []valueRecord       `json:"names,omitempty"`
+	Countries   []valueRecord       `json:"countries,omitempty"`
+	Identifiers []identifiersRecord `json:"identifiers,omitempty"`
+	Securities  []securitiesRecord  `json:"securities,omitempty"`
+}
+
+type valueRecord struct {
+	Value string     `json:"value"`
+	From  time.Time  `json:"from"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+type identifierRecord struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type identifiersRecord struct {
+	Identifiers []identifierRecord `json:"identifiers"`
+	From        time.Time          `json:"from"`
+	Until       *time.Time         `json:"until,omitempty"`
+}
+
+type securityRecord struct {
+	Name        string             `json:"name"`
+	Primary     bool               `json:"primary,omitempty"`
+	Identifiers []identifierRecord `json:"identifiers,omitempty"`
+}
+
+type securitiesRecord struct {
+	Securities []securityRecord `json:"securities"`
+	From       time.Time        `json:"from"`
+	Until      *time.Time       `json:"until,omitempty"`
+}
+
+func toRecord(e *resolve.Entity) entityRecord {
+	r := entityRecord{ID: e.ID.String()}
+
+	for _, n := range e.Name {
+		r.Names = append(r.Names, valueRecord{
+			Value: n.Detail.Value,
+			From:  n.Duration.StartDate,
+			Until: n.Duration.EndDate,
+		})
+	}
+	for _, c := range e.Country {
+		r.Countries = append(r.Countries, valueRecord{
+			Value: c.Detail.Value,
+			From:  c.Duration.StartDate,
+			Until: c.Duration.EndDate,
+		})
+	}
+	for _, idns := range e.Identifiers {
+		r.Identifiers = append(r.Identifiers, identifiersRecord{
+			Identifiers: toIdentifierRecords(idns.Detail),
+			From:        idns.Duration.StartDate,
+			Until:       idns.Duration.EndDate,
+		})
+	}
+	for _, secs := range e.Securities {
+		securities := make([]securityRecord, 0, len(secs.Detail))
+		for _, sec := range secs.Detail {
+			securities = append(securities, securityRecord{
+				Name:        sec.Name,
+				Primary:     sec.IsPrimary,
+				Identifiers: toIdentifierRecords(sec.Identifiers),
+			})
+		}
+		r.Securities = append(r.Securities, securitiesRecord{
+			Securities: securities,
+			From:       secs.Duration.StartDate,
+			Until:      secs.Duration.EndDate,
+		})
+	}
+
+	return r
+}
+
+func toIdentifierRecords(identifiers []resolve.Identifier) []identifierRecord {
+	if len(identifiers) == 0 {
+		return nil
+	}
+	records := make([]identifierRecord, 0, len(identifiers))
+	for _, idn := range identifiers {
+		records = append(records, identifierRecord{Type: string(idn.Type), Value: idn.Value})
+	}
+	return records
+}
+
+func (r entityRecord) toEntity() (*resolve.Entity, error) {
+	id, err := uuid.FromString(r.ID)
+	if err != nil {
+		return nil, fmt.Errorf("entity id %q: %w", r.ID, err)
+	}
+	e := resolve.Entity{ID: id}
+
+	for _, n := range r.Names {
+		e.Name = append(e.Name, resolve.DetailDuration[resolve.EntityName]{
+			Detail:   resolve.EntityName{Value: n.Value},
+			Duration: resolve.Duration{StartDate: n.From, EndDate: n.Until},
+		})
+	}
+	for _, c := range r.Countries {
+		e.Country = append(e.Country, resolve.DetailDuration[resolve.EntityCountry]{
+			Detail:   resolve.EntityCountry{Value: c.Value},
+			Duration: resolve.Duration{StartDate: c.From, EndDate: c.Until},
+		})
+	}
+	for _, idns := range r.Identifiers {
+		e.Identifiers = append(e.Identifiers, resolve.DetailDuration[[]resolve.Identifier]{
+			Detail:   fromIdentifierRecords(idns.Identifiers),
+			Duration: resolve.Duration{StartDate: idns.From, EndDate: idns.Until},
+		})
+	}
+	for _, secs := range r.Securities {
+		securities := make([]resolve.Security, 0, len(secs.Securities))
+		for _, sec := range secs.Securities {
+			securities = append(securities, resolve.Security{
+				Name:        sec.Name,
+				Identifiers: fromIdentifierRecords(sec.Identifiers),
+				IsPrimary:   sec.Primary,
+			})
+		}
+		e.Securities = append(e.Securities, resolve.DetailDuration[[]resolve.Security]{
+			Detail:   securities,
+			Duration: resolve.Duration{StartDate: secs.From, EndDate: secs.Until},
+		})
+	}
+
+	return &e, nil
+}
+
+func fromIdentifierRecords(records []identifierRecord) []resolve.Identifier {
+	if len(records) == 0 {
+		return nil
+	}
+	identifiers := make([]resolve.Identifier, 0, len(records))
+	for _, r := range records {
+		identifiers = append(identifiers, resolve.Identifier{Type: resolve.IdentifierType(r.Type), Value: r.Value})
+	}
+	return identifiers
+}
@@ -0,0 +1,90 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"neo4j-starter/resolve"
+	"neo4j-starter/resolve/resolvetest"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStore struct {
+	entities []*resolve.Entity
+}
+
+func (s *fakeStore) ExportEntities(_ context.Context, _ int, fn func(*resolve.Entity) error) error {
+	for _, e := range s.entities {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) CreateEntities(_ context.Context, entities []*resolve.Entity) error {
+	s.entities = append(s.entities, entities...)
+	return nil
+}
+
+func TestExportRestore_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	gen := resolvetest.NewDataGen(1)
+	src := &fakeStore{entities: gen.NewEntities(200)}
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, src, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 200, n)
+
+	dst := &fakeStore{}
+	stats, err := Restore(ctx, &buf, dst, 50)
+	require.NoError(t, err)
+	require.Equal(t, 200, stats.Entities)
+	require.Equal(t, 4, stats.Batches)
+
+	require.Equal(t, src.entities, dst.entities)
+}
+
+func TestReader_Header(t *testing.T) {
+	var buf bytes.Buffer
+	createdAt := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	w, err := NewWriter(&buf, createdAt)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	require.Equal(t, Header{Format: Format, Version: Version, CreatedAt: createdAt}, r.Header())
+
+	_, _, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	_, err = NewReader(strings.NewReader(`{"format":"neo4j-starter/snapshot","version":99}` + "\n"))
+	require.ErrorContains(t, err, "unsupported snapshot version 99")
+}
+
+func TestReader_Truncated(t *testing.T) {
+	gen := resolvetest.NewDataGen(1)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, time.Now())
+	require.NoError(t, err)
+	for _, e := range gen.NewEntities(3) {
+		require.NoError(t, w.Write(e))
+	}
+	require.NoError(t, w.Close())
+
+	// drop the footer line
+	lines := strings.SplitAfter(buf.String(), "\n")
+	truncated := strings.Join(lines[:len(lines)-2], "")
+
+	_, err = Restore(context.Background(), strings.NewReader(truncated), &fakeStore{}, 10)
+	require.ErrorIs(t, err, ErrTruncated)
+}